@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// defaultPageLimit : Items returned by admin lists if limit was not requested
+const defaultPageLimit = 50
+
+// maxPageLimit : Most items returned by admin lists at once
+const maxPageLimit = 500
+
+// pageParams : Parses "limit" and "offset" query parameters
+func pageParams(c *gin.Context) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			return
+		}
+		if limit < 1 || limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err == nil && offset < 0 {
+			offset = 0
+		}
+	}
+
+	return
+}
+
+// commitWithAudit : Writes action of current user to the audit log and commits the transaction, rolls it back on errors
+func commitWithAudit(c *gin.Context, tx *gorm.DB, action, targetType string, targetID uint64, details interface{}) error {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if err := models.WriteAudit(tx, userID, action, targetType, targetID, details); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// AdminGetUsers : Send users with names containing "q" query parameter (all if it is not set)
+func AdminGetUsers(c *gin.Context) {
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if users, err := models.SearchUsers(c.Query("q"), limit, offset); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(users))
+	}
+}
+
+// AdminUpdateUser : Change role or plan of user with the specified ID or suspend the user
+func AdminUpdateUser(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var userData models.AdminUpdateUserData
+
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(userData, err))
+		return
+	}
+
+	if targetID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		// Administrators can not lock themselves out
+		if targetID == userID {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewSelfAdminActionError())
+			return
+		}
+
+		if err := database.DB.First(&user, targetID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		if userData.PlanID != nil && *userData.PlanID != 0 {
+			if err := database.DB.First(&models.Plan{}, *userData.PlanID).Error; err != nil {
+				if gorm.IsRecordNotFoundError(err) {
+					err = h.NewPlanNotFoundError()
+				}
+				h.AbortWithError(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		tx := database.DB.Begin()
+		if err := tx.Model(&user).Updates(userData.Changes()).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditUserUpdated, models.AuditTargetUser, user.ID, userData); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(models.AdminUserResponseData{
+				ID:        user.ID,
+				Name:      user.Name,
+				Role:      user.Role,
+				Suspended: user.Suspended,
+				PlanID:    user.PlanID,
+				CreatedAt: user.CreatedAt,
+			}))
+		}
+	}
+}
+
+// AdminDeleteUser : Delete user with the specified ID together with the user's links, domains and webhooks
+func AdminDeleteUser(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+
+	if targetID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if targetID == userID {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewSelfAdminActionError())
+			return
+		}
+
+		if err := database.DB.First(&user, targetID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		tx := database.DB.Begin()
+		if err := models.DeleteUser(tx, user.ID); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditUserDeleted, models.AuditTargetUser, user.ID, gin.H{"name": user.Name}); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
+
+// AdminUnlockUser : Forget failed sign-in attempts of user with the specified ID, so the user can sign in before the lockout ends
+func AdminUnlockUser(c *gin.Context) {
+	var user models.User
+
+	if targetID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.First(&user, targetID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		tx := database.DB.Begin()
+		if err := models.UnlockUser(tx, user.Name); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditUserUnlocked, models.AuditTargetUser, user.ID, gin.H{"name": user.Name}); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
+
+// AdminUpdateShortlink : Disable abusive short link with the specified ID of any user or enable it again
+func AdminUpdateShortlink(c *gin.Context) {
+	var shortlink models.Shortlink
+	var shortlinkData models.AdminUpdateShortlinkData
+
+	if err := c.ShouldBindJSON(&shortlinkData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(shortlinkData, err))
+		return
+	}
+
+	if shortlinkID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.First(&shortlink, shortlinkID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		action := models.AuditLinkEnabled
+		if *shortlinkData.Disabled {
+			action = models.AuditLinkDisabled
+		} else {
+			shortlinkData.Reason = ""
+		}
+
+		tx := database.DB.Begin()
+		if err := tx.Model(&shortlink).Updates(map[string]interface{}{
+			"disabled":        *shortlinkData.Disabled,
+			"disabled_reason": shortlinkData.Reason,
+		}).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, action, models.AuditTargetLink, shortlink.ID, gin.H{"full": shortlink.Full, "reason": shortlinkData.Reason}); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			shortlink.URL = shortlink.PublicURL()
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(shortlink))
+		}
+	}
+}
+
+// AdminGetStats : Send service-wide counters
+func AdminGetStats(c *gin.Context) {
+	if stats, err := models.SystemStats(); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(stats))
+	}
+}
+
+// AdminGetAuditLog : Send latest actions of administrators, optionally filtered by action, actor and target
+func AdminGetAuditLog(c *gin.Context) {
+	var filter models.AuditFilter
+
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(filter, err))
+		return
+	}
+
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if entries, err := models.AuditLog(database.DB, filter, limit, offset); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(entries))
+	}
+}
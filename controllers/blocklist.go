@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetBlocklist : Send all blocklist entries
+func GetBlocklist(c *gin.Context) {
+	entries := make([]models.BlocklistEntry, 0)
+	if err := database.DB.Order("id").Find(&entries).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(entries))
+	}
+}
+
+// AddBlocklistEntry : Block domain or links matching regular expression
+func AddBlocklistEntry(c *gin.Context) {
+	var entryData models.AddBlocklistEntryData
+
+	if err := c.ShouldBindJSON(&entryData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(entryData, err))
+		return
+	}
+
+	entry := models.BlocklistEntry{
+		Kind:    entryData.Kind,
+		Pattern: entryData.Pattern,
+		Reason:  entryData.Reason,
+	}
+
+	if entry.Kind == models.BlocklistDomain {
+		entry.Pattern = h.NormalizeHost(entry.Pattern)
+	} else if _, err := regexp.Compile(entry.Pattern); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidPatternError())
+		return
+	}
+
+	if entry.Pattern == "" {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidPatternError())
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&entry).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := commitWithAudit(c, tx, models.AuditBlocklistAdded, models.AuditTargetBlocklist, entry.ID, entry); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(entry))
+	}
+}
+
+// DeleteBlocklistEntry : Delete blocklist entry with the specified ID
+func DeleteBlocklistEntry(c *gin.Context) {
+	var entry models.BlocklistEntry
+
+	if entryID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.First(&entry, entryID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		tx := database.DB.Begin()
+		if err := tx.Delete(&entry).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditBlocklistDeleted, models.AuditTargetBlocklist, entry.ID, entry); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDomains : Send all custom domains of current user
+func GetDomains(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	domains := make([]models.Domain, 0)
+	if err := database.DB.Where(&models.Domain{OwnerID: userID}).Find(&domains).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(domains))
+	}
+}
+
+// AddDomain : Register custom domain for current user
+func AddDomain(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var domainData models.AddDomainData
+
+	if err := c.ShouldBindJSON(&domainData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(domainData, err))
+		return
+	}
+
+	host := h.NormalizeHost(domainData.Host)
+	if parsedURL, err := url.Parse("//" + host); err != nil || host == "" || parsedURL.Host != host {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidHostError())
+		return
+	}
+	// Links of the default domain would be resolved through the user's domain
+	if models.IsBaseHost(host) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewReservedHostError())
+		return
+	}
+
+	if usage, err := models.DomainQuota(database.DB, userID); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	} else if usage.Full() {
+		h.AbortWithError(c, http.StatusPaymentRequired, h.NewQuotaExceededError("custom domains", usage.Limit))
+		return
+	}
+
+	domain, err := models.NewDomain(host, userID, domainData.DomainAppData)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if dbc := database.DB.Create(&domain); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(domain))
+	}
+}
+
+// UpdateDomain : Update native apps handling short links of custom domain with the specified ID
+func UpdateDomain(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var domain models.Domain
+	var domainData models.UpdateDomainData
+
+	if err := c.ShouldBindJSON(&domainData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(domainData, err))
+		return
+	}
+
+	if domainID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Domain{ID: domainID, OwnerID: userID}).First(&domain).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		domain.DomainAppData = domainData.DomainAppData
+		if dbc := database.DB.Save(&domain); dbc.Error != nil {
+			h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(domain))
+		}
+	}
+}
+
+// VerifyDomain : Verify custom domain with the specified ID by TXT record with its verification token.
+// Short links are served on the domain only after it is verified
+func VerifyDomain(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var domain models.Domain
+
+	if domainID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Domain{ID: domainID, OwnerID: userID}).First(&domain).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		if err := models.VerifyDomain(c.Request.Context(), database.DB, &domain); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(domain))
+		}
+	}
+}
+
+// GetAppleAppSiteAssociation : Serve apple-app-site-association of the custom domain the request was sent to
+func GetAppleAppSiteAssociation(c *gin.Context) {
+	if domain, err := models.FindDomainByHost(c.Request.Host); err != nil || domain.AppleAppIDs == "" {
+		h.AbortWithError(c, http.StatusNotFound, h.NewPageNotFoundError())
+	} else {
+		c.JSON(http.StatusOK, domain.AppleAppSiteAssociation())
+	}
+}
+
+// GetAssetLinks : Serve Android assetlinks.json of the custom domain the request was sent to
+func GetAssetLinks(c *gin.Context) {
+	if domain, err := models.FindDomainByHost(c.Request.Host); err != nil || domain.AndroidPackage == "" {
+		h.AbortWithError(c, http.StatusNotFound, h.NewPageNotFoundError())
+	} else {
+		c.JSON(http.StatusOK, domain.AssetLinks())
+	}
+}
+
+// DeleteDomain : Delete custom domain with the specified ID if it has no short links
+func DeleteDomain(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var domain models.Domain
+
+	if domainID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Domain{ID: domainID, OwnerID: userID}).First(&domain).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		var linksCount int
+		if err := database.DB.Model(&models.Shortlink{}).Where("domain_id = ?", domain.ID).Count(&linksCount).Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if linksCount > 0 {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewDomainInUseError())
+			return
+		}
+
+		if dbc := database.DB.Delete(&domain); dbc.Error != nil {
+			h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// oidcLoginTTL : Time the user has to sign in at identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie : Cookie binding state of single sign-on to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// defaultSSOTokenDays : Lifetime of API tokens issued by single sign-on if OIDC_TOKEN_DAYS is not set
+const defaultSSOTokenDays = 30
+
+// ssoTokenTTL : Lifetime of API tokens issued by single sign-on
+func ssoTokenTTL() time.Duration {
+	if days, err := strconv.Atoi(h.GetEnv("OIDC_TOKEN_DAYS", "")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+
+	return defaultSSOTokenDays * 24 * time.Hour
+}
+
+// setOIDCStateCookie : Sets HttpOnly cookie with the state for the callback, or deletes it if the state is empty
+func setOIDCStateCookie(c *gin.Context, state string) {
+	maxAge := int(oidcLoginTTL.Seconds())
+	if state == "" {
+		maxAge = -1
+	}
+
+	path := "/"
+	if redirectURL, err := url.Parse(oidc.Current.RedirectURL); err == nil && redirectURL.Path != "" {
+		path = redirectURL.Path
+	}
+
+	// Lax cookies are sent with the top-level redirect from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, path, "", strings.HasPrefix(oidc.Current.RedirectURL, "https://"), true)
+}
+
+// startOIDCLogin : Stores state, nonce and PKCE verifier of a new single sign-on, binds the state to the browser with a cookie
+// and returns URL of provider's login page
+func startOIDCLogin(c *gin.Context, linkUserID uint64) (string, error) {
+	state, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := oidc.Current.AuthURL(state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	if err := models.CreateOIDCLogin(database.DB, state, nonce, verifier, linkUserID, oidcLoginTTL); err != nil {
+		return "", err
+	}
+	setOIDCStateCookie(c, state)
+
+	return authURL, nil
+}
+
+// ssoEnabled : Responds with error and returns false if identity provider is not configured
+func ssoEnabled(c *gin.Context) bool {
+	if oidc.Current == nil {
+		h.AbortWithError(c, http.StatusNotFound, h.NewSSODisabledError())
+		return false
+	}
+
+	return true
+}
+
+// OIDCLogin : Redirect to identity provider's login page
+func OIDCLogin(c *gin.Context) {
+	if !ssoEnabled(c) {
+		return
+	}
+
+	if authURL, err := startOIDCLogin(c, 0); err != nil {
+		h.AbortWithError(c, http.StatusBadGateway, err)
+	} else {
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback : Finish single sign-on started by OIDCLogin or LinkOIDCIdentity in the same browser.
+// Signing in issues API token for the user, who is created on the first sign-on; linking attaches the identity to the user who started it
+func OIDCCallback(c *gin.Context) {
+	if !ssoEnabled(c) {
+		return
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		if description := c.Query("error_description"); description != "" {
+			reason += ": " + description
+		}
+		h.AbortWithError(c, http.StatusUnauthorized, h.NewSSOFailedError(reason))
+		return
+	}
+
+	// State from another browser means someone tries to finish their own sign-on in the victim's session
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "")
+	if state == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidTokenError())
+		return
+	}
+
+	login, err := models.ConsumeOIDCLogin(database.DB, state)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if login == nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidTokenError())
+		return
+	}
+
+	claims, err := oidc.Current.Exchange(c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		h.AbortWithError(c, http.StatusUnauthorized, h.NewSSOFailedError(err.Error()))
+		return
+	}
+
+	var user models.User
+	tx := database.DB.Begin()
+	if login.LinkUserID != 0 {
+		if err = tx.First(&user, login.LinkUserID).Error; err == nil {
+			err = models.LinkIdentity(tx, user.ID, claims)
+		}
+	} else {
+		var provisioned *models.User
+		if provisioned, err = models.FindOrProvisionUser(tx, claims); err == nil {
+			user = *provisioned
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Identity provider authenticated the user, so two-factor authentication of this service is not asked for.
+	// Users with it enabled are never linked automatically, only identities they linked themselves sign them in
+	if err := user.SignInError(); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusForbidden, err)
+		return
+	}
+
+	if err := models.ApplyGroupRoles(tx, user.ID, claims.Groups); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if login.LinkUserID != 0 {
+		if err := tx.Commit().Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+		return
+	}
+
+	token, apiToken, err := models.CreateAPIToken(tx, user.ID, "Single sign-on", ssoTokenTTL())
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(models.LoginResponseData{Token: token, ExpiresAt: apiToken.ExpiresAt}))
+	}
+}
+
+// LinkOIDCIdentity : Send URL of identity provider's login page, signing in there links the identity to current user.
+// The URL has to be opened in the browser that sent the request, which gets the state cookie
+func LinkOIDCIdentity(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if !ssoEnabled(c) {
+		return
+	}
+
+	if authURL, err := startOIDCLogin(c, userID); err != nil {
+		h.AbortWithError(c, http.StatusBadGateway, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.OIDCLinkResponseData{URL: authURL}))
+	}
+}
+
+// GetOIDCIdentities : Send identity provider accounts linked to current user
+func GetOIDCIdentities(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	identities := make([]models.UserIdentity, 0)
+	if dbc := database.DB.Where(&models.UserIdentity{UserID: userID}).Order("id").Find(&identities); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(identities))
+	}
+}
+
+// DeleteOIDCIdentity : Unlink identity provider account with the specified ID from current user
+func DeleteOIDCIdentity(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	identityID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if dbc := database.DB.Where(&models.UserIdentity{ID: identityID, UserID: userID}).Delete(&models.UserIdentity{}); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else if dbc.RowsAffected == 0 {
+		h.AbortWithError(c, http.StatusNotFound, gorm.ErrRecordNotFound)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
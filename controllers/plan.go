@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// GetUsage : Send usage and limits of current user's plan and of plans of the user's workspaces
+func GetUsage(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if usage, err := models.Usage(database.DB, userID); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(usage))
+	}
+}
+
+// AdminGetPlans : Send all plans
+func AdminGetPlans(c *gin.Context) {
+	plans := make([]models.Plan, 0)
+
+	if dbc := database.DB.Order("id").Find(&plans); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(plans))
+	}
+}
+
+// AdminAddPlan : Create a new plan
+func AdminAddPlan(c *gin.Context) {
+	var plan models.Plan
+	var planData models.PlanData
+
+	if err := c.ShouldBindJSON(&planData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(planData, err))
+		return
+	}
+	planData.Apply(&plan)
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&plan).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := commitWithAudit(c, tx, models.AuditPlanCreated, models.AuditTargetPlan, plan.ID, planData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(plan))
+	}
+}
+
+// AdminUpdatePlan : Replace name and limits of plan with the specified ID
+func AdminUpdatePlan(c *gin.Context) {
+	var plan models.Plan
+	var planData models.PlanData
+
+	if err := c.ShouldBindJSON(&planData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(planData, err))
+		return
+	}
+
+	if planID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.First(&plan, planID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+		planData.Apply(&plan)
+
+		tx := database.DB.Begin()
+		if err := tx.Save(&plan).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditPlanUpdated, models.AuditTargetPlan, plan.ID, planData); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(plan))
+		}
+	}
+}
+
+// AdminSetWorkspacePlan : Set plan covering links of workspace with the specified ID
+func AdminSetWorkspacePlan(c *gin.Context) {
+	var workspace models.Workspace
+	var planData models.SetWorkspacePlanData
+
+	if err := c.ShouldBindJSON(&planData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(planData, err))
+		return
+	}
+
+	if workspaceID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.First(&workspace, workspaceID).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		if *planData.PlanID != 0 {
+			if err := database.DB.First(&models.Plan{}, *planData.PlanID).Error; err != nil {
+				if gorm.IsRecordNotFoundError(err) {
+					err = h.NewPlanNotFoundError()
+				}
+				h.AbortWithError(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		tx := database.DB.Begin()
+		if err := tx.Model(&workspace).Update("plan_id", *planData.PlanID).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := commitWithAudit(c, tx, models.AuditWorkspaceUpdated, models.AuditTargetWorkspace, workspace.ID, planData); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(workspace))
+		}
+	}
+}
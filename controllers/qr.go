@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"image/color"
+	"net/http"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/qrcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultQRSize : Width and height of QR code image in pixels if size was not requested
+const defaultQRSize = 256
+
+// GetShortlinkQR : Returns QR code image (PNG or SVG) of the public URL of short link with the specified ID
+func GetShortlinkQR(c *gin.Context) {
+	var shortlink models.Shortlink
+	var options models.ShortlinkQROptions
+
+	if err := c.ShouldBindQuery(&options); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(options, err))
+		return
+	}
+
+	if !findShortlink(c, database.DB, models.RoleViewer, &shortlink) {
+		return
+	}
+
+	renderOptions, level, err := qrRenderOptions(options)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	code, err := qrcode.Encode(shortlink.QRURL(), level)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if options.Format == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", code.SVG(renderOptions))
+		return
+	}
+
+	image, err := code.PNG(renderOptions)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+// qrRenderOptions : Fills defaults of QR code options and parses colors and error correction level
+func qrRenderOptions(options models.ShortlinkQROptions) (renderOptions qrcode.Options, level qrcode.Level, err error) {
+	renderOptions = qrcode.Options{
+		Size:       defaultQRSize,
+		Margin:     qrcode.DefaultMargin,
+		Foreground: color.Black,
+		Background: color.White,
+	}
+
+	if options.Size != 0 {
+		renderOptions.Size = options.Size
+	}
+	if options.Margin != nil {
+		renderOptions.Margin = *options.Margin
+	}
+	if options.Foreground != "" {
+		if renderOptions.Foreground, err = qrcode.ParseColor(options.Foreground); err != nil {
+			return
+		}
+	}
+	if options.Background != "" {
+		if renderOptions.Background, err = qrcode.ParseColor(options.Background); err != nil {
+			return
+		}
+	}
+
+	level = qrcode.Medium
+	if options.Level != "" {
+		level, err = qrcode.ParseLevel(options.Level)
+	}
+
+	return
+}
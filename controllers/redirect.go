@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/pages"
+	"shorts/visitor"
+	"shorts/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// previewSuffix : Suffix of short link that shows preview page instead of redirecting ("/v1/s/abc+")
+const previewSuffix = "+"
+
+// defaultInterstitialSeconds : Countdown of interstitial page if INTERSTITIAL_SECONDS is not set
+const defaultInterstitialSeconds = 5
+
+// stickyCookieMaxAge : How long visitor is kept on the same destination of A/B split (30 days)
+const stickyCookieMaxAge = 30 * 24 * 60 * 60
+
+// GetShortlinkRedirect : Redirects to a full link by a short link.
+// Short links are resolved within the domain the request was sent to, "+" suffix shows preview instead of redirecting
+func GetShortlinkRedirect(c *gin.Context) {
+	var shortlink models.Shortlink
+
+	short := c.Params.ByName("short")
+	if strings.HasSuffix(short, previewSuffix) {
+		getShortlinkPreview(c, strings.TrimSuffix(short, previewSuffix))
+		return
+	}
+
+	if err := findShortlinkByHost(c.Request.Host, short, &shortlink); err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
+	} else if shortlink.Disabled {
+		h.AbortWithError(c, http.StatusGone, h.NewLinkDisabledError(shortlink.DisabledReason))
+	} else if shortlink.Expired() {
+		h.AbortWithError(c, http.StatusGone, h.NewLinkExpiredError())
+	} else {
+		// Previews built by chat apps and social networks are not counted as uses
+		if visitor.IsUnfurlBot(c.Request.UserAgent()) {
+			serveUnfurl(c, &shortlink)
+			return
+		}
+
+		info := visitor.FromRequest(c.Request, c.ClientIP())
+
+		target := shortlink.Full
+		visitorQuery := c.Request.URL.Query()
+		use := models.ShortlinkUse{LinkID: shortlink.ID, UseTime: info.Time, Source: models.TakeUseSource(visitorQuery), IsBot: info.Bot}
+
+		if rule := shortlink.MatchRule(info); rule != nil {
+			target = rule.Target
+			use.RuleID = rule.ID
+
+			if !info.Bot {
+				if dbc := database.DB.Model(rule).UpdateColumn("hits", gorm.Expr("hits + 1")); dbc.Error != nil {
+					fmt.Println(dbc.Error)
+				}
+			}
+		} else if destination := chooseDestination(c, &shortlink); destination != nil {
+			target = destination.URL
+			use.DestinationID = destination.ID
+		}
+
+		if dbc := database.DB.Create(&use); dbc.Error != nil {
+			fmt.Println(dbc.Error)
+		}
+		if !info.Bot {
+			if err := models.AddVisitor(shortlink.ID, info.Time, visitor.Fingerprint(info)); err != nil {
+				fmt.Println(err)
+			}
+			webhooks.MarkClicked(shortlink.ID)
+		}
+
+		destination := shortlink.BuildDestination(target, visitorQuery)
+
+		// Native app is opened on mobile devices, the destination is used if the app is not installed
+		if appRedirect := shortlink.AppRedirect(info.Device, destination); appRedirect != nil {
+			redirectToApp(c, appRedirect, destination)
+			return
+		}
+
+		if shortlink.Interstitial {
+			renderPage(c, "interstitial", pages.InterstitialData{Title: shortlink.Title, Destination: destination, Seconds: interstitialSeconds()}, destination)
+			return
+		}
+
+		// Browsers cache permanent redirects, so they are used only if every visitor goes to the full link
+		status := http.StatusMovedPermanently
+		if shortlink.Varies() || shortlink.HasDeepLinks() {
+			status = http.StatusFound
+		}
+
+		c.Redirect(status, destination)
+	}
+}
+
+// redirectToApp : Redirects to universal link or intent, or serves a page opening app by custom scheme
+func redirectToApp(c *gin.Context, appRedirect *models.AppRedirect, fallback string) {
+	if appRedirect.Location != "" {
+		c.Redirect(http.StatusFound, appRedirect.Location)
+		return
+	}
+
+	renderPage(c, "app", pages.AppData{AppURI: template.URL(appRedirect.AppURI), Fallback: fallback}, fallback)
+}
+
+// serveUnfurl : Serves configured OpenGraph and Twitter card tags, or redirects bot to the full link to build preview from it
+func serveUnfurl(c *gin.Context, shortlink *models.Shortlink) {
+	destination := shortlink.BuildDestination(shortlink.Full, nil)
+	if !shortlink.HasSocialTags() {
+		c.Redirect(http.StatusFound, destination)
+		return
+	}
+
+	data := pages.UnfurlData{
+		URL:         shortlink.PublicURL(),
+		Title:       shortlink.SocialTitle,
+		Description: shortlink.SocialDescription,
+		Image:       shortlink.SocialImage,
+		TwitterCard: shortlink.TwitterCard,
+		Destination: destination,
+	}
+	if data.Title == "" {
+		data.Title = shortlink.Title
+	}
+	if data.TwitterCard == "" {
+		data.TwitterCard = models.TwitterCardSummary
+	}
+
+	renderPage(c, "unfurl", data, destination)
+}
+
+// getShortlinkPreview : Shows where short link leads and how many times it was used, without redirecting
+func getShortlinkPreview(c *gin.Context, short string) {
+	var shortlink models.Shortlink
+	var shortlinkUse models.ShortlinkUse
+
+	if err := findShortlinkByHost(c.Request.Host, short, &shortlink); err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
+		return
+	}
+	if shortlink.Disabled {
+		h.AbortWithError(c, http.StatusGone, h.NewLinkDisabledError(shortlink.DisabledReason))
+		return
+	}
+	if shortlink.Expired() {
+		h.AbortWithError(c, http.StatusGone, h.NewLinkExpiredError())
+		return
+	}
+
+	usesCount, err := shortlinkUse.LinkUseCount(shortlink.ID, models.UsesFilter{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	page, err := pages.Render("preview", pages.PreviewData{
+		URL:         shortlink.PublicURL(),
+		Title:       shortlink.Title,
+		Destination: shortlink.BuildDestination(shortlink.Full, nil),
+		Varies:      shortlink.Varies(),
+		UsesCount:   usesCount,
+	})
+	if err != nil {
+		h.AbortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
+
+// renderPage : Serves HTML page made from the template, visitor is redirected to the fallback if it can not be rendered
+func renderPage(c *gin.Context, name string, data interface{}, fallback string) {
+	page, err := pages.Render(name, data)
+	if err != nil {
+		fmt.Println(err)
+		c.Redirect(http.StatusFound, fallback)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
+
+// findShortlinkByHost : Finds short link with its rules and destinations within the domain of the host
+func findShortlinkByHost(host, short string, shortlink *models.Shortlink) error {
+	domainID := models.FindDomainIDByHost(host)
+
+	return database.DB.Preload("Rules", orderByPosition).Preload("Destinations").Where("domain_id = ? AND short = ?", domainID, short).First(shortlink).Error
+}
+
+// interstitialSeconds : Countdown of interstitial page before redirect
+func interstitialSeconds() int {
+	if seconds, err := strconv.Atoi(h.GetEnv("INTERSTITIAL_SECONDS", "")); err == nil && seconds >= 0 {
+		return seconds
+	}
+
+	return defaultInterstitialSeconds
+}
+
+// chooseDestination : Picks destination of A/B split. Sticky destinations are remembered in a cookie
+func chooseDestination(c *gin.Context, shortlink *models.Shortlink) *models.ShortlinkDestination {
+	cookieName := "shorts_dst_" + strconv.FormatUint(shortlink.ID, 10)
+
+	if shortlink.StickyDestinations {
+		if value, err := c.Cookie(cookieName); err == nil {
+			if destinationID, err := strconv.ParseUint(value, 10, 64); err == nil {
+				if destination := shortlink.FindDestination(destinationID); destination != nil {
+					return destination
+				}
+			}
+		}
+	}
+
+	destination := shortlink.ChooseDestination()
+	if destination != nil && shortlink.StickyDestinations {
+		c.SetCookie(cookieName, strconv.FormatUint(destination.ID, 10), stickyCookieMaxAge, "/", "", false, true)
+	}
+
+	return destination
+}
+
+// orderByPosition : Preload rules in order of evaluation
+func orderByPosition(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
@@ -1,33 +1,43 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 	"net/url"
 	"shorts/database"
 	h "shorts/helper"
 	"shorts/models"
+	"shorts/slug"
+	"shorts/urlcheck"
+	"shorts/webhooks"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 )
 
-// GetShortlinks : Send all short links of current user
+// GetShortlinks : Send all short links of workspaces current user is member of, optionally only of the specified workspace
 func GetShortlinks(c *gin.Context) {
 	userID := c.MustGet(gin.AuthUserKey).(uint64)
 
 	var shortlinks []models.Shortlink
 	var shortlinksResponse []models.ShortlinkResponseData
-	if err := database.DB.Preload("Uses").Where(&models.Shortlink{OwnerID: userID}).Find(&shortlinks).Error; err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+
+	query := database.DB.Preload("Uses").
+		Where("workspace_id IN (?)", database.DB.Table("workspace_members").Select("workspace_id").Where("user_id = ?", userID).SubQuery())
+	if workspace := c.Query("workspace"); workspace != "" {
+		workspaceID, err := strconv.ParseUint(workspace, 10, 64)
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		query = query.Where(&models.Shortlink{WorkspaceID: workspaceID})
+	}
+
+	if err := query.Find(&shortlinks).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
 	} else {
 		for _, item := range shortlinks {
-			shortlinksResponse = append(shortlinksResponse, models.ShortlinkResponseData{
-				ID:    item.ID,
-				Short: item.Short,
-				Full:  item.Full,
-			})
+			shortlinksResponse = append(shortlinksResponse, item.ResponseData())
 		}
 		c.JSON(http.StatusOK, h.NewResponseOkWithData(shortlinksResponse))
 	}
@@ -40,93 +50,415 @@ func AddShortlink(c *gin.Context) {
 	var shortlinkData models.ShortlinkAddData
 
 	if err := c.ShouldBindJSON(&shortlinkData); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(shortlinkData, err))
 		return
 	}
 
-	if parsedURL, err := url.Parse(shortlinkData.Full); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+	normalizedFull, err := urlcheck.Normalize(shortlinkData.Full)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if parsedURL, err := url.Parse(normalizedFull); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
 	} else {
-		if !parsedURL.IsAbs() {
-			c.JSON(http.StatusBadRequest, h.NewResponseError(h.NewAbsoluteLinksOnlyError()))
+		if err := checkFullLink(c, parsedURL); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if shortlinkData.Short != "" && !h.IsValidShort(shortlinkData.Short) {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidShortError())
+			return
+		}
+
+		if err := shortlinkData.ShortlinkAppData.Validate(); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := prepareDeepLinks(c, &shortlinkData.ShortlinkAppData); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := shortlinkData.ShortlinkSocialData.Validate(); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		// Links are created in personal workspace unless another one is requested
+		if shortlinkData.WorkspaceID == 0 {
+			workspace, err := models.FindPersonalWorkspace(database.DB, userID)
+			if err != nil {
+				h.AbortWithError(c, http.StatusBadRequest, err)
+				return
+			}
+			shortlinkData.WorkspaceID = workspace.ID
+		}
+		if !checkWorkspaceRole(c, shortlinkData.WorkspaceID, userID, models.RoleEditor) {
 			return
 		}
 
 		shortlink := models.Shortlink{
-			OwnerID: userID,
-			Short:   shortlinkData.Short,
-			Full:    shortlinkData.Full,
+			OwnerID:             userID,
+			WorkspaceID:         shortlinkData.WorkspaceID,
+			Short:               shortlinkData.Short,
+			CustomAlias:         shortlinkData.Short != "",
+			Full:                normalizedFull,
+			Title:               shortlinkData.Title,
+			Interstitial:        shortlinkData.Interstitial,
+			ExpiresAt:           shortlinkData.ExpiresAt,
+			ShortlinkQueryData:  shortlinkData.ShortlinkQueryData,
+			ShortlinkAppData:    shortlinkData.ShortlinkAppData,
+			ShortlinkSocialData: shortlinkData.ShortlinkSocialData,
+		}
+
+		if shortlinkData.Domain != "" {
+			var domain models.Domain
+			if err := database.DB.Where(&models.Domain{Host: h.NormalizeHost(shortlinkData.Domain), OwnerID: userID}).First(&domain).Error; err != nil {
+				h.AbortWithError(c, http.StatusBadRequest, h.NewDomainNotFoundError())
+				return
+			}
+			shortlink.DomainID = domain.ID
+		}
+
+		if shortlinkData.ReuseExisting && shortlinkData.Short == "" {
+			var existing models.Shortlink
+			if err := database.DB.Where("workspace_id = ? AND domain_id = ? AND shortlinks.full = ?", shortlink.WorkspaceID, shortlink.DomainID, shortlink.Full).First(&existing).Error; err == nil {
+				c.JSON(http.StatusOK, h.NewResponseOkWithData(existing.ResponseData()))
+				return
+			}
+		}
+
+		// Quota is counted and the link is created while the user and the workspace are locked, so concurrent requests can not exceed it
+		tx := database.DB.Begin()
+		if err := models.LockLinkQuota(tx, userID, shortlink.WorkspaceID); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		usage, err := models.LinkQuota(tx, userID, shortlink.WorkspaceID)
+		if err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := usage.Check(shortlink.CustomAlias); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusPaymentRequired, err)
+			return
 		}
 
-		if dbc := database.DB.Create(&shortlink); dbc.Error != nil {
-			c.JSON(http.StatusBadRequest, h.NewResponseError(dbc.Error))
+		// Generated short links may collide with existing ones, so creating is retried with a new one.
+		// Failed insert aborts the transaction, so each attempt is rolled back to the savepoint
+		var dbc *gorm.DB
+		for attempt := 0; attempt < slug.MaxAttempts; attempt++ {
+			shortlink.ID = 0
+			shortlink.Short = shortlinkData.Short
+
+			tx.Exec("SAVEPOINT create_shortlink")
+			if dbc = tx.Create(&shortlink); !database.IsUniqueViolation(dbc.Error) || shortlinkData.Short != "" {
+				break
+			}
+			tx.Exec("ROLLBACK TO SAVEPOINT create_shortlink")
+		}
+		if dbc.Error != nil {
+			tx.Rollback()
+			if database.IsUniqueViolation(dbc.Error) {
+				h.AbortWithError(c, http.StatusConflict, h.NewShortTakenError())
+			} else {
+				h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+			}
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
 		} else {
-			c.JSON(http.StatusCreated, h.NewResponseOkWithData(models.ShortlinkResponseData{
-				ID:    shortlink.ID,
-				Full:  shortlink.Full,
-				Short: shortlink.Short,
-			}))
+			webhooks.Notify(userID, models.EventLinkCreated, shortlink.ResponseData())
+			c.JSON(http.StatusCreated, h.NewResponseOkWithData(shortlink.ResponseData()))
 		}
 	}
 }
 
-// DeleteShortlink : Delete short link with the specified ID
-func DeleteShortlink(c *gin.Context) {
-	userID := c.MustGet(gin.AuthUserKey).(uint64)
-
+// UpdateShortlink : Update full link, query parameters and deep links of short link with the specified ID
+func UpdateShortlink(c *gin.Context) {
 	var shortlink models.Shortlink
+	var shortlinkData models.ShortlinkUpdateData
+
+	if err := c.ShouldBindJSON(&shortlinkData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(shortlinkData, err))
+		return
+	}
+
+	if !findShortlink(c, database.DB, models.RoleEditor, &shortlink) {
+		return
+	}
+
+	if err := shortlinkData.Validate(); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	appData := shortlinkData.AppData()
+	if err := prepareDeepLinks(c, &appData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if shortlinkData.IOSUniversalLink != nil {
+		shortlinkData.IOSUniversalLink = &appData.IOSUniversalLink
+	}
+
+	if shortlinkData.Full != nil {
+		normalizedFull, err := prepareFullLink(c, *shortlinkData.Full)
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		shortlinkData.Full = &normalizedFull
+	}
 
-	if parseResult, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+	if dbc := database.DB.Model(&shortlink).Updates(shortlinkData.Changes()); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
 	} else {
-		shortlinkID := parseResult
+		webhooks.Notify(shortlink.OwnerID, models.EventLinkUpdated, shortlink.ResponseData())
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(shortlink.ResponseData()))
+	}
+}
 
-		if err := database.DB.Where(&models.Shortlink{ID: shortlinkID, OwnerID: userID}).First(&shortlink).Error; err != nil {
-			c.JSON(http.StatusNotFound, h.NewResponseError(err))
-		} else {
-			if dbc := database.DB.Delete(&shortlink); dbc.Error != nil {
-				c.JSON(http.StatusBadRequest, h.NewResponseError(err))
-			} else {
-				c.JSON(http.StatusOK, h.NewResponseOK())
+// prepareFullLink : Returns normalized full link or error if it is not safe to redirect to
+func prepareFullLink(c *gin.Context, link string) (string, error) {
+	normalizedLink, err := urlcheck.Normalize(link)
+	if err != nil {
+		return "", err
+	}
+
+	parsedURL, err := url.Parse(normalizedLink)
+	if err != nil {
+		return "", err
+	}
+
+	return normalizedLink, checkFullLink(c, parsedURL)
+}
+
+// checkFullLink : Returns error if full link is not safe to redirect to
+func checkFullLink(c *gin.Context, parsedURL *url.URL) error {
+	if !parsedURL.IsAbs() {
+		return h.NewAbsoluteLinksOnlyError()
+	}
+
+	if !urlcheck.IsSchemeAllowed(parsedURL) {
+		return h.NewSchemeNotAllowedError(urlcheck.AllowedSchemes())
+	}
+
+	// Links of other shorteners are checked together with every link they redirect to
+	chain := []*url.URL{parsedURL}
+	if urlcheck.IsShortener(parsedURL) && h.GetEnvBool("RESOLVE_SHORTENERS", false) {
+		resolved, err := urlcheck.ResolveChain(parsedURL)
+		if err != nil {
+			// Details of the failure are not returned, they would tell about internal hosts
+			return h.NewUnresolvableLinkError()
+		}
+		chain = append(chain, resolved...)
+	}
+
+	for _, link := range chain {
+		if models.IsOwnHost(link.Host) || h.NormalizeHost(link.Host) == h.NormalizeHost(c.Request.Host) {
+			return h.NewRedirectLoopError()
+		}
+
+		if entry, err := models.FindBlocklistEntry(link); err != nil {
+			return err
+		} else if entry != nil {
+			return h.NewBlockedLinkError(entry.Reason)
+		}
+
+		if urlcheck.Reputation != nil {
+			if err := urlcheck.Reputation.Check(link); err != nil {
+				return err
 			}
 		}
 	}
+
+	return nil
+}
+
+// prepareDeepLinks : Normalizes universal link and returns error if it or the intent's browser fallback is not safe to redirect to.
+// They are checked like full links, visitors are sent to them instead of the full link
+func prepareDeepLinks(c *gin.Context, appData *models.ShortlinkAppData) error {
+	if appData.IOSUniversalLink != "" {
+		normalizedLink, err := prepareFullLink(c, appData.IOSUniversalLink)
+		if err != nil {
+			return err
+		}
+		appData.IOSUniversalLink = normalizedLink
+	}
+
+	fallback, err := models.AndroidIntentFallback(appData.AndroidIntent)
+	if err != nil {
+		return h.NewInvalidDeepLinkError("androidIntent")
+	}
+	if fallback != "" {
+		parsedURL, err := url.Parse(fallback)
+		if err != nil {
+			return h.NewInvalidDeepLinkError("androidIntent")
+		}
+		if err := checkFullLink(c, parsedURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteShortlink : Delete short link with the specified ID
+func DeleteShortlink(c *gin.Context) {
+	var shortlink models.Shortlink
+
+	if !findShortlink(c, database.DB, models.RoleEditor, &shortlink) {
+		return
+	}
+
+	if dbc := database.DB.Delete(&shortlink); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		webhooks.Notify(shortlink.OwnerID, models.EventLinkDeleted, shortlink.ResponseData())
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
 }
 
 // GetShortlinkInfo : Send information about short link with the specified ID (including uses)
 func GetShortlinkInfo(c *gin.Context) {
-	userID := c.MustGet(gin.AuthUserKey).(uint64)
+	var shortlink models.Shortlink
 
+	if !findShortlink(c, database.DB.Preload("Uses").Preload("Rules", orderByPosition).Preload("Destinations"), models.RoleViewer, &shortlink) {
+		return
+	}
+
+	shortlink.URL = shortlink.PublicURL()
+	c.JSON(http.StatusOK, h.NewResponseOkWithData(shortlink))
+}
+
+// SetShortlinkRules : Replace conditional redirect rules of short link with the specified ID
+func SetShortlinkRules(c *gin.Context) {
 	var shortlink models.Shortlink
-	if parseResult, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
-	} else {
-		shortlinkID := parseResult
+	var rulesData models.ShortlinkRulesData
 
-		if err := database.DB.Preload("Uses").Where(&models.Shortlink{ID: shortlinkID, OwnerID: userID}).Find(&shortlink).Error; err != nil {
-			c.JSON(http.StatusNotFound, h.NewResponseError(err))
-		} else {
-			c.JSON(http.StatusOK, h.NewResponseOkWithData(shortlink))
+	if err := c.ShouldBindJSON(&rulesData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(rulesData, err))
+		return
+	}
+
+	if !findShortlink(c, database.DB, models.RoleEditor, &shortlink) {
+		return
+	}
+
+	rules := make([]models.ShortlinkRule, 0, len(rulesData.Rules))
+	for position, ruleData := range rulesData.Rules {
+		if !models.IsValidWeekdays(ruleData.Weekdays) {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidRuleError("weekdays"))
+			return
+		}
+		if !models.IsValidTimeZone(ruleData.TimeZone) {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidRuleError("timeZone"))
+			return
+		}
+
+		target, err := prepareFullLink(c, ruleData.Target)
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		rules = append(rules, models.ShortlinkRule{
+			LinkID:   shortlink.ID,
+			Position: position,
+			Device:   ruleData.Device,
+			Country:  ruleData.Country,
+			Language: ruleData.Language,
+			Weekdays: ruleData.Weekdays,
+			HourFrom: ruleData.HourFrom,
+			HourTo:   ruleData.HourTo,
+			TimeZone: ruleData.TimeZone,
+			Target:   target,
+		})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where(&models.ShortlinkRule{LinkID: shortlink.ID}).Delete(&models.ShortlinkRule{}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	for i := range rules {
+		if err := tx.Create(&rules[i]).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
 		}
 	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		webhooks.Notify(shortlink.OwnerID, models.EventLinkUpdated, shortlink.ResponseData())
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(rules))
+	}
 }
 
-// GetShortlinkRedirect : Redirects to a full link by a short link
-func GetShortlinkRedirect(c *gin.Context) {
+// SetShortlinkDestinations : Replace weighted destinations (A/B split) of short link with the specified ID
+func SetShortlinkDestinations(c *gin.Context) {
 	var shortlink models.Shortlink
+	var destinationsData models.ShortlinkDestinationsData
 
-	if shortlinkID, err := strconv.ParseUint(c.Params.ByName("short"), 36, 64); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
-	} else {
-		if err := database.DB.Preload("Uses").First(&shortlink, shortlinkID).Error; err != nil {
-			c.JSON(http.StatusBadRequest, h.NewResponseError(err))
-		} else {
-			shortlink.Uses = append(shortlink.Uses, models.ShortlinkUse{LinkID: shortlink.ID, UseTime: time.Now()})
-			if dbc := database.DB.Save(&shortlink); dbc.Error != nil {
-				fmt.Println(dbc.Error)
-			}
+	if err := c.ShouldBindJSON(&destinationsData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(destinationsData, err))
+		return
+	}
+
+	if !findShortlink(c, database.DB, models.RoleEditor, &shortlink) {
+		return
+	}
+
+	destinations := make([]models.ShortlinkDestination, 0, len(destinationsData.Destinations))
+	for _, destinationData := range destinationsData.Destinations {
+		destinationURL, err := prepareFullLink(c, destinationData.URL)
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
 
-			c.Redirect(http.StatusMovedPermanently, shortlink.Full)
+		destinations = append(destinations, models.ShortlinkDestination{
+			LinkID: shortlink.ID,
+			Label:  destinationData.Label,
+			URL:    destinationURL,
+			Weight: destinationData.Weight,
+		})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&shortlink).Update("sticky_destinations", destinationsData.Sticky).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Where(&models.ShortlinkDestination{LinkID: shortlink.ID}).Delete(&models.ShortlinkDestination{}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	for i := range destinations {
+		if err := tx.Create(&destinations[i]).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
 		}
 	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		webhooks.Notify(shortlink.OwnerID, models.EventLinkUpdated, shortlink.ResponseData())
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(destinations))
+	}
 }
@@ -1,18 +1,23 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
+	"time"
 
 	"shorts/database"
 	h "shorts/helper"
 	"shorts/models"
+	"shorts/urlcheck"
 
 	"github.com/gin-gonic/gin"
 )
 
+// statsDateFormat : Format of days in stats requests and responses
+const statsDateFormat = "2006-01-02"
+
 // GetShortlinksTop : Returns top 20 shortlinks
 func GetShortlinksTop(c *gin.Context) {
 	domainIndexes := make(map[string]int)
@@ -20,20 +25,26 @@ func GetShortlinksTop(c *gin.Context) {
 	var topDomains []models.TopDomainsResponseData
 	var shortlinkUse models.ShortlinkUse
 
-	if linksUses, err := shortlinkUse.UseCount(); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+	filter, err := usesFilter(c)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if linksUses, err := shortlinkUse.UseCount(filter); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
 	} else {
 		for _, linkUse := range linksUses {
-			parsedURL, err := url.Parse(linkUse.FullLink)
-			if err != nil {
+			host, ok := fullLinkHost(linkUse.FullLink)
+			if !ok {
 				continue
 			}
 
-			if _, exists := domainIndexes[parsedURL.Host]; !exists {
-				domainIndexes[parsedURL.Host] = len(topDomains)
-				topDomains = append(topDomains, models.TopDomainsResponseData{Website: parsedURL.Host, UsesCount: linkUse.UsesCount})
+			if _, exists := domainIndexes[host]; !exists {
+				domainIndexes[host] = len(topDomains)
+				topDomains = append(topDomains, models.TopDomainsResponseData{Website: host, UsesCount: linkUse.UsesCount})
 			} else {
-				topDomains[domainIndexes[parsedURL.Host]].UsesCount += linkUse.UsesCount
+				topDomains[domainIndexes[host]].UsesCount += linkUse.UsesCount
 			}
 		}
 
@@ -48,20 +59,73 @@ func GetShortlinksTop(c *gin.Context) {
 		if len(topDomains) < 20 {
 			upperLimit = len(topDomains)
 		}
+		topDomains = topDomains[:upperLimit]
+
+		if err := addTopDomainsUnique(topDomains, filter); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
 
-		c.JSON(http.StatusOK, h.NewResponseOkWithData(topDomains[:upperLimit]))
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(topDomains))
 	}
 }
 
+// addTopDomainsUnique : Sets unique visitors of websites merged from sketches of all links to them
+func addTopDomainsUnique(topDomains []models.TopDomainsResponseData, filter models.UsesFilter) error {
+	sketches, err := models.FullLinkSketches(filter)
+	if err != nil {
+		return err
+	}
+
+	domainSketches := make(map[string][]models.ShortlinkSketch)
+	for _, sketch := range sketches {
+		if host, ok := fullLinkHost(sketch.FullLink); ok {
+			domainSketches[host] = append(domainSketches[host], models.ShortlinkSketch{Registers: sketch.Registers})
+		}
+	}
+
+	for i := range topDomains {
+		merged, err := models.MergeSketches(domainSketches[topDomains[i].Website])
+		if err != nil {
+			return err
+		}
+		topDomains[i].Unique = merged.Count()
+	}
+
+	return nil
+}
+
+// fullLinkHost : Returns normalized host of the full link.
+// Links created before normalization was introduced may differ only by host case or port
+func fullLinkHost(fullLink string) (string, bool) {
+	normalizedFull, err := urlcheck.Normalize(fullLink)
+	if err != nil {
+		return "", false
+	}
+
+	parsedURL, err := url.Parse(normalizedFull)
+	if err != nil {
+		return "", false
+	}
+
+	return parsedURL.Host, true
+}
+
 // GetShortlinksGraph : Retuns uses count groupped by day, hour and minute
 func GetShortlinksGraph(c *gin.Context) {
 
 	var result models.ShortlinksGraphResponseData = make(models.ShortlinksGraphResponseData)
 
-	var shortlinksUse []models.ShortlinkUse
-	if err := database.DB.Find(&shortlinksUse).Error; err != nil {
-		c.AbortWithStatus(http.StatusNotFound)
-		fmt.Println(err)
+	var shortlinkUse models.ShortlinkUse
+
+	filter, err := usesFilter(c)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if shortlinksUse, err := shortlinkUse.Uses(filter); err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
 	} else {
 		for _, shortlinkUse := range shortlinksUse {
 			models.AddUseToGraph(&result, shortlinkUse)
@@ -69,3 +133,131 @@ func GetShortlinksGraph(c *gin.Context) {
 		c.JSON(http.StatusOK, h.NewResponseOkWithData(result))
 	}
 }
+
+// GetShortlinkStats : Returns uses count and unique visitors of short link with the specified ID, of its QR code, of each its destination and day
+func GetShortlinkStats(c *gin.Context) {
+	var shortlink models.Shortlink
+	var shortlinkUse models.ShortlinkUse
+
+	if !findShortlink(c, database.DB.Preload("Destinations"), models.RoleViewer, &shortlink) {
+		return
+	}
+
+	filter, err := usesFilter(c)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	destinationsUses, err := shortlinkUse.DestinationUseCount(shortlink.ID, filter)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	qrUses, err := shortlinkUse.SourceUseCount(shortlink.ID, models.UseSourceQR, filter)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	result := models.ShortlinkStatsResponseData{QRUsesCount: qrUses, Variants: make([]models.ShortlinkVariantStats, 0)}
+	variantIndexes := make(map[uint64]int)
+	for _, destination := range shortlink.Destinations {
+		variantIndexes[destination.ID] = len(result.Variants)
+		result.Variants = append(result.Variants, models.ShortlinkVariantStats{
+			DestinationID: destination.ID,
+			Label:         destination.Label,
+			URL:           destination.URL,
+			Weight:        destination.Weight,
+		})
+	}
+
+	for _, destinationUses := range destinationsUses {
+		result.UsesCount += destinationUses.UsesCount
+		if destinationUses.DestinationID == 0 {
+			continue
+		}
+
+		if _, exists := variantIndexes[destinationUses.DestinationID]; !exists {
+			variantIndexes[destinationUses.DestinationID] = len(result.Variants)
+			result.Variants = append(result.Variants, models.ShortlinkVariantStats{DestinationID: destinationUses.DestinationID})
+		}
+		result.Variants[variantIndexes[destinationUses.DestinationID]].UsesCount = destinationUses.UsesCount
+	}
+
+	if err := addShortlinkUnique(&result, shortlink.ID, filter); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.NewResponseOkWithData(result))
+}
+
+// addShortlinkUnique : Sets uses count and unique visitors of each day and unique visitors of the whole range
+func addShortlinkUnique(result *models.ShortlinkStatsResponseData, linkID uint64, filter models.UsesFilter) error {
+	var shortlinkUse models.ShortlinkUse
+
+	daysUses, err := shortlinkUse.DayUseCount(linkID, filter)
+	if err != nil {
+		return err
+	}
+
+	sketches, err := models.LinkSketches(linkID, filter)
+	if err != nil {
+		return err
+	}
+
+	result.Days = make([]models.ShortlinkDayStats, 0, len(daysUses))
+	dayIndexes := make(map[string]int)
+	for _, dayUses := range daysUses {
+		day := dayUses.Day.Format(statsDateFormat)
+		dayIndexes[day] = len(result.Days)
+		result.Days = append(result.Days, models.ShortlinkDayStats{Day: day, UsesCount: dayUses.UsesCount})
+	}
+
+	for _, stored := range sketches {
+		sketch, err := stored.Sketch()
+		if err != nil {
+			return err
+		}
+
+		// Days with uses only by bots have no sketches
+		day := stored.Day.Format(statsDateFormat)
+		if _, exists := dayIndexes[day]; !exists {
+			dayIndexes[day] = len(result.Days)
+			result.Days = append(result.Days, models.ShortlinkDayStats{Day: day})
+		}
+		result.Days[dayIndexes[day]].Unique = sketch.Count()
+	}
+
+	sort.Slice(result.Days, func(left, right int) bool {
+		return result.Days[left].Day < result.Days[right].Day
+	})
+
+	merged, err := models.MergeSketches(sketches)
+	if err != nil {
+		return err
+	}
+	result.Unique = merged.Count()
+
+	return nil
+}
+
+// usesFilter : Returns which uses are counted by "include_bots", "from" and "to" (YYYY-MM-DD, inclusive) query parameters
+func usesFilter(c *gin.Context) (filter models.UsesFilter, err error) {
+	filter.IncludeBots, _ = strconv.ParseBool(c.Query("include_bots"))
+
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(statsDateFormat, from); err != nil {
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(statsDateFormat, to); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"image/color"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/qrcode"
+	"shorts/totp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Login : Issue API token for user name and password, users with two-factor authentication also need TOTP or recovery code
+func Login(c *gin.Context) {
+	var user models.User
+	var loginData models.LoginData
+
+	if err := c.ShouldBindJSON(&loginData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(loginData, err))
+		return
+	}
+
+	signedIn, retryAfter, err := models.SignIn(database.DB, loginData.Name, loginData.Password, h.ClientNetwork(c.ClientIP()))
+	if err != nil {
+		h.AbortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		h.AbortWithError(c, http.StatusTooManyRequests, h.NewLoginLockedError())
+		return
+	}
+	if signedIn == nil {
+		h.AbortWithError(c, http.StatusUnauthorized, h.NewInvalidCredentialsError())
+		return
+	}
+	user = *signedIn
+
+	if err := user.SignInError(); err != nil {
+		h.AbortWithError(c, http.StatusForbidden, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		if ok, err := checkSecondFactor(&user, loginData.Code, loginData.RecoveryCode); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		} else if !ok {
+			err = h.NewTwoFactorRequiredError()
+			// Wrong codes are throttled like wrong passwords
+			if loginData.Code != "" || loginData.RecoveryCode != "" {
+				err = h.NewInvalidTwoFactorCodeError()
+				if failureErr := models.RecordSecondFactorFailure(database.DB, user, h.ClientNetwork(c.ClientIP())); failureErr != nil {
+					err = failureErr
+				}
+			}
+			h.AbortWithError(c, http.StatusUnauthorized, err)
+			return
+		}
+		if err := models.ClearLoginFailures(database.DB, user.Name); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	token, apiToken, err := models.CreateAPIToken(database.DB, user.ID, loginData.TokenName, time.Duration(loginData.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(models.LoginResponseData{Token: token, ExpiresAt: apiToken.ExpiresAt}))
+	}
+}
+
+// checkSecondFactor : Checks TOTP code or, if it is not set, recovery code of the user
+func checkSecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return models.CheckTOTP(database.DB, user, code)
+	}
+	if recoveryCode != "" {
+		return models.UseRecoveryCode(database.DB, user.ID, recoveryCode)
+	}
+
+	return false, nil
+}
+
+// secondFactorAccepted : Checks code of the user with the check like Login does: attempts are refused during lockout
+// and wrong codes are counted as failed sign-ins. Responds with error and returns false unless the code is accepted
+func secondFactorAccepted(c *gin.Context, user *models.User, check func() (bool, error)) bool {
+	ip := h.ClientNetwork(c.ClientIP())
+
+	if retryAfter, err := models.LoginRetryAfter(database.DB, user.Name, ip); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	} else if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		h.AbortWithError(c, http.StatusTooManyRequests, h.NewLoginLockedError())
+		return false
+	}
+
+	ok, err := check()
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+	if !ok {
+		err = h.NewInvalidTwoFactorCodeError()
+		if failureErr := models.RecordSecondFactorFailure(database.DB, *user, ip); failureErr != nil {
+			err = failureErr
+		}
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	if err := models.ClearLoginFailures(database.DB, user.Name); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
+}
+
+// findCurrentUserWithPassword : Loads current user, responds with error and returns false if the password does not match
+func findCurrentUserWithPassword(c *gin.Context, password string, user *models.User) bool {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if err := database.DB.First(user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	if !user.CheckPassword(password) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewWrongPasswordError())
+		return false
+	}
+
+	return true
+}
+
+// EnableTwoFactor : Generate pending TOTP secret of current user, it is enabled after the first code is confirmed
+func EnableTwoFactor(c *gin.Context) {
+	var user models.User
+	var passwordData models.PasswordData
+
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(passwordData, err))
+		return
+	}
+
+	if !findCurrentUserWithPassword(c, passwordData.Password, &user) {
+		return
+	}
+
+	if user.TOTPEnabled {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewTwoFactorStateError(true))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	uri := totp.ProvisioningURI(h.GetEnv("TOTP_ISSUER", "Shorts"), user.Name, secret)
+	code, err := qrcode.Encode(uri, qrcode.Medium)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	svg := code.SVG(qrcode.Options{Size: defaultQRSize, Margin: qrcode.DefaultMargin, Foreground: color.Black, Background: color.White})
+
+	if dbc := database.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.TwoFactorSetupResponseData{
+			Secret: secret,
+			URI:    uri,
+			QRCode: "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg),
+		}))
+	}
+}
+
+// ConfirmTwoFactor : Enable two-factor authentication of current user with the first code from authenticator and send recovery codes
+func ConfirmTwoFactor(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var codeData models.TwoFactorCodeData
+
+	if err := c.ShouldBindJSON(&codeData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(codeData, err))
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewTwoFactorStateError(user.TOTPEnabled))
+		return
+	}
+
+	tx := database.DB.Begin()
+	if ok, err := models.CheckTOTP(tx, &user, codeData.Code); err != nil || !ok {
+		tx.Rollback()
+		if err == nil {
+			err = h.NewInvalidTwoFactorCodeError()
+		}
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	// Tokens issued before were not checked with the second factor
+	if err := models.RevokeAPITokens(tx, user.ID); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	codes, err := models.GenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.RecoveryCodesResponseData{RecoveryCodes: codes}))
+	}
+}
+
+// DisableTwoFactor : Disable two-factor authentication of current user, password and TOTP or recovery code are required
+func DisableTwoFactor(c *gin.Context) {
+	var user models.User
+	var disableData models.DisableTwoFactorData
+
+	if err := c.ShouldBindJSON(&disableData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(disableData, err))
+		return
+	}
+
+	if !findCurrentUserWithPassword(c, disableData.Password, &user) {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewTwoFactorStateError(false))
+		return
+	}
+
+	if !secondFactorAccepted(c, &user, func() (bool, error) {
+		ok, err := models.CheckTOTP(database.DB, &user, disableData.Code)
+		if err == nil && !ok {
+			ok, err = models.UseRecoveryCode(database.DB, user.ID, disableData.Code)
+		}
+		return ok, err
+	}) {
+		return
+	}
+
+	// Tokens were issued with the second factor, they are not trusted without it
+	tx := database.DB.Begin()
+	if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Where(&models.RecoveryCode{UserID: user.ID}).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.RevokeAPITokens(tx, user.ID); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// RegenerateRecoveryCodes : Replace recovery codes of current user, TOTP code is required
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var codeData models.TwoFactorCodeData
+
+	if err := c.ShouldBindJSON(&codeData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(codeData, err))
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewTwoFactorStateError(false))
+		return
+	}
+
+	if !secondFactorAccepted(c, &user, func() (bool, error) { return models.CheckTOTP(database.DB, &user, codeData.Code) }) {
+		return
+	}
+
+	tx := database.DB.Begin()
+	codes, err := models.GenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.RecoveryCodesResponseData{RecoveryCodes: codes}))
+	}
+}
+
+// GetAPITokens : Send API tokens issued to current user (without the tokens themselves)
+func GetAPITokens(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	tokens := make([]models.APIToken, 0)
+	if err := database.DB.Where(&models.APIToken{UserID: userID}).Order("id").Find(&tokens).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(tokens))
+	}
+}
+
+// DeleteAPIToken : Revoke API token of current user with the specified ID
+func DeleteAPIToken(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if tokenID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if dbc := database.DB.Where(&models.APIToken{ID: tokenID, UserID: userID}).Delete(&models.APIToken{}); dbc.Error != nil {
+			h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+		} else if dbc.RowsAffected == 0 {
+			h.AbortWithError(c, http.StatusNotFound, gorm.ErrRecordNotFound)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"shorts/database"
 	h "shorts/helper"
+	"shorts/mailer"
 	"shorts/models"
 
 	"github.com/gin-gonic/gin"
@@ -15,18 +16,66 @@ func AddUser(c *gin.Context) {
 	var userData models.AddUserData
 
 	if err := c.ShouldBindJSON(&userData); err != nil {
-		c.JSON(http.StatusBadRequest, h.NewValidationError(userData, err))
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(userData, err))
+		return
+	}
+
+	password, err := models.HashPassword(userData.Password)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
 		return
 	}
 
 	user := models.User{
 		Name:     userData.Name,
-		Password: userData.Password,
+		Password: password,
+	}
+
+	// Unverified addresses of other users do not block the email, the first user to verify it gets it
+	email := models.NormalizeEmail(userData.Email)
+	if email != "" {
+		if taken, err := models.EmailTaken(database.DB, email, 0); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		} else if taken {
+			h.AbortWithError(c, http.StatusConflict, h.NewEmailTakenError())
+			return
+		}
+		user.PendingEmail = &email
+	} else if h.EmailVerificationRequired() {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewEmailRequiredError())
+		return
+	}
+
+	// Every user gets a personal workspace for own links
+	tx := database.DB.Begin()
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := models.CreateWorkspace(tx, user.Name, user.ID, true); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var message mailer.Message
+	if user.PendingEmail != nil {
+		var err error
+		if message, err = createVerificationMail(tx, user, email); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
 	}
 
-	if dbc := database.DB.Create(&user); dbc.Error != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(dbc.Error))
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
 	} else {
+		if user.PendingEmail != nil {
+			sendMail(message)
+		}
 		c.JSON(http.StatusCreated, h.NewResponseOK())
 	}
 }
@@ -38,13 +87,198 @@ func GetCurrentUser(c *gin.Context) {
 	userID := c.MustGet(gin.AuthUserKey).(uint64)
 
 	if err := database.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusBadRequest, h.NewResponseError(err))
+		h.AbortWithError(c, http.StatusBadRequest, err)
 	} else {
 		// Remove password for *security reasons*
 		// Remove shortlinks because we have /shorts
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.UserResponseData{
+			ID:            user.ID,
+			Name:          user.Name,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			PendingEmail:  user.PendingEmail,
+		}))
+	}
+}
+
+// ChangePassword : Change password of current user, the current password is required
+func ChangePassword(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var passwordData models.ChangePasswordData
+
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(passwordData, err))
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !user.CheckPassword(passwordData.CurrentPassword) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewWrongPasswordError())
+		return
+	}
+
+	password, err := models.HashPassword(passwordData.NewPassword)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Tokens issued with the old password could have leaked with it
+	tx := database.DB.Begin()
+	if err := tx.Model(&user).Update("password", password).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.RevokeAPITokens(tx, user.ID); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// ChangeName : Change name of current user (and of the user's personal workspace) if it is not taken
+func ChangeName(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var nameData models.ChangeNameData
+
+	if err := c.ShouldBindJSON(&nameData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(nameData, err))
+		return
+	}
+
+	var existing models.User
+	if err := database.DB.Where("name = ?", nameData.Name).First(&existing).Error; err == nil && existing.ID != userID {
+		h.AbortWithError(c, http.StatusConflict, h.NewUserNameTakenError())
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	workspace, err := models.FindPersonalWorkspace(database.DB, userID)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&user).Update("name", nameData.Name).Error; err != nil {
+		tx.Rollback()
+		// Another user could take the name since it was checked
+		if database.IsUniqueViolation(err) {
+			err = h.NewUserNameTakenError()
+		}
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Model(workspace).Update("name", nameData.Name).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
 		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.UserResponseData{
 			ID:   user.ID,
 			Name: user.Name,
 		}))
 	}
 }
+
+// DeleteAccount : Delete current user, the user's links are deleted or transferred to an owner of workspaces they belong to
+func DeleteAccount(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var accountData models.DeleteAccountData
+
+	if err := c.ShouldBindJSON(&accountData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(accountData, err))
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !user.CheckPassword(accountData.Password) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewWrongPasswordError())
+		return
+	}
+
+	var target models.User
+	if accountData.Links == models.AccountLinksTransfer {
+		if err := database.DB.Where("name = ?", accountData.TransferTo).First(&target).Error; err != nil || target.ID == userID {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewTransferTargetError())
+			return
+		}
+
+		// Recipient controls the workspaces the links are transferred into
+		if owns, err := models.OwnsSharedWorkspace(userID, target.ID); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		} else if !owns {
+			h.AbortWithError(c, http.StatusBadRequest, h.NewTransferTargetError())
+			return
+		}
+	}
+
+	tx := database.DB.Begin()
+	if target.ID != 0 {
+		if err := models.TransferUserLinks(tx, userID, target.ID); err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := models.DeleteUser(tx, userID); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// ExportAccount : Send all data of current user as a JSON file
+func ExportAccount(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if export, err := models.ExportAccount(user); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.Header("Content-Disposition", `attachment; filename="shorts-export.json"`)
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(export))
+	}
+}
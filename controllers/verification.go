@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/mailer"
+	"shorts/models"
+	"shorts/pages"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+)
+
+// defaultVerificationTokenHours : Lifetime of email verification links if VERIFICATION_TOKEN_HOURS is not set
+const defaultVerificationTokenHours = 48
+
+// defaultResetTokenMinutes : Lifetime of password reset tokens if RESET_TOKEN_MINUTES is not set
+const defaultResetTokenMinutes = 60
+
+// verificationTokenTTL : Lifetime of email verification links
+func verificationTokenTTL() time.Duration {
+	if hours, err := strconv.Atoi(h.GetEnv("VERIFICATION_TOKEN_HOURS", "")); err == nil && hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+
+	return defaultVerificationTokenHours * time.Hour
+}
+
+// resetTokenTTL : Lifetime of password reset tokens
+func resetTokenTTL() time.Duration {
+	if minutes, err := strconv.Atoi(h.GetEnv("RESET_TOKEN_MINUTES", "")); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+
+	return defaultResetTokenMinutes * time.Minute
+}
+
+// createVerificationMail : Creates email verification token of the user and returns message with the verification link
+func createVerificationMail(db *gorm.DB, user models.User, email string) (mailer.Message, error) {
+	token, err := models.CreateUserToken(db, user.ID, models.TokenVerifyEmail, email, verificationTokenTTL())
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	return mailer.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nopen the link below to verify your email:\n%s/v1/users/verify?token=%s\n\nThe link is valid for %s.\n",
+			user.Name, h.GetEnv("BASE_URL", ""), url.QueryEscape(token), verificationTokenTTL()),
+	}, nil
+}
+
+// sendMail : Sends the message, failures are only logged since users can request the message again
+func sendMail(message mailer.Message) {
+	if err := mailer.Send(message); err != nil {
+		fmt.Println("Cannot send email: " + err.Error())
+	}
+}
+
+// ShowVerifyEmail : Serve page confirming email verification by the token from verification link.
+// Opening the link changes nothing, so mail scanners following links can not use up the token
+func ShowVerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidTokenError())
+		return
+	}
+
+	renderVerifyEmailPage(c, http.StatusOK, pages.VerifyEmailData{Token: token})
+}
+
+// VerifyEmail : Mark email of the user as verified by the token from verification link.
+// The form of the confirmation page gets the result as a page, other clients as JSON
+func VerifyEmail(c *gin.Context) {
+	var verifyData models.VerifyEmailData
+
+	fromPage := c.ContentType() == binding.MIMEPOSTForm
+	var err error
+	if fromPage {
+		err = c.ShouldBindWith(&verifyData, binding.Form)
+	} else {
+		err = c.ShouldBindJSON(&verifyData)
+	}
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(verifyData, err))
+		return
+	}
+
+	err = verifyEmail(verifyData.Token)
+	if fromPage {
+		if err != nil {
+			renderVerifyEmailPage(c, http.StatusBadRequest, pages.VerifyEmailData{Failed: true})
+		} else {
+			renderVerifyEmailPage(c, http.StatusOK, pages.VerifyEmailData{Verified: true})
+		}
+		return
+	}
+
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// verifyEmail : Uses up the verification token and marks email of its user as verified
+func verifyEmail(token string) error {
+	var user models.User
+
+	userToken, err := models.ConsumeUserToken(database.DB, token, models.TokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if userToken == nil {
+		return h.NewInvalidTokenError()
+	}
+
+	if err := database.DB.First(&user, userToken.UserID).Error; err != nil {
+		return h.NewInvalidTokenError()
+	}
+
+	// The link is valid only while the user waits for the email it was sent to
+	return models.ConfirmPendingEmail(database.DB, &user, userToken.Email)
+}
+
+// renderVerifyEmailPage : Serves the page confirming email verification with the status
+func renderVerifyEmailPage(c *gin.Context, status int, data pages.VerifyEmailData) {
+	page, err := pages.Render("verify_email", data)
+	if err != nil {
+		h.AbortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Data(status, "text/html; charset=utf-8", page)
+}
+
+// ResendVerification : Send new verification links to users waiting for verification of the email.
+// The response is the same in any case so registered emails can not be discovered
+func ResendVerification(c *gin.Context) {
+	var users []models.User
+	var emailData models.EmailData
+
+	if err := c.ShouldBindJSON(&emailData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(emailData, err))
+		return
+	}
+
+	email := models.NormalizeEmail(emailData.Email)
+	if err := database.DB.Where("pending_email = ?", email).Order("id").Find(&users).Error; err != nil {
+		fmt.Println(err)
+	}
+	for _, user := range users {
+		if message, err := createVerificationMail(database.DB, user, email); err != nil {
+			fmt.Println(err)
+		} else {
+			sendMail(message)
+		}
+	}
+
+	c.JSON(http.StatusOK, h.NewResponseOK())
+}
+
+// RequestPasswordReset : Send password reset token if a user with the email exists.
+// The response is the same in any case so registered emails can not be discovered
+func RequestPasswordReset(c *gin.Context) {
+	var user models.User
+	var emailData models.EmailData
+
+	if err := c.ShouldBindJSON(&emailData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(emailData, err))
+		return
+	}
+
+	email := models.NormalizeEmail(emailData.Email)
+	if err := database.DB.Where("email = ?", email).First(&user).Error; err == nil {
+		if token, err := models.CreateUserToken(database.DB, user.ID, models.TokenResetPassword, email, resetTokenTTL()); err != nil {
+			fmt.Println(err)
+		} else {
+			sendMail(mailer.Message{
+				To:      email,
+				Subject: "Reset your password",
+				Body: fmt.Sprintf("Hi %s,\n\nuse the token below to set a new password with POST %s/v1/users/password-reset/confirm:\n%s\n\nThe token is valid for %s. Ignore this email if you did not ask to reset your password.\n",
+					user.Name, h.GetEnv("BASE_URL", ""), token, resetTokenTTL()),
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, h.NewResponseOK())
+}
+
+// ResetPassword : Set new password of the user by the token from password reset email
+func ResetPassword(c *gin.Context) {
+	var user models.User
+	var resetData models.ResetPasswordData
+
+	if err := c.ShouldBindJSON(&resetData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(resetData, err))
+		return
+	}
+
+	// Hashed before the token is used up, so a failure does not cost the user the token
+	password, err := models.HashPassword(resetData.NewPassword)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userToken, err := models.ConsumeUserToken(database.DB, resetData.Token, models.TokenResetPassword)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if userToken == nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidTokenError())
+		return
+	}
+
+	if err := database.DB.Where("id = ? AND email = ?", userToken.UserID, userToken.Email).First(&user).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewInvalidTokenError())
+		return
+	}
+
+	// Receiving the token proves the user owns the email. API tokens are revoked, the password is reset when the account may be taken over
+	tx := database.DB.Begin()
+	if err := tx.Model(&user).Updates(map[string]interface{}{"password": password, "email_verified": true}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.RevokeAPITokens(tx, user.ID); err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// SetEmail : Change email of current user, the new email replaces the current one once it is verified
+func SetEmail(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var user models.User
+	var emailData models.SetEmailData
+
+	if err := c.ShouldBindJSON(&emailData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(emailData, err))
+		return
+	}
+
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !user.CheckPassword(emailData.Password) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewWrongPasswordError())
+		return
+	}
+
+	email := models.NormalizeEmail(emailData.Email)
+
+	// Setting the current email again cancels the change
+	if user.Email != nil && *user.Email == email {
+		if err := database.DB.Model(&user).Update("pending_email", nil).Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+		return
+	}
+
+	if taken, err := models.EmailTaken(database.DB, email, user.ID); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	} else if taken {
+		h.AbortWithError(c, http.StatusConflict, h.NewEmailTakenError())
+		return
+	}
+
+	// The current email is kept until the new one is verified, so a mistyped address does not lock the user out
+	tx := database.DB.Begin()
+	if err := tx.Model(&user).Update("pending_email", email).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	message, err := createVerificationMail(tx, user, email)
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		sendMail(message)
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
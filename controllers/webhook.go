@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/urlcheck"
+	"shorts/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deliveriesLimit : Number of latest deliveries returned in the delivery log
+const deliveriesLimit = 100
+
+// GetWebhooks : Send all webhooks of current user
+func GetWebhooks(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	webhookList := make([]models.Webhook, 0)
+	if err := database.DB.Where(&models.Webhook{OwnerID: userID}).Order("id").Find(&webhookList).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(webhookList))
+	}
+}
+
+// AddWebhook : Register webhook notified about events of current user's links
+func AddWebhook(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var webhookData models.AddWebhookData
+
+	if err := c.ShouldBindJSON(&webhookData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(webhookData, err))
+		return
+	}
+
+	parsedURL, err := url.Parse(webhookData.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewSchemeNotAllowedError([]string{"http", "https"}))
+		return
+	}
+	// Deliveries are refused anyway, the check only reports it early
+	if err := urlcheck.CheckPublicHost(c.Request.Context(), parsedURL.Hostname()); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewNonPublicWebhookError())
+		return
+	}
+
+	webhook := models.Webhook{
+		OwnerID:         userID,
+		URL:             webhookData.URL,
+		Secret:          webhookData.Secret,
+		Events:          strings.Join(webhookData.Events, ","),
+		ClickThresholds: models.JoinThresholds(webhookData.ClickThresholds),
+	}
+
+	if webhook.Secret == "" {
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	if dbc := database.DB.Create(&webhook); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(models.WebhookCreatedResponseData{Webhook: webhook, Secret: webhook.Secret}))
+	}
+}
+
+// DeleteWebhook : Delete webhook with the specified ID and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var webhook models.Webhook
+
+	if webhookID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Webhook{ID: webhookID, OwnerID: userID}).First(&webhook).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		tx := database.DB.Begin()
+		if err := tx.Where(&models.WebhookDelivery{WebhookID: webhook.ID}).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := tx.Where(&models.WebhookThresholdHit{WebhookID: webhook.ID}).Delete(&models.WebhookThresholdHit{}).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := tx.Delete(&webhook).Error; err != nil {
+			tx.Rollback()
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOK())
+		}
+	}
+}
+
+// GetWebhookDeliveries : Send latest deliveries of webhook with the specified ID with their attempts log
+func GetWebhookDeliveries(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var webhook models.Webhook
+
+	if webhookID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Webhook{ID: webhookID, OwnerID: userID}).First(&webhook).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		deliveries := make([]models.WebhookDelivery, 0)
+		if err := database.DB.Where(&models.WebhookDelivery{WebhookID: webhook.ID}).Order("id desc").Limit(deliveriesLimit).Find(&deliveries).Error; err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(deliveries))
+		}
+	}
+}
+
+// TestWebhook : Send "ping" event to webhook with the specified ID immediately and return the delivery.
+// Failed delivery is retried like any other one
+func TestWebhook(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var webhook models.Webhook
+
+	if webhookID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		if err := database.DB.Where(&models.Webhook{ID: webhookID, OwnerID: userID}).First(&webhook).Error; err != nil {
+			h.AbortWithError(c, http.StatusNotFound, err)
+			return
+		}
+
+		if delivery, err := webhooks.Fire(webhook, models.EventPing, gin.H{"webhookId": webhook.ID}); err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+		} else {
+			c.JSON(http.StatusOK, h.NewResponseOkWithData(delivery))
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// findShortlink : Finds short link with ID from the path in workspaces of current user.
+// Responds with error and returns false if it is not found or the user's role in its workspace is lower than required
+func findShortlink(c *gin.Context, query *gorm.DB, requiredRole string, shortlink *models.Shortlink) bool {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	shortlinkID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	if err := query.First(shortlink, shortlinkID).Error; err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
+		return false
+	}
+
+	return checkWorkspaceRole(c, shortlink.WorkspaceID, userID, requiredRole)
+}
+
+// checkWorkspaceRole : Responds with error and returns false if the user's role in the workspace is lower than required.
+// Workspaces of other users are reported as not found
+func checkWorkspaceRole(c *gin.Context, workspaceID, userID uint64, requiredRole string) bool {
+	role, err := models.MemberRole(workspaceID, userID)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	if role == "" {
+		h.AbortWithError(c, http.StatusNotFound, gorm.ErrRecordNotFound)
+		return false
+	}
+	if !models.RoleAllows(role, requiredRole) {
+		h.AbortWithError(c, http.StatusForbidden, h.NewForbiddenError())
+		return false
+	}
+
+	return true
+}
+
+// findWorkspace : Finds workspace with ID from the path, responds with error and returns false if the user's role in it is lower than required
+func findWorkspace(c *gin.Context, requiredRole string, workspace *models.Workspace) bool {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	workspaceID, err := strconv.ParseUint(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	if !checkWorkspaceRole(c, workspaceID, userID, requiredRole) {
+		return false
+	}
+
+	if err := database.DB.First(workspace, workspaceID).Error; err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
+		return false
+	}
+
+	return true
+}
+
+// GetWorkspaces : Send all workspaces current user is member of
+func GetWorkspaces(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	if workspaces, err := models.UserWorkspaces(userID); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(workspaces))
+	}
+}
+
+// AddWorkspace : Create workspace owned by current user
+func AddWorkspace(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var workspaceData models.AddWorkspaceData
+
+	if err := c.ShouldBindJSON(&workspaceData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(workspaceData, err))
+		return
+	}
+
+	tx := database.DB.Begin()
+	workspace, err := models.CreateWorkspace(tx, workspaceData.Name, userID, false)
+	if err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusCreated, h.NewResponseOkWithData(models.WorkspaceResponseData{
+			ID:   workspace.ID,
+			Name: workspace.Name,
+			Role: models.RoleOwner,
+		}))
+	}
+}
+
+// DeleteWorkspace : Delete workspace with the specified ID that has no short links, only owners can delete it
+func DeleteWorkspace(c *gin.Context) {
+	var workspace models.Workspace
+	if !findWorkspace(c, models.RoleOwner, &workspace) {
+		return
+	}
+
+	if workspace.Personal {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewPersonalWorkspaceError())
+		return
+	}
+
+	var linksCount int
+	if err := database.DB.Model(&models.Shortlink{}).Where(&models.Shortlink{WorkspaceID: workspace.ID}).Count(&linksCount).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if linksCount > 0 {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewWorkspaceInUseError())
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where(&models.WorkspaceMember{WorkspaceID: workspace.ID}).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Delete(&workspace).Error; err != nil {
+		tx.Rollback()
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// GetWorkspaceMembers : Send members of workspace with the specified ID
+func GetWorkspaceMembers(c *gin.Context) {
+	var workspace models.Workspace
+	if !findWorkspace(c, models.RoleViewer, &workspace) {
+		return
+	}
+
+	members := make([]models.WorkspaceMemberResponseData, 0)
+	if dbc := database.DB.Table("workspace_members").Select("workspace_members.user_id, users.name, workspace_members.role").
+		Joins("join users on users.id = workspace_members.user_id").Where("workspace_members.workspace_id = ?", workspace.ID).
+		Order("users.name").Scan(&members); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(members))
+	}
+}
+
+// SetWorkspaceMember : Add user to workspace with the specified ID or change the user's role, only owners can manage members
+func SetWorkspaceMember(c *gin.Context) {
+	var workspace models.Workspace
+	var memberData models.SetWorkspaceMemberData
+
+	if err := c.ShouldBindJSON(&memberData); err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewValidationError(memberData, err))
+		return
+	}
+
+	if !findWorkspace(c, models.RoleOwner, &workspace) {
+		return
+	}
+
+	if workspace.Personal {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewPersonalWorkspaceError())
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("name = ?", memberData.Name).First(&user).Error; err != nil {
+		h.AbortWithError(c, http.StatusNotFound, err)
+		return
+	}
+
+	var member models.WorkspaceMember
+	if err := database.DB.Where(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: user.ID}).
+		Assign(models.WorkspaceMember{Role: memberData.Role}).FirstOrInit(&member).Error; err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if member.ID != 0 && memberData.Role != models.RoleOwner && isLastOwner(workspace.ID, user.ID) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewLastOwnerError())
+		return
+	}
+
+	// Role set by an owner is not changed by single sign-on group mapping anymore
+	member.Managed = false
+
+	if dbc := database.DB.Save(&member); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOkWithData(models.WorkspaceMemberResponseData{UserID: user.ID, Name: user.Name, Role: member.Role}))
+	}
+}
+
+// DeleteWorkspaceMember : Remove user from workspace with the specified ID.
+// Owners can remove anyone, other members can only leave
+func DeleteWorkspaceMember(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uint64)
+
+	var workspace models.Workspace
+
+	memberID, err := strconv.ParseUint(c.Params.ByName("userId"), 10, 64)
+	if err != nil {
+		h.AbortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	requiredRole := models.RoleOwner
+	if memberID == userID {
+		requiredRole = models.RoleViewer
+	}
+	if !findWorkspace(c, requiredRole, &workspace) {
+		return
+	}
+
+	if workspace.Personal {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewPersonalWorkspaceError())
+		return
+	}
+	if isLastOwner(workspace.ID, memberID) {
+		h.AbortWithError(c, http.StatusBadRequest, h.NewLastOwnerError())
+		return
+	}
+
+	if dbc := database.DB.Where(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: memberID}).Delete(&models.WorkspaceMember{}); dbc.Error != nil {
+		h.AbortWithError(c, http.StatusBadRequest, dbc.Error)
+	} else if dbc.RowsAffected == 0 {
+		h.AbortWithError(c, http.StatusNotFound, gorm.ErrRecordNotFound)
+	} else {
+		c.JSON(http.StatusOK, h.NewResponseOK())
+	}
+}
+
+// isLastOwner : Checks if the user is the only owner of the workspace
+func isLastOwner(workspaceID, userID uint64) bool {
+	var owners []models.WorkspaceMember
+	if err := database.DB.Where(&models.WorkspaceMember{WorkspaceID: workspaceID, Role: models.RoleOwner}).Find(&owners).Error; err != nil {
+		return false
+	}
+
+	return len(owners) == 1 && owners[0].UserID == userID
+}
@@ -1,7 +1,55 @@
 package database
 
 import (
+	h "shorts/helper"
+
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
 var DB *gorm.DB
+
+// IsUniqueViolation : Checks if error was caused by a unique constraint
+func IsUniqueViolation(err error) bool {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Code == "23505"
+	case gorm.Errors:
+		for _, item := range e {
+			if IsUniqueViolation(item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// TranslateError : Replaces errors of the database with application errors of the matching status,
+// so texts of the driver and names of constraints are not returned to clients. Other errors are returned as is
+func TranslateError(err error) error {
+	switch e := err.(type) {
+	case *pq.Error:
+		switch {
+		case e.Code == "23505":
+			return h.NewRecordExistsError()
+		case e.Code == "23503":
+			return h.NewRecordReferencedError()
+		case e.Code.Class() == "22" || e.Code.Class() == "23":
+			// Data exceptions and other integrity violations are caused by values of the request
+			return h.NewInvalidValueError()
+		default:
+			return h.NewInternalError()
+		}
+	case gorm.Errors:
+		if len(e) > 0 {
+			return TranslateError(e[0])
+		}
+	}
+
+	if gorm.IsRecordNotFoundError(err) {
+		return h.NewRecordNotFoundError()
+	}
+
+	return err
+}
@@ -14,10 +14,15 @@
 //
 //     Security:
 //     - basic
+//     - bearer
 //
 //    SecurityDefinitions:
 //    basic:
 //      type: basic
+//    bearer:
+//      type: apiKey
+//      in: header
+//      name: Authorization
 //
 // swagger:meta
 package docs
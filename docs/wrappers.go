@@ -5,13 +5,20 @@ import (
 	"shorts/models"
 )
 
-// "result": "error" and error text returns in the response
+// "result": "error", stable error code and error text return in the response
 // swagger:response ResponseError
 type ResponseErrorWrapper struct {
 	// in: body
 	Body helper.ResponseError
 }
 
+// RFC 7807 problem details return instead of ResponseError if the client accepts application/problem+json
+// swagger:response ProblemDetails
+type ProblemDetailsWrapper struct {
+	// in: body
+	Body helper.ProblemDetails
+}
+
 // "result": "error" and error text returns in the response
 // swagger:response ResponseError
 type UnauthorizedResponseWrapper struct {
@@ -110,3 +117,469 @@ type RedirectShortlinkParameterWrapper struct {
 	// required: true
 	Short string `json:"short"`
 }
+
+// Information about a custom domain
+// swagger:response DomainResponse
+type DomainResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.Domain `json:"data"`
+		Result string        `json:"result"`
+	}
+}
+
+// List of custom domains
+// swagger:response DomainsResponse
+type DomainsResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.Domain `json:"data"`
+		Result string          `json:"result"`
+	}
+}
+
+// Path parameters for deleting or verifying custom domain
+// swagger:parameters deleteDomain verifyDomain
+type DeleteDomainParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Information about a blocklist entry
+// swagger:response BlocklistEntryResponse
+type BlocklistEntryResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.BlocklistEntry `json:"data"`
+		Result string                `json:"result"`
+	}
+}
+
+// List of blocklist entries
+// swagger:response BlocklistResponse
+type BlocklistResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.BlocklistEntry `json:"data"`
+		Result string                  `json:"result"`
+	}
+}
+
+// Path parameters for deleting blocklist entry
+// swagger:parameters deleteBlocklistEntry
+type DeleteBlocklistEntryParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Path parameters for updating short link
+// swagger:parameters updateShortlink
+type UpdateShortlinkParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// List of conditional redirect rules
+// swagger:response ShortlinkRulesResponse
+type ShortlinkRulesResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.ShortlinkRule `json:"data"`
+		Result string                 `json:"result"`
+	}
+}
+
+// Path parameters for setting redirect rules of short link
+// swagger:parameters setShortlinkRules
+type SetShortlinkRulesParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// List of weighted destinations
+// swagger:response ShortlinkDestinationsResponse
+type ShortlinkDestinationsResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.ShortlinkDestination `json:"data"`
+		Result string                        `json:"result"`
+	}
+}
+
+// Statistics of a short link
+// swagger:response ShortlinkStatsResponse
+type ShortlinkStatsResponseWrapper struct {
+	// in: body
+	Body models.ShortlinkStatsResponse
+}
+
+// Path parameters for setting destinations of short link
+// swagger:parameters setShortlinkDestinations
+type SetShortlinkDestinationsParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Path parameters for getting statistics of short link
+// swagger:parameters getShortlinkStats
+type GetShortlinkStatsParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Path parameters for updating custom domain
+// swagger:parameters updateDomain
+type UpdateDomainParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// QR code image
+// swagger:response QRCodeResponse
+type QRCodeResponseWrapper struct {
+	// in: body
+	Body []byte
+}
+
+// Path parameters for getting QR code of short link
+// swagger:parameters getShortlinkQR
+type GetShortlinkQRParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// HTML page shown to visitors
+// swagger:response PageResponse
+type PageResponseWrapper struct {
+	// in: body
+	Body string
+}
+
+// Query parameters of statistics
+// swagger:parameters getShortlinksTop getShortlinksGraph getShortlinkStats
+type StatsParameterWrapper struct {
+	// Include uses by crawlers, link checkers and health probes
+	// in: query
+	IncludeBots bool `json:"include_bots"`
+	// First counted day (UTC), YYYY-MM-DD
+	// in: query
+	From string `json:"from"`
+	// Last counted day (UTC), YYYY-MM-DD
+	// in: query
+	To string `json:"to"`
+}
+
+// List of webhooks
+// swagger:response WebhooksResponse
+type WebhooksResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.Webhook `json:"data"`
+		Result string           `json:"result"`
+	}
+}
+
+// Information about a webhook
+// swagger:response WebhookResponse
+type WebhookResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.Webhook `json:"data"`
+		Result string         `json:"result"`
+	}
+}
+
+// List of webhook deliveries
+// swagger:response WebhookDeliveriesResponse
+type WebhookDeliveriesResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.WebhookDelivery `json:"data"`
+		Result string                   `json:"result"`
+	}
+}
+
+// Information about a webhook delivery
+// swagger:response WebhookDeliveryResponse
+type WebhookDeliveryResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.WebhookDelivery `json:"data"`
+		Result string                 `json:"result"`
+	}
+}
+
+// Path parameters of webhook actions
+// swagger:parameters getWebhookDeliveries testWebhook deleteWebhook
+type WebhookParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// List of workspaces
+// swagger:response WorkspacesResponse
+type WorkspacesResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.WorkspaceResponseData `json:"data"`
+		Result string                         `json:"result"`
+	}
+}
+
+// Information about a workspace
+// swagger:response WorkspaceResponse
+type WorkspaceResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.WorkspaceResponseData `json:"data"`
+		Result string                       `json:"result"`
+	}
+}
+
+// List of workspace members
+// swagger:response WorkspaceMembersResponse
+type WorkspaceMembersResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.WorkspaceMemberResponseData `json:"data"`
+		Result string                               `json:"result"`
+	}
+}
+
+// Information about a workspace member
+// swagger:response WorkspaceMemberResponse
+type WorkspaceMemberResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.WorkspaceMemberResponseData `json:"data"`
+		Result string                             `json:"result"`
+	}
+}
+
+// Path parameters of workspace actions
+// swagger:parameters getWorkspaceMembers setWorkspaceMember deleteWorkspaceMember deleteWorkspace
+type WorkspaceParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Path parameters of removing workspace member
+// swagger:parameters deleteWorkspaceMember
+type WorkspaceMemberParameterWrapper struct {
+	// in: path
+	// required: true
+	UserID int `json:"userId"`
+}
+
+// Query parameters of short links list
+// swagger:parameters getShortlinks
+type ShortlinksParameterWrapper struct {
+	// Only links of the workspace
+	// in: query
+	Workspace int `json:"workspace"`
+}
+
+// List of users for administrators
+// swagger:response AdminUsersResponse
+type AdminUsersResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.AdminUserResponseData `json:"data"`
+		Result string                         `json:"result"`
+	}
+}
+
+// Information about a user for administrators
+// swagger:response AdminUserResponse
+type AdminUserResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.AdminUserResponseData `json:"data"`
+		Result string                       `json:"result"`
+	}
+}
+
+// Service-wide counters
+// swagger:response SystemStatsResponse
+type SystemStatsResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.SystemStatsResponseData `json:"data"`
+		Result string                         `json:"result"`
+	}
+}
+
+// Latest actions of administrators
+// swagger:response AuditLogResponse
+type AuditLogResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.AuditEntry `json:"data"`
+		Result string              `json:"result"`
+	}
+}
+
+// Path parameters of admin actions with users, short links, plans and workspaces
+// swagger:parameters adminUpdateUser adminDeleteUser adminUnlockUser adminUpdateShortlink adminUpdatePlan adminSetWorkspacePlan
+type AdminParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Query parameters of admin lists
+// swagger:parameters adminGetUsers getAuditLog
+type PageParameterWrapper struct {
+	// Items returned at once (default 50, at most 500)
+	// in: query
+	Limit int `json:"limit"`
+	// in: query
+	Offset int `json:"offset"`
+}
+
+// Query parameters of users search
+// swagger:parameters adminGetUsers
+type AdminUsersParameterWrapper struct {
+	// Part of user name
+	// in: query
+	Q string `json:"q"`
+}
+
+// All data of a user
+// swagger:response AccountExportResponse
+type AccountExportResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.AccountExport `json:"data"`
+		Result string               `json:"result"`
+	}
+}
+
+// Issued API token
+// swagger:response LoginResponse
+type LoginResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.LoginResponseData `json:"data"`
+		Result string                   `json:"result"`
+	}
+}
+
+// Pending TOTP secret
+// swagger:response TwoFactorSetupResponse
+type TwoFactorSetupResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.TwoFactorSetupResponseData `json:"data"`
+		Result string                            `json:"result"`
+	}
+}
+
+// New recovery codes
+// swagger:response RecoveryCodesResponse
+type RecoveryCodesResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.RecoveryCodesResponseData `json:"data"`
+		Result string                           `json:"result"`
+	}
+}
+
+// List of API tokens
+// swagger:response APITokensResponse
+type APITokensResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.APIToken `json:"data"`
+		Result string            `json:"result"`
+	}
+}
+
+// Path parameters of revoking API token
+// swagger:parameters deleteAPIToken
+type APITokenParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Login page of identity provider for linking
+// swagger:response OIDCLinkResponse
+type OIDCLinkResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.OIDCLinkResponseData `json:"data"`
+		Result string                      `json:"result"`
+	}
+}
+
+// List of linked identity provider accounts
+// swagger:response OIDCIdentitiesResponse
+type OIDCIdentitiesResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.UserIdentity `json:"data"`
+		Result string                `json:"result"`
+	}
+}
+
+// Path parameters of unlinking identity provider account
+// swagger:parameters deleteOIDCIdentity
+type OIDCIdentityParameterWrapper struct {
+	// in: path
+	// required: true
+	ID int `json:"id"`
+}
+
+// Query parameters of single sign-on callback
+// swagger:parameters oidcCallback
+type OIDCCallbackParameterWrapper struct {
+	// Authorization code
+	// in: query
+	Code string `json:"code"`
+	// in: query
+	State string `json:"state"`
+	// Error reported by identity provider
+	// in: query
+	Error string `json:"error"`
+}
+
+// Usage and limits of plans
+// swagger:response UsageResponse
+type UsageResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.UsageResponseData `json:"data"`
+		Result string                   `json:"result"`
+	}
+}
+
+// List of plans
+// swagger:response PlansResponse
+type PlansResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   []models.Plan `json:"data"`
+		Result string        `json:"result"`
+	}
+}
+
+// Information about a plan
+// swagger:response PlanResponse
+type PlanResponseWrapper struct {
+	// in: body
+	Body struct {
+		Data   models.Plan `json:"data"`
+		Result string      `json:"result"`
+	}
+}
@@ -0,0 +1,100 @@
+package helper
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error : Application error with a stable code clients can rely on, the message is meant for people and may change
+type Error struct {
+	// Status of the response, if it is not set the handler decides
+	Status  int
+	Code    string
+	Message string
+	// Invalid fields of the request body with failed validation rules
+	Fields []map[string]string
+}
+
+// Error : Returns the message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError : Returns application error with the code and message
+func newError(code, message string) error {
+	return &Error{Code: code, Message: message}
+}
+
+// ProblemDetails : Error response in RFC 7807 format, returned as application/problem+json
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	// Stable error code, the same as in the default error response
+	Code          string              `json:"code"`
+	InvalidParams []map[string]string `json:"invalidParams,omitempty"`
+}
+
+// ProblemContentType : Media type of RFC 7807 error responses
+const ProblemContentType = "application/problem+json"
+
+// NewProblemDetails : Returns RFC 7807 representation of the error
+func NewProblemDetails(err *Error, status int, instance string) ProblemDetails {
+	return ProblemDetails{
+		Type:          "urn:shorts:error:" + err.Code,
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        err.Message,
+		Instance:      instance,
+		Code:          err.Code,
+		InvalidParams: err.Fields,
+	}
+}
+
+// ProblemDetailsWanted : Checks if errors have to be returned as problem details,
+// either the client accepts application/problem+json or ERROR_FORMAT is "problem"
+func ProblemDetailsWanted(accept string) bool {
+	for _, mediaType := range strings.Split(accept, ",") {
+		if strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0]) == ProblemContentType {
+			return true
+		}
+	}
+
+	return GetEnv("ERROR_FORMAT", "") == "problem"
+}
+
+// AbortWithError : Stops handling of the request, the response with the error is written by the router's error handler.
+// Status is used unless the error is translated (see TranslateError)
+func AbortWithError(c *gin.Context, status int, err error) {
+	c.Abort()
+	c.Error(err).SetMeta(status)
+}
+
+// TranslateError : Returns application error and status of the response for the error.
+// Errors with own status (e.g. translated database errors, see database.TranslateError) override the status of the handler.
+// Other errors without a code get the code of the status, messages of internal errors are hidden
+func TranslateError(err error, status int) (*Error, int) {
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		if appErr.Status != 0 {
+			status = appErr.Status
+		}
+		return appErr, status
+	}
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = http.StatusText(status)
+	}
+
+	return &Error{Code: strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"), Message: message}, status
+}
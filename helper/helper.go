@@ -1,10 +1,16 @@
 package helper
 
 import (
-	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -36,13 +42,11 @@ type ResponseOK struct {
 // ResponseError : response with error
 type ResponseError struct {
 	Result string `json:"result"`
-	Error  string `json:"error"`
-}
-
-// ValidationError : response with error
-type ValidationError struct {
-	Result string              `json:"result"`
-	Error  []map[string]string `json:"error"`
+	// Stable error code, see the constructors of errors for the codes
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	// Invalid fields of the request body with failed validation rules
+	Fields []map[string]string `json:"fields,omitempty"`
 }
 
 // ResponseData : response with data
@@ -56,23 +60,24 @@ func NewResponseOK() ResponseOK {
 	return ResponseOK{Result: "ok"}
 }
 
-// NewResponseError : Returns response with error message
+// NewResponseError : Returns response with error message and code, see TranslateError for errors without a code
 func NewResponseError(err error) ResponseError {
-	return ResponseError{Result: "error", Error: err.Error()}
+	appErr, _ := TranslateError(err, http.StatusBadRequest)
+	return ResponseError{Result: "error", Code: appErr.Code, Error: appErr.Message, Fields: appErr.Fields}
 }
 
-// NewValidationError : Returns response with error message
-func NewValidationError(validationObject interface{}, err error) interface{} {
+// NewValidationError : Returns error of request body binding, with the list of invalid fields if validation failed
+func NewValidationError(validationObject interface{}, err error) error {
 	errorMessage := err.Error()
 	if errorMessage == "EOF" || errorMessage == "unexpected EOF" {
-		return NewResponseError(errors.New("Invalid requrest body"))
+		return newError("invalid_body", "Invalid request body")
 	}
 
 	switch err.(type) {
 	default:
-		return NewResponseError(err)
+		return &Error{Code: "invalid_body", Message: errorMessage}
 	case validator.ValidationErrors:
-		return ValidationError{Result: "error", Error: ListOfErrors(validationObject, err)}
+		return &Error{Code: "validation_failed", Message: "Invalid fields", Fields: ListOfErrors(validationObject, err)}
 	}
 }
 
@@ -81,19 +86,342 @@ func NewResponseOkWithData(data interface{}) ResponseData {
 	return ResponseData{Result: "ok", Data: data}
 }
 
-// MakeShortlinkFromID returns id in Base36 format
-func MakeShortlinkFromID(s uint64) string {
-	return strconv.FormatUint(uint64(s), 36)
-}
-
 // NewAbsoluteLinksOnlyError returns error to indicate that full link is not absolute
 func NewAbsoluteLinksOnlyError() error {
-	return errors.New("Only absolule URLs are supported")
+	return newError("url_not_absolute", "Only absolule URLs are supported")
+}
+
+// NewSchemeNotAllowedError returns error to indicate that full link scheme is not in the allowlist
+func NewSchemeNotAllowedError(allowed []string) error {
+	return newError("scheme_not_allowed", "Only following URL schemes are supported: "+strings.Join(allowed, ", "))
+}
+
+// NewRedirectLoopError returns error to indicate that full link leads back to this service
+func NewRedirectLoopError() error {
+	return newError("redirect_loop", "Links to short links are not supported")
+}
+
+// NewUnresolvableLinkError returns error to indicate that link of another shortener redirects to a host that is not allowed
+func NewUnresolvableLinkError() error {
+	return newError("link_unresolvable", "Link redirects to an address that is not allowed")
+}
+
+// NewNonPublicWebhookError returns error to indicate that webhook URL does not point to a public host
+func NewNonPublicWebhookError() error {
+	return newError("webhook_not_public", "Webhook URL must point to a public host")
+}
+
+// NewBlockedLinkError returns error to indicate that full link is in the blocklist
+func NewBlockedLinkError(reason string) error {
+	if reason == "" {
+		return newError("link_blocked", "Link is blocked")
+	}
+	return newError("link_blocked", "Link is blocked: "+reason)
+}
+
+// NewInvalidPatternError returns error to indicate that blocklist pattern can not be used
+func NewInvalidPatternError() error {
+	return newError("invalid_pattern", "Invalid pattern")
+}
+
+// NewInvalidRuleError returns error to indicate that redirect rule has invalid condition
+func NewInvalidRuleError(condition string) error {
+	return newError("invalid_rule", "Invalid rule condition: "+condition)
+}
+
+// NewInvalidDeepLinkError returns error to indicate that app deep link can not be used
+func NewInvalidDeepLinkError(field string) error {
+	return newError("invalid_deep_link", "Invalid deep link: "+field)
+}
+
+// NewInvalidSocialTagError returns error to indicate that link preview tag can not be used
+func NewInvalidSocialTagError(field string) error {
+	return newError("invalid_social_tag", "Invalid social tag: "+field)
+}
+
+// NewLinkExpiredError returns error to indicate that short link can not be used anymore
+func NewLinkExpiredError() error {
+	return newError("link_expired", "Short link has expired")
+}
+
+// NewLinkDisabledError returns error to indicate that short link was disabled by administrator
+func NewLinkDisabledError(reason string) error {
+	if reason == "" {
+		return newError("link_disabled", "Short link has been disabled")
+	}
+	return newError("link_disabled", "Short link has been disabled: "+reason)
+}
+
+// NewUserSuspendedError returns error to indicate that user account is suspended
+func NewUserSuspendedError() error {
+	return newError("user_suspended", "User account is suspended")
+}
+
+// NewSelfAdminActionError returns error to indicate that administrator tried to suspend, demote or delete own account
+func NewSelfAdminActionError() error {
+	return newError("self_admin_action", "Administrators can not change or delete their own account here")
+}
+
+// NewWrongPasswordError returns error to indicate that current password of the user does not match
+func NewWrongPasswordError() error {
+	return newError("wrong_password", "Current password is wrong")
+}
+
+// NewUserNameTakenError returns error to indicate that another user already has the name
+func NewUserNameTakenError() error {
+	return &Error{Status: http.StatusConflict, Code: "user_name_taken", Message: "User name is already taken"}
+}
+
+// NewTransferTargetError returns error to indicate that links can not be transferred to the requested user
+func NewTransferTargetError() error {
+	return newError("invalid_transfer_target", "Links can only be transferred to an owner of a workspace you are a member of")
+}
+
+// NewEmailRequiredError returns error to indicate that users have to register with email
+func NewEmailRequiredError() error {
+	return newError("email_required", "Email is required")
+}
+
+// NewEmailTakenError returns error to indicate that another user already has the email
+func NewEmailTakenError() error {
+	return &Error{Status: http.StatusConflict, Code: "email_taken", Message: "Email is already used by another user"}
+}
+
+// NewEmailNotVerifiedError returns error to indicate that user has to verify email before signing in
+func NewEmailNotVerifiedError() error {
+	return newError("email_not_verified", "Email is not verified")
+}
+
+// NewInvalidTokenError returns error to indicate that token does not exist, expired or was already used
+func NewInvalidTokenError() error {
+	return newError("invalid_token", "Token is invalid or expired")
+}
+
+// NewInvalidCredentialsError returns error to indicate that user name or password is wrong
+func NewInvalidCredentialsError() error {
+	return newError("invalid_credentials", "Invalid user name or password")
+}
+
+// NewAuthenticationRequiredError returns error to indicate that request has no supported credentials
+func NewAuthenticationRequiredError() error {
+	return newError("authentication_required", "Authentication required")
+}
+
+// NewTwoFactorRequiredError returns error to indicate that user with two-factor authentication has to sign in with a code
+func NewTwoFactorRequiredError() error {
+	return newError("two_factor_required", "Two-factor authentication is enabled, sign in with a code at POST /v1/login and use the token")
+}
+
+// NewInvalidTwoFactorCodeError returns error to indicate that TOTP or recovery code is wrong or was already used
+func NewInvalidTwoFactorCodeError() error {
+	return newError("invalid_two_factor_code", "Invalid two-factor code")
+}
+
+// NewTwoFactorStateError returns error to indicate that two-factor authentication is already enabled or was not set up
+func NewTwoFactorStateError(enabled bool) error {
+	if enabled {
+		return newError("two_factor_state", "Two-factor authentication is already enabled")
+	}
+	return newError("two_factor_state", "Two-factor authentication is not enabled")
+}
+
+// NewSSODisabledError returns error to indicate that single sign-on is not configured
+func NewSSODisabledError() error {
+	return newError("sso_disabled", "Single sign-on is not configured")
+}
+
+// NewSSOFailedError returns error to indicate that identity provider did not authenticate the user
+func NewSSOFailedError(reason string) error {
+	return newError("sso_failed", "Single sign-on failed: "+reason)
+}
+
+// NewIdentityLinkedError returns error to indicate that identity provider account is linked to another user
+func NewIdentityLinkedError() error {
+	return &Error{Status: http.StatusConflict, Code: "identity_linked", Message: "This account of identity provider is linked to another user"}
+}
+
+// NewIdentityLinkRequiredError returns error to indicate that identity provider account has to be linked by the user with the same email
+func NewIdentityLinkRequiredError() error {
+	return &Error{Status: http.StatusConflict, Code: "identity_link_required",
+		Message: "Account with this email uses two-factor authentication, sign in and link the identity provider account first"}
+}
+
+// NewPersonalWorkspaceError returns error to indicate that personal workspace can not be shared or deleted
+func NewPersonalWorkspaceError() error {
+	return newError("personal_workspace", "Personal workspace can not be shared or deleted")
+}
+
+// NewWorkspaceInUseError returns error to indicate that workspace still has short links
+func NewWorkspaceInUseError() error {
+	return newError("workspace_in_use", "Workspace has short links")
+}
+
+// NewLastOwnerError returns error to indicate that workspace would be left without owners
+func NewLastOwnerError() error {
+	return newError("last_owner", "Workspace must have at least one owner")
+}
+
+// NewRateLimitedError returns error to indicate that client sent too many requests
+func NewRateLimitedError() error {
+	return newError("rate_limited", "Too many requests, retry later")
+}
+
+// NewQuotaExceededError returns error to indicate that plan does not allow more of the resource
+func NewQuotaExceededError(resource string, limit int) error {
+	return newError("quota_exceeded", fmt.Sprintf("Plan limit reached: at most %d %s", limit, resource))
+}
+
+// NewAPIQuotaExceededError returns error to indicate that monthly API calls of the plan were used up
+func NewAPIQuotaExceededError(limit int) error {
+	return newError("api_quota_exceeded", fmt.Sprintf("Plan limit reached: at most %d API calls per month", limit))
+}
+
+// NewLoginLockedError returns error to indicate that sign-in is blocked after failed attempts
+func NewLoginLockedError() error {
+	return newError("login_locked", "Too many failed sign-in attempts, retry later")
+}
+
+// NewForbiddenError returns error to indicate that current user is not allowed to perform an action
+func NewForbiddenError() error {
+	return newError("forbidden", "Access denied")
+}
+
+// NewPlanNotFoundError returns error to indicate that plan referenced in the request does not exist
+func NewPlanNotFoundError() error {
+	return newError("plan_not_found", "Plan not found")
+}
+
+// NewRecordNotFoundError returns error to indicate that requested record does not exist
+func NewRecordNotFoundError() error {
+	return &Error{Status: http.StatusNotFound, Code: "not_found", Message: "Record not found"}
+}
+
+// NewRecordExistsError returns error to indicate that record with the same unique values already exists
+func NewRecordExistsError() error {
+	return &Error{Status: http.StatusConflict, Code: "already_exists", Message: "Record already exists"}
+}
+
+// NewRecordReferencedError returns error to indicate that record is referenced by other records or references missing one
+func NewRecordReferencedError() error {
+	return &Error{Status: http.StatusConflict, Code: "conflict", Message: "Record is referenced by other records"}
+}
+
+// NewInvalidValueError returns error to indicate that database did not accept a value
+func NewInvalidValueError() error {
+	return &Error{Status: http.StatusBadRequest, Code: "invalid_value", Message: "Invalid value"}
+}
+
+// NewInternalError returns error to indicate that request failed because of the server, the cause is not exposed
+func NewInternalError() error {
+	return &Error{Status: http.StatusInternalServerError, Code: "internal_error", Message: "Internal server error"}
 }
 
 // NewPageNotFoundError returns error to indicate that route was not found
 func NewPageNotFoundError() error {
-	return errors.New("Page not found")
+	return newError("page_not_found", "Page not found")
+}
+
+// NewShortTakenError returns error to indicate that requested short link is already used on the domain
+func NewShortTakenError() error {
+	return &Error{Status: http.StatusConflict, Code: "short_taken", Message: "Short link is already taken"}
+}
+
+// NewInvalidShortError returns error to indicate that requested short link contains unsupported characters
+func NewInvalidShortError() error {
+	return newError("invalid_short", "Short link may contain only latin letters, digits, '-' and '_'")
+}
+
+// NewInvalidHostError returns error to indicate that domain is not a valid host name
+func NewInvalidHostError() error {
+	return newError("invalid_host", "Invalid host name")
+}
+
+// NewDomainNotFoundError returns error to indicate that domain is not registered by current user
+func NewDomainNotFoundError() error {
+	return newError("domain_not_found", "Domain not found")
+}
+
+// NewDomainInUseError returns error to indicate that domain still has short links
+func NewDomainInUseError() error {
+	return newError("domain_in_use", "Domain still has short links")
+}
+
+// NewReservedHostError returns error to indicate that host of the service itself can not be registered as custom domain
+func NewReservedHostError() error {
+	return newError("reserved_host", "Host of the service itself can not be registered")
+}
+
+// NewDomainTakenError returns error to indicate that domain is verified by another user already
+func NewDomainTakenError() error {
+	return &Error{Status: http.StatusConflict, Code: "domain_taken", Message: "Domain is already verified by another user"}
+}
+
+// NewDomainNotVerifiedError returns error to indicate that TXT record with the verification token was not found
+func NewDomainNotVerifiedError(name string) error {
+	return newError("domain_not_verified", fmt.Sprintf("TXT record with the verification token was not found at %s", name))
+}
+
+var shortPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// IsValidShort : Checks that custom short link can be used as a single URL path segment
+func IsValidShort(short string) bool {
+	return shortPattern.MatchString(short)
+}
+
+// NormalizeHost : Returns host in lower case without port and trailing dot
+func NormalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// GetEnv : Returns value of the environment variable or fallback if it is not set
+func GetEnv(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// GetEnvBool : Returns boolean value of the environment variable or fallback if it is not set or invalid
+func GetEnvBool(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(GetEnv(key, "")); err == nil {
+		return value
+	}
+
+	return fallback
+}
+
+// EmailVerificationRequired : Checks if users have to register with email and verify it before signing in
+func EmailVerificationRequired() bool {
+	return GetEnvBool("REQUIRE_EMAIL_VERIFICATION", false)
+}
+
+// RootShortlinksEnabled : Checks if short links of the default domain are served from the root ("/{short}")
+func RootShortlinksEnabled() bool {
+	return GetEnvBool("ROOT_SHORTLINKS", false)
+}
+
+// MakeShortURL : Returns public URL of the short link. Empty host means default domain from BASE_URL
+func MakeShortURL(host, short string) string {
+	baseURL := strings.TrimSuffix(GetEnv("BASE_URL", "http://localhost:8080"), "/")
+
+	if host != "" {
+		scheme := "https"
+		if parsedURL, err := url.Parse(baseURL); err == nil && parsedURL.Scheme != "" {
+			scheme = parsedURL.Scheme
+		}
+		return scheme + "://" + host + "/" + short
+	}
+
+	if RootShortlinksEnabled() {
+		return baseURL + "/" + short
+	}
+
+	return baseURL + "/v1/s/" + short
 }
 
 // A data structure to hold a key/value pair.
@@ -138,3 +466,14 @@ func GetTopDomains(m map[string]uint64, topCount int) (res map[string]uint64) {
 
 	return
 }
+
+// ClientNetwork : Returns the IPv4 address or the /64 network of the IPv6 address. Clients usually get a whole /64,
+// so limits and lockouts per IPv6 address could be avoided by rotating through it
+func ClientNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
@@ -0,0 +1,103 @@
+// Package hll estimates numbers of distinct elements with HyperLogLog sketches
+package hll
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// DefaultPrecision : 4096 registers, standard error is about 1.6%
+const DefaultPrecision = 12
+
+// Sketch : HyperLogLog sketch, one register per 2^precision buckets
+type Sketch struct {
+	precision uint8
+	registers []uint8
+}
+
+// New : Returns empty sketch with 2^precision registers, precision has to be between 4 and 18
+func New(precision uint8) (*Sketch, error) {
+	if precision < 4 || precision > 18 {
+		return nil, errors.New("Invalid sketch precision")
+	}
+
+	return &Sketch{precision: precision, registers: make([]uint8, 1<<precision)}, nil
+}
+
+// Add : Adds element by its 64-bit hash, hashes have to be uniformly distributed
+func (s *Sketch) Add(hash uint64) {
+	index := hash >> (64 - s.precision)
+	// Guard bit limits rank if all remaining bits are zeros
+	rank := uint8(bits.LeadingZeros64(hash<<s.precision|1<<(s.precision-1))) + 1
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge : Adds all elements of other sketch of the same precision
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.precision != s.precision {
+		return errors.New("Sketches have different precision")
+	}
+
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+
+	return nil
+}
+
+// Count : Returns estimated number of distinct elements
+func (s *Sketch) Count() uint64 {
+	m := float64(len(s.registers))
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(m) * m * m / sum
+
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary : Encodes sketch as precision followed by registers
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	return append([]byte{s.precision}, s.registers...), nil
+}
+
+// UnmarshalBinary : Decodes sketch encoded by MarshalBinary
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 || data[0] < 4 || data[0] > 18 || len(data) != 1+1<<data[0] {
+		return errors.New("Invalid sketch data")
+	}
+
+	s.precision = data[0]
+	s.registers = append([]uint8{}, data[1:]...)
+	return nil
+}
+
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/m)
+	}
+}
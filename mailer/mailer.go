@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	h "shorts/helper"
+)
+
+// DefaultOutboxDir : Directory of FileMailer messages if MAIL_OUTBOX_DIR is not set
+const DefaultOutboxDir = "outbox"
+
+// Message : Plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer : Sends emails to users (verification links, password reset tokens, notifications)
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Default : Mailer used by the service, messages are written to DefaultOutboxDir until it is configured with FromEnv
+var Default Mailer = FileMailer{Dir: DefaultOutboxDir}
+
+// FromEnv : Returns SMTPMailer if MAIL_SMTP_ADDR is set and FileMailer writing to MAIL_OUTBOX_DIR otherwise
+func FromEnv() Mailer {
+	from := h.GetEnv("MAIL_FROM", "shorts@localhost")
+
+	if addr := h.GetEnv("MAIL_SMTP_ADDR", ""); addr != "" {
+		return SMTPMailer{
+			Addr:     addr,
+			From:     from,
+			Username: h.GetEnv("MAIL_SMTP_USER", ""),
+			Password: h.GetEnv("MAIL_SMTP_PASSWORD", ""),
+		}
+	}
+
+	return FileMailer{Dir: h.GetEnv("MAIL_OUTBOX_DIR", DefaultOutboxDir), From: from}
+}
+
+// Send : Sends message with the Default mailer
+func Send(message Message) error {
+	return Default.Send(message)
+}
+
+// SMTPMailer : Sends messages through SMTP server, e.g. local MTA relaying them.
+// PLAIN authentication is used if Username is set (Go allows it only over TLS or to localhost)
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send : Sends the message to SMTP server
+func (m SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, format(m.From, message))
+}
+
+// FileMailer : Writes messages as .eml files to a directory instead of sending them (development and tests)
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// outboxCounter : Keeps names of messages written within the same nanosecond unique
+var outboxCounter uint64
+
+// Send : Writes the message to a new file in the outbox directory
+func (m FileMailer) Send(message Message) error {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), atomic.AddUint64(&outboxCounter, 1))
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), format(m.From, message), 0600)
+}
+
+// headerReplacer : Removes line breaks from header values so they can not add headers
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// format : Returns RFC 5322 message with headers
+func format(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + headerReplacer.Replace(from) + "\r\n")
+	builder.WriteString("To: " + headerReplacer.Replace(message.To) + "\r\n")
+	builder.WriteString("Subject: " + headerReplacer.Replace(message.Subject) + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
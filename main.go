@@ -4,11 +4,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"shorts/database"
 	_ "shorts/docs"
+	"shorts/mailer"
 	"shorts/models"
+	"shorts/oidc"
+	"shorts/pages"
 	"shorts/router"
+	"shorts/urlcheck"
+	"shorts/visitor"
+	"shorts/webhooks"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -41,6 +49,53 @@ func InitDatabase() (*gorm.DB, error) {
 	db.AutoMigrate(&models.User{})
 	db.AutoMigrate(&models.Shortlink{})
 	db.AutoMigrate(&models.ShortlinkUse{})
+	db.AutoMigrate(&models.Domain{})
+	db.AutoMigrate(&models.BlocklistEntry{})
+	db.AutoMigrate(&models.ShortlinkRule{})
+	db.AutoMigrate(&models.ShortlinkDestination{})
+	db.AutoMigrate(&models.ShortlinkSketch{})
+	db.AutoMigrate(&models.Webhook{})
+	db.AutoMigrate(&models.WebhookDelivery{})
+	db.AutoMigrate(&models.WebhookThresholdHit{})
+	db.AutoMigrate(&models.Workspace{})
+	db.AutoMigrate(&models.WorkspaceMember{})
+	db.AutoMigrate(&models.AuditEntry{})
+	db.AutoMigrate(&models.UserToken{})
+	db.AutoMigrate(&models.RecoveryCode{})
+	db.AutoMigrate(&models.APIToken{})
+	db.AutoMigrate(&models.OIDCLogin{})
+	db.AutoMigrate(&models.UserIdentity{})
+	db.AutoMigrate(&models.Plan{})
+	db.AutoMigrate(&models.APIUsage{})
+	db.AutoMigrate(&models.LoginThrottle{})
+	db.AutoMigrate(&models.Setting{})
+
+	// Short links are unique per domain now (see idx_shortlinks_domain_short)
+	db.Exec("ALTER TABLE shortlinks DROP CONSTRAINT IF EXISTS shortlinks_short_key")
+
+	// Several users may claim a host, only one of them can verify it
+	db.Exec("ALTER TABLE domains DROP CONSTRAINT IF EXISTS domains_host_key")
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_host ON domains (host) WHERE verified")
+
+	// Links created before workspaces are moved to personal workspaces of their owners
+	if err := models.MigratePersonalWorkspaces(db); err != nil {
+		return nil, err
+	}
+
+	// Passwords stored in plain text by older versions are hashed
+	if _, err := models.HashStoredPasswords(db); err != nil {
+		return nil, err
+	}
+
+	// Unverified emails are pending, so they do not block users verifying them
+	if err := models.MigratePendingEmails(db); err != nil {
+		return nil, err
+	}
+
+	// Users listed in ADMIN_USERS are promoted, so the first administrator can be set up without database access
+	if _, err := models.PromoteAdmins(db, os.Getenv("ADMIN_USERS")); err != nil {
+		return nil, err
+	}
 
 	database.DB = db
 
@@ -62,8 +117,99 @@ func main() {
 	}
 	defer db.Close()
 
+	if reputationFile := os.Getenv("REPUTATION_FILE"); reputationFile != "" {
+		if urlcheck.Reputation, err = urlcheck.NewFileReputationChecker(reputationFile); err != nil {
+			fmt.Println("Cannot load reputation file:" + err.Error())
+			return
+		}
+	}
+
+	// Unique visitors are counted across restarts only if their fingerprints keep the key, so the generated one is stored
+	if os.Getenv("VISITOR_SECRET") == "" {
+		secret, err := models.GeneratedSecret(db, "visitor_secret")
+		if err != nil {
+			fmt.Println("Cannot load visitor secret:" + err.Error())
+			return
+		}
+		visitor.SetSecret([]byte(secret))
+	}
+
+	// Countries of visitors are taken from a header set by a proxy and/or a local IP ranges file
+	var locators visitor.ChainLocator
+	if geoHeader := os.Getenv("GEOIP_HEADER"); geoHeader != "" {
+		locators = append(locators, visitor.HeaderLocator{Header: geoHeader})
+	}
+	if geoFile := os.Getenv("GEOIP_FILE"); geoFile != "" {
+		rangeLocator, err := visitor.NewRangeLocator(geoFile)
+		if err != nil {
+			fmt.Println("Cannot load GeoIP file:" + err.Error())
+			return
+		}
+		locators = append(locators, rangeLocator)
+	}
+	if len(locators) > 0 {
+		visitor.Geo = locators
+	}
+
+	// Uses from crawlers and health probes are counted as bots
+	visitor.BotNetworks = visitor.ParseIPRanges(os.Getenv("BOT_IP_RANGES"))
+
+	// Operators can replace visitor pages (preview, interstitial, app) with their own templates
+	if templatesDir := os.Getenv("TEMPLATES_DIR"); templatesDir != "" {
+		if err := pages.LoadOverrides(templatesDir); err != nil {
+			fmt.Println("Cannot load templates:" + err.Error())
+			return
+		}
+	}
+
+	// Emails are sent through SMTP server or written to a local outbox
+	mailer.Default = mailer.FromEnv()
+
+	// Single sign-on is enabled if identity provider is configured
+	oidc.Current = oidc.FromEnv()
+	if models.GroupRoles, err = models.ParseGroupRoles(os.Getenv("OIDC_GROUP_ROLES")); err != nil {
+		fmt.Println("Cannot parse OIDC_GROUP_ROLES:" + err.Error())
+		return
+	}
+
+	// Queued webhook deliveries are sent in background
+	webhooks.Start(time.Duration(webhookPollSeconds()) * time.Second)
+
+	// Unique visitors and API calls are buffered by requests and written in background
+	go func() {
+		for range time.Tick(models.FlushInterval) {
+			if err := models.FlushVisitors(); err != nil {
+				fmt.Println(err)
+			}
+			if err := models.FlushAPICalls(database.DB); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	// Uses older than retention of the links' plans and forgotten sign-in failures are deleted hourly
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := models.PruneUses(database.DB); err != nil {
+				fmt.Println(err)
+			}
+			if _, err := models.PruneLoginThrottles(database.DB); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
 	// Initialize WebServer
 	r := router.SetupRouter()
 
 	r.Run()
 }
+
+// webhookPollSeconds : How often queued webhook deliveries are checked (WEBHOOK_POLL_SECONDS, default 5)
+func webhookPollSeconds() int {
+	if seconds, err := strconv.Atoi(os.Getenv("WEBHOOK_POLL_SECONDS")); err == nil && seconds > 0 {
+		return seconds
+	}
+
+	return 5
+}
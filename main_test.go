@@ -2,26 +2,53 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image/color"
+	"image/png"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"shorts/database"
 	h "shorts/helper"
+	"shorts/hll"
+	"shorts/mailer"
 	"shorts/models"
+	"shorts/oidc"
+	"shorts/pages"
+	"shorts/qrcode"
+	"shorts/ratelimit"
 	"shorts/router"
+	"shorts/slug"
+	"shorts/totp"
+	"shorts/urlcheck"
+	"shorts/visitor"
+	"shorts/webhooks"
 
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -70,6 +97,8 @@ func DeleteCreatedEntities(db *gorm.DB) func() {
 	}
 }
 
+const testUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0 Safari/537.36"
+
 func performRequest(r http.Handler, method, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
 	var req *http.Request
 	var err error
@@ -84,8 +113,17 @@ func performRequest(r http.Handler, method, path string, body string, headers ma
 		return nil
 	}
 
+	// Requests without User-Agent are counted as bots
+	req.Header.Set("User-Agent", testUserAgent)
+	// Requests come from the local proxy of TRUSTED_PROXIES in .env.test
+	req.RemoteAddr = "127.0.0.1:40000"
+
 	for k, v := range headers {
-		req.Header.Add(k, v)
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
 	}
 
 	w := httptest.NewRecorder()
@@ -128,12 +166,54 @@ func testFailedResponse(t *testing.T, w *httptest.ResponseRecorder, expectedResp
 	}
 
 	if testKeyAndValueEquality(t, response, "result", "error") {
-		return testKeyAndValueNotEmpty(t, response, "error")
+		return testKeyAndValueNotEmpty(t, response, "code") && testKeyAndValueNotEmpty(t, response, "error")
 	}
 
 	return false
 }
 
+// issueAPIToken : Signs in with the password and returns headers authorizing with the issued API token
+func issueAPIToken(t *testing.T, r http.Handler, name, password string) map[string]string {
+	var loginResponse struct {
+		Data   models.LoginResponseData `json:"data"`
+		Result string                   `json:"result"`
+	}
+	testDataResponse(t, performRequest(r, "POST", "/v1/login", `{"name":"`+name+`","password":"`+password+`"}`, getEmptyStringMap()), http.StatusCreated, &loginResponse)
+
+	return map[string]string{"Authorization": "Bearer " + loginResponse.Data.Token}
+}
+
+// addDomain : Registers custom domain and returns it with the verification token
+func addDomain(t *testing.T, r http.Handler, body string, credentials map[string]string) (models.Domain, bool) {
+	var domainResponse struct {
+		Data   models.Domain `json:"data"`
+		Result string        `json:"result"`
+	}
+	ok := testDataResponse(t, performRequest(r, "POST", "/v1/domains", body, credentials), http.StatusCreated, &domainResponse)
+
+	return domainResponse.Data, ok
+}
+
+// verifyDomain : Publishes TXT record with the verification token of the domain while its verification is requested
+func verifyDomain(r http.Handler, domain models.Domain, credentials map[string]string) *httptest.ResponseRecorder {
+	models.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if name == domain.VerificationName() {
+			return []string{"unrelated record", domain.VerificationToken}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	defer func() { models.LookupTXT = net.DefaultResolver.LookupTXT }()
+
+	return performRequest(r, "POST", "/v1/domains/"+strconv.FormatUint(domain.ID, 10)+"/verify", "", credentials)
+}
+
+// addVerifiedDomain : Registers custom domain and verifies it
+func addVerifiedDomain(t *testing.T, r http.Handler, body string, credentials map[string]string) bool {
+	domain, ok := addDomain(t, r, body, credentials)
+
+	return ok && testSuccessfulResponse(t, verifyDomain(r, domain, credentials), http.StatusOK)
+}
+
 func testSuccessfulResponse(t *testing.T, w *httptest.ResponseRecorder, expectedResponseCode int) bool {
 	if !assert.Equal(t, expectedResponseCode, w.Code) {
 		fmt.Println(w)
@@ -358,6 +438,109 @@ func TestValidation(t *testing.T) {
 	}
 }
 
+func TestCustomDomains(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const OTHER_NAME = "Test Other"
+	const USER_PASSWORD = "testPassword123"
+	const DOMAIN = "brand.test"
+	const SHORT = "promo"
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		// Link to a domain that is not registered yet
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://google.com","short":"`+SHORT+`","domain":"`+DOMAIN+`"}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/domains", `{"host":"not a host"}`, encodedCredentials), http.StatusBadRequest)
+		// Host of the service itself is reserved
+		testFailedResponse(t, performRequest(r, "POST", "/v1/domains", `{"host":"LOCALHOST:8080"}`, encodedCredentials), http.StatusBadRequest)
+
+		domain, ok := addDomain(t, r, `{"host":"Brand.Test:443"}`, encodedCredentials)
+		if !ok {
+			return
+		}
+		assert.False(t, domain.Verified)
+		assert.NotEmpty(t, domain.VerificationToken)
+		assert.Equal(t, "_shorts-verification."+DOMAIN, domain.VerificationName())
+		testFailedResponse(t, performRequest(r, "POST", "/v1/domains", `{"host":"`+DOMAIN+`"}`, encodedCredentials), http.StatusConflict)
+
+		// Other users may claim the host too, only the first one proving control gets it
+		testRegistrationResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "`+OTHER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap()))
+		otherCredentials := map[string]string{"Authorization": "Basic " + encodeCredentials(OTHER_NAME, USER_PASSWORD)}
+		otherDomain, _ := addDomain(t, r, `{"host":"`+DOMAIN+`"}`, otherCredentials)
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/domains/"+strconv.FormatUint(domain.ID, 10)+"/verify", "", encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, verifyDomain(r, domain, otherCredentials), http.StatusNotFound)
+
+		// The same short link on different domains
+		var shortlinkResponse models.ShortlinkResponse
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://google.com","short":"`+SHORT+`","domain":"`+DOMAIN+`"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			assert.Equal(t, "http://"+DOMAIN+"/"+SHORT, shortlinkResponse.Data.URL)
+		}
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://github.com","short":"`+SHORT+`"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			assert.Equal(t, "http://localhost:8080/v1/s/"+SHORT, shortlinkResponse.Data.URL)
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://github.com","short":"`+SHORT+`"}`, encodedCredentials), http.StatusConflict)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://github.com","short":"no/slashes"}`, encodedCredentials), http.StatusBadRequest)
+
+		// Links are served on the domain only after it is verified
+		testFailedResponse(t, performRequest(r, "GET", "/"+SHORT, "", map[string]string{"Host": DOMAIN}), http.StatusNotFound)
+		var verifiedResponse struct {
+			Data models.Domain `json:"data"`
+		}
+		if testDataResponse(t, verifyDomain(r, domain, encodedCredentials), http.StatusOK, &verifiedResponse) {
+			assert.True(t, verifiedResponse.Data.Verified)
+		}
+		testFailedResponse(t, verifyDomain(r, otherDomain, otherCredentials), http.StatusConflict)
+
+		redirect := performRequest(r, "GET", "/"+SHORT, "", map[string]string{"Host": DOMAIN})
+		_ = assert.Equal(t, http.StatusMovedPermanently, redirect.Code) && assert.Equal(t, "https://google.com", redirect.HeaderMap.Get("Location"))
+
+		redirect = performRequest(r, "GET", "/v1/s/"+SHORT, "", map[string]string{"Host": "localhost:8080"})
+		_ = assert.Equal(t, http.StatusMovedPermanently, redirect.Code) && assert.Equal(t, "https://github.com", redirect.HeaderMap.Get("Location"))
+
+		// Root redirects are served for the default domain only if they are enabled
+		testFailedResponse(t, performRequest(r, "GET", "/"+SHORT, "", map[string]string{"Host": "localhost:8080"}), http.StatusNotFound)
+
+		os.Setenv("ROOT_SHORTLINKS", "true")
+		redirect = performRequest(r, "GET", "/"+SHORT, "", map[string]string{"Host": "localhost:8080"})
+		_ = assert.Equal(t, http.StatusMovedPermanently, redirect.Code) && assert.Equal(t, "https://github.com", redirect.HeaderMap.Get("Location"))
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", encodedCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/unknown", "", encodedCredentials), http.StatusNotFound)
+		os.Setenv("ROOT_SHORTLINKS", "false")
+
+		// Domain can not be deleted while it has short links
+		var domains struct {
+			Data []models.Domain `json:"data"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/domains", "", encodedCredentials), http.StatusOK, &domains) && assert.Len(t, domains.Data, 1) {
+			assert.Equal(t, DOMAIN, domains.Data[0].Host)
+			testFailedResponse(t, performRequest(r, "DELETE", "/v1/domains/"+strconv.FormatUint(domains.Data[0].ID, 10), "", encodedCredentials), http.StatusBadRequest)
+		}
+	}
+}
+
 func TestStats(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
 
@@ -437,7 +620,9 @@ func TestStats(t *testing.T) {
 
 				var shortlinkResponse models.ShortlinkFullResponse
 				if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"`+link+`"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) { // should be empty list
-					if !assert.Equal(t, shortlinkResponse.Data.Full, link) {
+					// Full links are normalized, e.g. trailing slashes are removed
+					normalizedLink, _ := urlcheck.Normalize(link)
+					if !assert.Equal(t, normalizedLink, shortlinkResponse.Data.Full) {
 						return
 					}
 					shortlinkID := strconv.FormatUint(shortlinkResponse.Data.ID, 10)
@@ -488,3 +673,2483 @@ func TestStats(t *testing.T) {
 		}
 	}
 }
+
+func TestSlugGenerators(t *testing.T) {
+	hashids := slug.NewHashids("this is my salt", 0)
+	// Compatible with the reference implementation
+	assert.Equal(t, "NkK9", hashids.Encode(12345))
+
+	hashids = slug.NewHashids("shorts", 8)
+	for _, id := range []uint64{0, 1, 36, 12345, 1 << 40} {
+		hash, err := hashids.Generate(id)
+		if assert.Nil(t, err) && assert.True(t, len(hash) >= 8) {
+			decoded, err := hashids.Decode(hash)
+			_ = assert.Nil(t, err) && assert.Equal(t, id, decoded)
+		}
+	}
+	_, err := hashids.Decode("not a hash")
+	assert.NotNil(t, err)
+
+	short, err := slug.Random{Length: 10}.Generate(0)
+	_ = assert.Nil(t, err) && assert.Len(t, short, 10) && assert.True(t, h.IsValidShort(short))
+
+	short, err = slug.Words{Count: 3, Separator: "-"}.Generate(0)
+	_ = assert.Nil(t, err) && assert.Len(t, strings.Split(short, "-"), 3) && assert.True(t, h.IsValidShort(short))
+}
+
+func TestLinkSafety(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// Resolving links of other shorteners does not reach internal hosts
+	for ip, public := range map[string]bool{"93.184.216.34": true, "2606:2800:220:1::1": true, "127.0.0.1": false, "10.1.2.3": false,
+		"172.20.0.1": false, "192.168.1.1": false, "169.254.169.254": false, "::1": false, "fd00::1": false, "::ffff:127.0.0.1": false} {
+		assert.Equal(t, public, urlcheck.IsPublicIP(net.ParseIP(ip)), ip)
+	}
+	internal := httptest.NewServer(http.RedirectHandler("http://example.com/", http.StatusFound))
+	defer internal.Close()
+	os.Setenv("ALLOW_PRIVATE_NETWORKS", "false")
+	internalURL, _ := url.Parse(internal.URL)
+	_, err := urlcheck.ResolveChain(internalURL)
+	assert.Equal(t, urlcheck.ErrNonPublicAddress, err)
+	os.Unsetenv("ALLOW_PRIVATE_NETWORKS")
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		// Only http(s) links are allowed
+		for _, link := range []string{"javascript:alert(1)", "data:text/html,test", "file:///etc/passwd", "ftp://example.com/"} {
+			testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"`+link+`"}`, encodedCredentials), http.StatusBadRequest)
+		}
+
+		// Links back to the service
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"http://LOCALHOST:8080/v1/s/abc"}`, encodedCredentials), http.StatusBadRequest)
+
+		// Blocklist is available to administrators only
+		testFailedResponse(t, performRequest(r, "POST", "/v1/admin/blocklist", `{"kind":"domain","pattern":"evil.test"}`, encodedCredentials), http.StatusForbidden)
+
+		models.PromoteAdmins(database.DB, USER_NAME)
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/admin/blocklist", `{"kind":"regex","pattern":"("}`, encodedCredentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/admin/blocklist", `{"kind":"domain","pattern":"evil.test","reason":"phishing"}`, encodedCredentials), http.StatusCreated)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/admin/blocklist", `{"kind":"regex","pattern":"^https?://[^/]+/malware"}`, encodedCredentials), http.StatusCreated)
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://login.evil.test/"}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/malware.exe"}`, encodedCredentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://notevil.test/"}`, encodedCredentials), http.StatusCreated)
+
+		// Local reputation list
+		reputationFile, err := ioutil.TempFile("", "reputation")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer os.Remove(reputationFile.Name())
+		reputationFile.WriteString("# Known bad links\nbad-reputation.test\nhttps://example.com/scam\n")
+		reputationFile.Close()
+
+		urlcheck.Reputation, err = urlcheck.NewFileReputationChecker(reputationFile.Name())
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer func() { urlcheck.Reputation = nil }()
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"http://www.bad-reputation.test/"}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/scam/page"}`, encodedCredentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/"}`, encodedCredentials), http.StatusCreated)
+	}
+}
+
+func TestNormalization(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	for link, expected := range map[string]string{
+		"https://Example.com/a/":         "https://example.com/a/",
+		"https://example.com/a":          "https://example.com/a",
+		"HTTPS://EXAMPLE.COM:443/a":      "https://example.com/a",
+		"http://example.com:8080/":       "http://example.com:8080",
+		"https://münchen.de/straße":      "https://xn--mnchen-3ya.de/stra%C3%9Fe",
+		"https://例え.テスト/":                "https://xn--r8jz45g.xn--zckzah",
+		"https://example.com/?b=2&a=1#x": "https://example.com?b=2&a=1#x",
+	} {
+		normalized, err := urlcheck.Normalize(link)
+		_ = assert.Nil(t, err) && assert.Equal(t, expected, normalized)
+	}
+
+	os.Setenv("NORMALIZE_SORT_QUERY", "true")
+	normalized, _ := urlcheck.Normalize("https://example.com/?b=2&a=1")
+	assert.Equal(t, "https://example.com?a=1&b=2", normalized)
+	os.Setenv("NORMALIZE_SORT_QUERY", "false")
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var created, reused, duplicate models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://Example.com/a"}`, encodedCredentials), http.StatusCreated, &created) {
+			return
+		}
+		assert.Equal(t, "https://example.com/a", created.Data.Full)
+
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://EXAMPLE.com:443/a","reuseExisting":true}`, encodedCredentials), http.StatusOK, &reused) {
+			_ = assert.Equal(t, created.Data.ID, reused.Data.ID) && assert.Equal(t, created.Data.Short, reused.Data.Short)
+		}
+
+		// Trailing slash of a path is kept, it may lead elsewhere
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/a/","reuseExisting":true}`, encodedCredentials), http.StatusCreated, &duplicate) {
+			_ = assert.NotEqual(t, created.Data.ID, duplicate.Data.ID) && assert.Equal(t, "https://example.com/a/", duplicate.Data.Full)
+		}
+
+		// Requested custom short link is created even if the full link has one already
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/a","short":"reusedalias","reuseExisting":true}`, encodedCredentials), http.StatusCreated, &duplicate) {
+			_ = assert.NotEqual(t, created.Data.ID, duplicate.Data.ID) && assert.Equal(t, "reusedalias", duplicate.Data.Short)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	queryData := models.ShortlinkQueryData{
+		UTMSource:    "newsletter",
+		UTMCampaign:  "spring",
+		QueryParams:  models.QueryParams{"lang": "en", "ref": "shorts"},
+		ForwardQuery: true,
+	}
+
+	// UTM parameters > visitor's query > query of the full link > default parameters
+	visitorQuery := url.Values{"utm_source": {"spoofed"}, "ref": {"visitor"}, "page": {"2"}}
+	destination, err := url.Parse(queryData.BuildDestination("https://example.com/a?lang=de&ref=full", visitorQuery))
+	if assert.Nil(t, err) {
+		assert.Equal(t, url.Values{
+			"utm_source":   {"newsletter"},
+			"utm_campaign": {"spring"},
+			"ref":          {"visitor"},
+			"page":         {"2"},
+			"lang":         {"de"},
+		}, destination.Query())
+	}
+
+	// Visitor's query is ignored if forwarding is disabled
+	queryData.ForwardQuery = false
+	destination, err = url.Parse(queryData.BuildDestination("https://example.com/a", visitorQuery))
+	if assert.Nil(t, err) {
+		assert.Equal(t, url.Values{
+			"utm_source":   {"newsletter"},
+			"utm_campaign": {"spring"},
+			"ref":          {"shorts"},
+			"lang":         {"en"},
+		}, destination.Query())
+	}
+
+	// Full link is not changed if there is nothing to add
+	assert.Equal(t, "https://example.com/a?b=1&a=2", models.ShortlinkQueryData{}.BuildDestination("https://example.com/a?b=1&a=2", visitorQuery))
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/a","utmSource":"twitter"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		shortlinkID := strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+
+		redirect := performRequest(r, "GET", "/v1/s/"+shortlinkResponse.Data.Short+"?page=2", "", getEmptyStringMap())
+		assert.Equal(t, "https://example.com/a?utm_source=twitter", redirect.HeaderMap.Get("Location"))
+
+		testSuccessfulResponse(t, performRequest(r, "PATCH", "/v1/shorts/"+shortlinkID, `{"utmSource":"","forwardQuery":true}`, encodedCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "PATCH", "/v1/shorts/"+shortlinkID, `{"full":"javascript:alert(1)"}`, encodedCredentials), http.StatusBadRequest)
+
+		redirect = performRequest(r, "GET", "/v1/s/"+shortlinkResponse.Data.Short+"?page=2", "", getEmptyStringMap())
+		assert.Equal(t, "https://example.com/a?page=2", redirect.HeaderMap.Get("Location"))
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const IPHONE = "Mozilla/5.0 (iPhone; CPU iPhone OS 13_4 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	const ANDROID = "Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 Mobile Safari/537.36"
+
+	assert.Equal(t, []string{"de-at", "de", "en"}, visitor.ParseAcceptLanguage("en;q=0.5, de-AT, de;q=0.8, fr;q=0"))
+
+	saturday := time.Date(2020, 5, 2, 23, 30, 0, 0, time.UTC)
+	weekend := models.ShortlinkRule{Weekdays: "sat,sun", HourFrom: 22, HourTo: 6}
+	assert.True(t, weekend.Matches(visitor.Info{Time: saturday}))
+	assert.False(t, weekend.Matches(visitor.Info{Time: saturday.Add(-2 * time.Hour)}))
+	assert.False(t, weekend.Matches(visitor.Info{Time: saturday.Add(-24 * time.Hour)}))
+	weekend.TimeZone = "Asia/Tokyo"
+	assert.False(t, weekend.Matches(visitor.Info{Time: saturday}))
+
+	mobileInGermany := models.ShortlinkRule{Device: visitor.DeviceMobile, Country: "de,at"}
+	assert.True(t, mobileInGermany.Matches(visitor.Info{Device: visitor.DeviceAndroid, Country: "AT"}))
+	assert.False(t, mobileInGermany.Matches(visitor.Info{Device: visitor.DeviceDesktop, Country: "DE"}))
+	assert.False(t, mobileInGermany.Matches(visitor.Info{Device: visitor.DeviceIOS}))
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/app"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		shortlinkID := strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/rules", `{"rules":[{"device":"tv","target":"https://example.com/tv"}]}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/rules", `{"rules":[{"weekdays":"someday","target":"https://example.com/"}]}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/rules", `{"rules":[{"device":"ios"}]}`, encodedCredentials), http.StatusBadRequest)
+
+		rules := `{"rules":[
+			{"device":"ios","target":"https://apps.apple.com/app/id1"},
+			{"device":"android","target":"https://play.google.com/store/apps/details?id=app"},
+			{"language":"de","target":"https://example.com/de/app"}
+		]}`
+		if !testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/rules", rules, encodedCredentials), http.StatusOK) {
+			return
+		}
+
+		for headers, expected := range map[[2]string]string{
+			{IPHONE, ""}:                "https://apps.apple.com/app/id1",
+			{ANDROID, "de"}:             "https://play.google.com/store/apps/details?id=app",
+			{"Mozilla/5.0", "de-DE,en"}: "https://example.com/de/app",
+			{"Mozilla/5.0", "en,de"}:    "https://example.com/app",
+		} {
+			redirect := performRequest(r, "GET", short, "", map[string]string{"User-Agent": headers[0], "Accept-Language": headers[1]})
+			_ = assert.Equal(t, http.StatusFound, redirect.Code) && assert.Equal(t, expected, redirect.HeaderMap.Get("Location"))
+		}
+
+		var shortlinkInfo models.ShortlinkFullResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts/"+shortlinkID, "", encodedCredentials), http.StatusOK, &shortlinkInfo) && assert.Len(t, shortlinkInfo.Data.Rules, 3) {
+			for _, rule := range shortlinkInfo.Data.Rules {
+				assert.Equal(t, uint64(1), rule.Hits)
+			}
+			assert.Len(t, shortlinkInfo.Data.Uses, 4)
+		}
+	}
+}
+
+func TestSplitDestinations(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const CLICKS = 20
+
+	split := models.Shortlink{Destinations: []models.ShortlinkDestination{{ID: 1, Weight: 1}, {ID: 2, Weight: 3}}}
+	chosen := make(map[uint64]int)
+	for i := 0; i < 4000; i++ {
+		chosen[split.ChooseDestination().ID]++
+	}
+	assert.InDelta(t, 3000, chosen[2], 200)
+	assert.Nil(t, (&models.Shortlink{}).ChooseDestination())
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/landing"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		shortlinkID := strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/destinations", `{"destinations":[{"url":"https://example.com/a","weight":0}]}`, encodedCredentials), http.StatusBadRequest)
+
+		destinations := `{"sticky":true,"destinations":[
+			{"label":"A","url":"https://example.com/a","weight":1},
+			{"label":"B","url":"https://example.com/b","weight":1}
+		]}`
+		if !testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/shorts/"+shortlinkID+"/destinations", destinations, encodedCredentials), http.StatusOK) {
+			return
+		}
+
+		// Sticky visitor always gets the same destination
+		first := performRequest(r, "GET", short, "", getEmptyStringMap())
+		if !assert.Equal(t, http.StatusFound, first.Code) || !assert.Len(t, first.Result().Cookies(), 1) {
+			return
+		}
+		cookie := first.Result().Cookies()[0]
+		for i := 1; i < CLICKS; i++ {
+			redirect := performRequest(r, "GET", short, "", map[string]string{"Cookie": cookie.Name + "=" + cookie.Value})
+			assert.Equal(t, first.HeaderMap.Get("Location"), redirect.HeaderMap.Get("Location"))
+		}
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts/"+shortlinkID+"/stats", "", encodedCredentials), http.StatusOK, &stats) {
+			assert.Equal(t, uint64(CLICKS), stats.Data.UsesCount)
+			if assert.Len(t, stats.Data.Variants, 2) {
+				for _, variant := range stats.Data.Variants {
+					if variant.URL == first.HeaderMap.Get("Location") {
+						assert.Equal(t, uint64(CLICKS), variant.UsesCount)
+					} else {
+						assert.Equal(t, uint64(0), variant.UsesCount)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDeepLinks(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const DOMAIN = "app.test"
+	const IPHONE = "Mozilla/5.0 (iPhone; CPU iPhone OS 13_4 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	const ANDROID = "Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 Mobile Safari/537.36"
+
+	assert.Equal(t,
+		"intent://item/1#Intent;scheme=myapp;package=com.example;S.browser_fallback_url=https%3A%2F%2Fexample.com%2Fitem%3Fid%3D1;end",
+		models.AndroidIntentWithFallback("intent://item/1#Intent;scheme=myapp;package=com.example;end", "https://example.com/item?id=1"))
+	// Only schemes of APP_SCHEMES open apps
+	os.Setenv("APP_SCHEMES", "myapp")
+	assert.False(t, models.IsValidAppURI("javascript:alert(1)"))
+	assert.False(t, models.IsValidAppURI("otherapp://item/1"))
+	assert.True(t, models.IsValidAppURI("myapp://item/1"))
+	assert.True(t, models.IsValidAndroidIntent("intent://item/1#Intent;scheme=myapp;package=com.example;end"))
+	assert.False(t, models.IsValidAndroidIntent("intent://example.com#Intent;scheme=https;end"))
+	if fallback, err := models.AndroidIntentFallback("intent://item/1#Intent;scheme=myapp;S.browser_fallback_url=https%3A%2F%2Fexample.com%2Fitem;end"); assert.Nil(t, err) {
+		assert.Equal(t, "https://example.com/item", fallback)
+	}
+
+	page, err := pages.Render("app", pages.AppData{AppURI: "myapp://item/1", Fallback: "https://example.com/item"})
+	_ = assert.Nil(t, err) && assert.Contains(t, string(page), `window.location.href = "myapp://item/1"`)
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","appUri":"javascript:alert(1)"}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","androidIntent":"https://example.com"}`, encodedCredentials), http.StatusBadRequest)
+		// Targets of deep links are checked like full links
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","iosUniversalLink":"https://localhost:8080/v1/s/abc"}`, encodedCredentials), http.StatusBadRequest)
+		fallbackIntent := "intent://item/1#Intent;scheme=myapp;S.browser_fallback_url=" + url.QueryEscape("http://localhost:8080/v1/s/abc") + ";end"
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","androidIntent":"`+fallbackIntent+`"}`, encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","androidIntent":"intent://evil.test#Intent;scheme=https;end"}`, encodedCredentials), http.StatusBadRequest)
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/item","appUri":"myapp://item/1","androidIntent":"intent://item/1#Intent;scheme=myapp;package=com.example;end"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+
+		page := performRequest(r, "GET", short, "", map[string]string{"User-Agent": IPHONE})
+		_ = assert.Equal(t, http.StatusOK, page.Code) && assert.Contains(t, page.Body.String(), "myapp://item/1") && assert.Contains(t, page.Body.String(), "https://example.com/item")
+
+		intent := performRequest(r, "GET", short, "", map[string]string{"User-Agent": ANDROID})
+		_ = assert.Equal(t, http.StatusFound, intent.Code) && assert.True(t, strings.HasPrefix(intent.HeaderMap.Get("Location"), "intent://item/1#Intent;"))
+
+		desktop := performRequest(r, "GET", short, "", map[string]string{"User-Agent": "Mozilla/5.0"})
+		assert.Equal(t, "https://example.com/item", desktop.HeaderMap.Get("Location"))
+
+		// Association files are served only for custom domains with configured apps
+		testFailedResponse(t, performRequest(r, "GET", "/.well-known/apple-app-site-association", "", map[string]string{"Host": DOMAIN}), http.StatusNotFound)
+		addVerifiedDomain(t, r, `{"host":"`+DOMAIN+`","appleAppIds":"TEAMID.com.example","androidPackage":"com.example","androidCertFingerprints":"AA:BB"}`, encodedCredentials)
+
+		var association map[string]map[string][]map[string]interface{}
+		if testDataResponse(t, performRequest(r, "GET", "/.well-known/apple-app-site-association", "", map[string]string{"Host": DOMAIN}), http.StatusOK, &association) && assert.Len(t, association["applinks"]["details"], 1) {
+			assert.Equal(t, "TEAMID.com.example", association["applinks"]["details"][0]["appID"])
+		}
+
+		var assetLinks []map[string]interface{}
+		if testDataResponse(t, performRequest(r, "GET", "/.well-known/assetlinks.json", "", map[string]string{"Host": DOMAIN}), http.StatusOK, &assetLinks) && assert.Len(t, assetLinks, 1) {
+			assert.Equal(t, "com.example", assetLinks[0]["target"].(map[string]interface{})["package_name"])
+		}
+	}
+}
+
+func TestQRCodes(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// Capacity of version 1 with low error correction is 17 bytes
+	code, err := qrcode.Encode("https://example.com", qrcode.Low)
+	_ = assert.Nil(t, err) && assert.Equal(t, 2, code.Version) && assert.Equal(t, 25, code.Size)
+	code, err = qrcode.Encode("https://a.io/abc", qrcode.Low)
+	_ = assert.Nil(t, err) && assert.Equal(t, 1, code.Version)
+	_, err = qrcode.Encode(strings.Repeat("a", 3000), qrcode.Low)
+	assert.NotNil(t, err)
+
+	// Finder patterns are in three corners
+	_ = assert.True(t, code.Module(0, 0)) && assert.True(t, code.Module(code.Size-1, 0)) && assert.True(t, code.Module(0, code.Size-1)) && assert.False(t, code.Module(7, 7))
+
+	image, err := code.PNG(qrcode.Options{Size: 100, Margin: qrcode.DefaultMargin, Foreground: color.Black, Background: color.White})
+	if assert.Nil(t, err) {
+		if decoded, err := png.Decode(bytes.NewReader(image)); assert.Nil(t, err) {
+			// 29 modules with margin, 3 pixels each
+			assert.Equal(t, 87, decoded.Bounds().Dx())
+		}
+	}
+
+	query := url.Values{"qr": {"1"}, "ref": {"x"}}
+	_ = assert.Equal(t, models.UseSourceQR, models.TakeUseSource(query)) && assert.Equal(t, "ref=x", query.Encode())
+	assert.Equal(t, "", models.TakeUseSource(query))
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/print"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		qrPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10) + "/qr"
+
+		pngResponse := performRequest(r, "GET", qrPath+"?size=200&level=H&fg=%23112233", "", encodedCredentials)
+		_ = assert.Equal(t, http.StatusOK, pngResponse.Code) && assert.Equal(t, "image/png", pngResponse.HeaderMap.Get("Content-Type"))
+
+		svgResponse := performRequest(r, "GET", qrPath+"?format=svg&margin=0&bg=fff", "", encodedCredentials)
+		_ = assert.Equal(t, http.StatusOK, svgResponse.Code) && assert.Contains(t, svgResponse.Body.String(), `fill="#ffffff"`)
+
+		testFailedResponse(t, performRequest(r, "GET", qrPath+"?format=gif", "", encodedCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "GET", qrPath+"?fg=red", "", encodedCredentials), http.StatusBadRequest)
+
+		// Marker is not forwarded and scans are counted separately
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+		assert.Equal(t, "https://example.com/print", performRequest(r, "GET", short+"?qr=1", "", getEmptyStringMap()).HeaderMap.Get("Location"))
+		performRequest(r, "GET", short, "", getEmptyStringMap())
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts/"+strconv.FormatUint(shortlinkResponse.Data.ID, 10)+"/stats", "", encodedCredentials), http.StatusOK, &stats) {
+			_ = assert.Equal(t, uint64(2), stats.Data.UsesCount) && assert.Equal(t, uint64(1), stats.Data.QRUsesCount)
+		}
+	}
+}
+
+func TestPreviewPages(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	page, err := pages.Render("preview", pages.PreviewData{URL: "http://localhost:8080/v1/s/abc", Title: "<Sale>", Destination: "https://example.com/sale", UsesCount: 3})
+	_ = assert.Nil(t, err) && assert.Contains(t, string(page), "&lt;Sale&gt;") && assert.Contains(t, string(page), "Clicks: 3")
+
+	page, err = pages.Render("interstitial", pages.InterstitialData{Destination: "https://example.com/sale", Seconds: 5})
+	_ = assert.Nil(t, err) && assert.Contains(t, string(page), "window.location.replace(") && assert.Contains(t, string(page), "example.com")
+
+	// Operators can redefine templates
+	templatesDir, err := ioutil.TempDir("", "templates")
+	if assert.Nil(t, err) {
+		defer os.RemoveAll(templatesDir)
+		_ = ioutil.WriteFile(templatesDir+"/preview.html", []byte(`{{define "preview"}}Custom {{.Destination}}{{end}}`), 0644)
+		if assert.Nil(t, pages.LoadOverrides(templatesDir)) {
+			page, err = pages.Render("preview", pages.PreviewData{Destination: "https://example.com"})
+			_ = assert.Nil(t, err) && assert.Equal(t, "Custom https://example.com", string(page))
+		}
+	}
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/sale","title":"Big sale"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+
+		assert.Equal(t, http.StatusMovedPermanently, performRequest(r, "GET", short, "", getEmptyStringMap()).Code)
+
+		// Preview is not counted as a use
+		for i := 0; i < 2; i++ {
+			preview := performRequest(r, "GET", short+"+", "", getEmptyStringMap())
+			_ = assert.Equal(t, http.StatusOK, preview.Code) && assert.Contains(t, preview.Body.String(), "Custom https://example.com/sale")
+		}
+		testFailedResponse(t, performRequest(r, "GET", "/v1/s/missing+", "", getEmptyStringMap()), http.StatusNotFound)
+
+		testSuccessfulResponse(t, performRequest(r, "PATCH", "/v1/shorts/"+strconv.FormatUint(shortlinkResponse.Data.ID, 10), `{"interstitial":true}`, encodedCredentials), http.StatusOK)
+		interstitial := performRequest(r, "GET", short, "", getEmptyStringMap())
+		_ = assert.Equal(t, http.StatusOK, interstitial.Code) && assert.Contains(t, interstitial.Body.String(), "Big sale") && assert.Contains(t, interstitial.Body.String(), "countdown")
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts/"+strconv.FormatUint(shortlinkResponse.Data.ID, 10)+"/stats", "", encodedCredentials), http.StatusOK, &stats) {
+			assert.Equal(t, uint64(2), stats.Data.UsesCount)
+		}
+	}
+}
+
+func TestSocialUnfurl(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const SLACKBOT = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+
+	assert.True(t, visitor.IsUnfurlBot(SLACKBOT))
+	assert.True(t, visitor.IsUnfurlBot("facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)"))
+	assert.False(t, visitor.IsUnfurlBot("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/80.0 Safari/537.36"))
+
+	assert.NotNil(t, models.ShortlinkSocialData{SocialImage: "javascript:alert(1)"}.Validate())
+	assert.NotNil(t, models.ShortlinkSocialData{TwitterCard: "player"}.Validate())
+	assert.Nil(t, models.ShortlinkSocialData{SocialImage: "https://example.com/a.png", TwitterCard: models.TwitterCardLargeSummary}.Validate())
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/post","twitterCard":"player"}`, encodedCredentials), http.StatusBadRequest)
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/post","socialTitle":"Our <new> post","socialImage":"https://example.com/cover.png"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+		statsPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10) + "/stats"
+
+		unfurl := performRequest(r, "GET", short, "", map[string]string{"User-Agent": SLACKBOT})
+		if assert.Equal(t, http.StatusOK, unfurl.Code) {
+			assert.Contains(t, unfurl.Body.String(), `<meta property="og:title" content="Our &lt;new&gt; post">`)
+			assert.Contains(t, unfurl.Body.String(), `<meta property="og:image" content="https://example.com/cover.png">`)
+			assert.Contains(t, unfurl.Body.String(), `<meta name="twitter:card" content="summary">`)
+		}
+
+		// Without tags bots are redirected to the full link
+		testSuccessfulResponse(t, performRequest(r, "PATCH", "/v1/shorts/"+strconv.FormatUint(shortlinkResponse.Data.ID, 10), `{"socialTitle":"","socialImage":""}`, encodedCredentials), http.StatusOK)
+		redirect := performRequest(r, "GET", short, "", map[string]string{"User-Agent": SLACKBOT})
+		_ = assert.Equal(t, http.StatusFound, redirect.Code) && assert.Equal(t, "https://example.com/post", redirect.HeaderMap.Get("Location"))
+
+		performRequest(r, "GET", short, "", getEmptyStringMap())
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", statsPath, "", encodedCredentials), http.StatusOK, &stats) {
+			assert.Equal(t, uint64(1), stats.Data.UsesCount)
+		}
+	}
+}
+
+func TestBotFiltering(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const BROWSER = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/80.0 Safari/537.36"
+
+	assert.True(t, visitor.IsBotAgent("Googlebot/2.1 (+http://www.google.com/bot.html)"))
+	assert.True(t, visitor.IsBotAgent("curl/7.68.0"))
+	assert.True(t, visitor.IsBotAgent(""))
+	assert.False(t, visitor.IsBotAgent(BROWSER))
+	assert.True(t, visitor.IsBotAgent("Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"))
+	assert.False(t, visitor.IsBotAgent("Mozilla/5.0 (Linux; Android 10; CUBOT_X30) AppleWebKit/537.36 Chrome/80.0 Mobile Safari/537.36"))
+
+	visitor.BotNetworks = visitor.ParseIPRanges("10.0.0.0/8, 192.168.1.5, invalid")
+	assert.Len(t, visitor.BotNetworks, 2)
+	assert.True(t, visitor.IsBotIP(net.ParseIP("10.1.2.3")))
+	assert.True(t, visitor.IsBotIP(net.ParseIP("192.168.1.5")))
+	assert.False(t, visitor.IsBotIP(net.ParseIP("192.168.1.6")))
+	visitor.BotNetworks = nil
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/bots"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+		statsPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10) + "/stats"
+
+		performRequest(r, "GET", short, "", map[string]string{"User-Agent": BROWSER})
+		performRequest(r, "GET", short, "", map[string]string{"User-Agent": "Googlebot/2.1"})
+		head := performRequest(r, "HEAD", short, "", map[string]string{"User-Agent": BROWSER})
+		_ = assert.Equal(t, http.StatusMovedPermanently, head.Code) && assert.Equal(t, "https://example.com/bots", head.HeaderMap.Get("Location"))
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", statsPath, "", encodedCredentials), http.StatusOK, &stats) {
+			assert.Equal(t, uint64(1), stats.Data.UsesCount)
+		}
+		if testDataResponse(t, performRequest(r, "GET", statsPath+"?include_bots=true", "", encodedCredentials), http.StatusOK, &stats) {
+			assert.Equal(t, uint64(3), stats.Data.UsesCount)
+		}
+
+		var top models.TopDomainsResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/stats/top", "", getEmptyStringMap()), http.StatusOK, &top) && assert.Len(t, top.Data, 1) {
+			assert.Equal(t, uint64(1), top.Data[0].UsesCount)
+		}
+	}
+}
+
+func TestUniqueVisitors(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	first, _ := hll.New(hll.DefaultPrecision)
+	second, _ := hll.New(hll.DefaultPrecision)
+	for i := 0; i < 10000; i++ {
+		info := visitor.Info{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), UserAgent: testUserAgent}
+		first.Add(visitor.Fingerprint(info))
+		// Half of visitors come back
+		if i%2 == 0 {
+			second.Add(visitor.Fingerprint(info))
+		}
+	}
+	assert.InDelta(t, 10000, first.Count(), 500)
+	assert.InDelta(t, 5000, second.Count(), 250)
+
+	encoded, err := second.MarshalBinary()
+	var decoded hll.Sketch
+	_ = assert.Nil(t, err) && assert.Nil(t, decoded.UnmarshalBinary(encoded)) && assert.Nil(t, decoded.Merge(first))
+	assert.Equal(t, first.Count(), decoded.Count())
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://unique.example.com/"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+		statsPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10) + "/stats"
+
+		performRequest(r, "GET", short, "", getEmptyStringMap())
+		performRequest(r, "GET", short, "", getEmptyStringMap())
+		performRequest(r, "GET", short, "", map[string]string{"User-Agent": testUserAgent + " Edg/80.0"})
+		performRequest(r, "GET", short, "", map[string]string{"User-Agent": "curl/7.68.0"})
+
+		// Visitors are written to the sketches in background, the second flush merges into the stored sketch
+		performRequest(r, "GET", short, "", getEmptyStringMap())
+		if !assert.Nil(t, models.FlushVisitors()) {
+			return
+		}
+		performRequest(r, "GET", short, "", map[string]string{"User-Agent": testUserAgent + " Edg/80.0"})
+		assert.Nil(t, models.FlushVisitors())
+
+		today := time.Now().UTC().Format("2006-01-02")
+
+		var stats models.ShortlinkStatsResponse
+		if testDataResponse(t, performRequest(r, "GET", statsPath, "", encodedCredentials), http.StatusOK, &stats) {
+			_ = assert.Equal(t, uint64(5), stats.Data.UsesCount) && assert.Equal(t, uint64(2), stats.Data.Unique)
+			if assert.Len(t, stats.Data.Days, 1) {
+				assert.Equal(t, models.ShortlinkDayStats{Day: today, UsesCount: 5, Unique: 2}, stats.Data.Days[0])
+			}
+		}
+
+		if testDataResponse(t, performRequest(r, "GET", statsPath+"?to=2000-01-01", "", encodedCredentials), http.StatusOK, &stats) {
+			_ = assert.Equal(t, uint64(0), stats.Data.UsesCount) && assert.Equal(t, uint64(0), stats.Data.Unique) && assert.Len(t, stats.Data.Days, 0)
+		}
+		testFailedResponse(t, performRequest(r, "GET", statsPath+"?from=yesterday", "", encodedCredentials), http.StatusBadRequest)
+
+		var top models.TopDomainsResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/stats/top?from="+today, "", getEmptyStringMap()), http.StatusOK, &top) {
+			for _, domain := range top.Data {
+				if domain.Website == "unique.example.com" {
+					assert.Equal(t, uint64(2), domain.Unique)
+				}
+			}
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const SECRET = "0123456789abcdef0123"
+
+	// Local receiver checks signatures and records events
+	var received []webhooks.Payload
+	failing := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+		if r.Header.Get(webhooks.HeaderSignature) != webhooks.Sign(SECRET, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload webhooks.Payload
+		_ = json.Unmarshal(body, &payload)
+		received = append(received, payload)
+	}))
+	defer receiver.Close()
+
+	os.Setenv("WEBHOOK_RETRY_SECONDS", "10")
+	_ = assert.Equal(t, 10*time.Second, webhooks.Backoff(1)) && assert.Equal(t, 40*time.Second, webhooks.Backoff(3)) && assert.Equal(t, time.Hour, webhooks.Backoff(20))
+	os.Unsetenv("WEBHOOK_RETRY_SECONDS")
+
+	// Webhooks do not reach private addresses unless they are allowed for development
+	webhook := models.Webhook{URL: receiver.URL, Secret: SECRET}
+	os.Setenv("ALLOW_PRIVATE_NETWORKS", "false")
+	delivery := models.WebhookDelivery{Event: models.EventPing, Payload: `{"event":"ping"}`, Status: models.DeliveryPending}
+	webhooks.Attempt(webhook, &delivery)
+	_ = assert.Equal(t, models.DeliveryPending, delivery.Status) && assert.Equal(t, 0, delivery.ResponseStatus) && assert.Empty(t, received)
+	os.Setenv("ALLOW_PRIVATE_NETWORKS", "true")
+
+	delivery = models.WebhookDelivery{Event: models.EventPing, Payload: `{"event":"ping"}`, Status: models.DeliveryPending}
+	webhooks.Attempt(webhook, &delivery)
+	_ = assert.Equal(t, models.DeliveryDelivered, delivery.Status) && assert.Equal(t, http.StatusOK, delivery.ResponseStatus) && assert.Len(t, received, 1)
+
+	failing = true
+	delivery = models.WebhookDelivery{Event: models.EventPing, Payload: `{"event":"ping"}`, Status: models.DeliveryPending}
+	webhooks.Attempt(webhook, &delivery)
+	_ = assert.Equal(t, models.DeliveryPending, delivery.Status) && assert.Equal(t, 1, delivery.Attempts) && assert.True(t, delivery.NextAttemptAt.After(time.Now()))
+	delivery.Attempts = webhooks.MaxAttempts() - 1
+	webhooks.Attempt(webhook, &delivery)
+	_ = assert.Equal(t, models.DeliveryFailed, delivery.Status) && assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+	failing = false
+	received = nil
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		encodedCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		testFailedResponse(t, performRequest(r, "POST", "/v1/webhooks", `{"url":"`+receiver.URL+`","events":["link.renamed"]}`, encodedCredentials), http.StatusBadRequest)
+
+		os.Setenv("ALLOW_PRIVATE_NETWORKS", "false")
+		testFailedResponse(t, performRequest(r, "POST", "/v1/webhooks", `{"url":"http://169.254.169.254/latest/meta-data/"}`, encodedCredentials), http.StatusBadRequest)
+		os.Setenv("ALLOW_PRIVATE_NETWORKS", "true")
+
+		var webhookResponse struct {
+			Data   models.WebhookCreatedResponseData `json:"data"`
+			Result string                            `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/webhooks", `{"url":"`+receiver.URL+`","secret":"`+SECRET+`","events":["link.created","link.expired","link.clicks_threshold"],"clickThresholds":[2]}`, encodedCredentials), http.StatusCreated, &webhookResponse) {
+			return
+		}
+		assert.Equal(t, SECRET, webhookResponse.Data.Secret)
+		// Secrets are only returned on creation
+		listResponse := performRequest(r, "GET", "/v1/webhooks", "", encodedCredentials)
+		_ = assert.Equal(t, http.StatusOK, listResponse.Code) && assert.NotContains(t, listResponse.Body.String(), SECRET)
+		webhookPath := "/v1/webhooks/" + strconv.FormatUint(webhookResponse.Data.ID, 10)
+
+		var testResponse struct {
+			Data   models.WebhookDelivery `json:"data"`
+			Result string                 `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "POST", webhookPath+"/test", "", encodedCredentials), http.StatusOK, &testResponse) {
+			_ = assert.Equal(t, models.DeliveryDelivered, testResponse.Data.Status) && assert.Len(t, received, 1) && assert.Equal(t, models.EventPing, received[0].Event)
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/hooks"}`, encodedCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		shortlinkPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+
+		// Updates are not subscribed
+		testSuccessfulResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"title":"Hooks"}`, encodedCredentials), http.StatusOK)
+
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+		for i := 0; i < 3; i++ {
+			performRequest(r, "GET", short, "", getEmptyStringMap())
+		}
+
+		// Click thresholds are checked by the worker
+		assert.Nil(t, webhooks.CheckClickedLinks())
+		_, err := webhooks.DeliverDue()
+		if assert.Nil(t, err) && assert.Len(t, received, 3) {
+			assert.Equal(t, models.EventLinkCreated, received[1].Event)
+			assert.Equal(t, models.EventLinkClicksThreshold, received[2].Event)
+			assert.Equal(t, float64(2), received[2].Data.(map[string]interface{})["threshold"])
+		}
+
+		// Expired links are not redirected and reported once
+		testSuccessfulResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"expiresAt":"2000-01-01T00:00:00Z"}`, encodedCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", short, "", getEmptyStringMap()), http.StatusGone)
+		testFailedResponse(t, performRequest(r, "GET", short+"+", "", getEmptyStringMap()), http.StatusGone)
+		_ = assert.Nil(t, webhooks.NotifyExpiredLinks()) && assert.Nil(t, webhooks.NotifyExpiredLinks())
+		if _, err := webhooks.DeliverDue(); assert.Nil(t, err) && assert.Len(t, received, 4) {
+			assert.Equal(t, models.EventLinkExpired, received[3].Event)
+		}
+
+		var deliveriesResponse struct {
+			Data   []models.WebhookDelivery `json:"data"`
+			Result string                   `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", webhookPath+"/deliveries", "", encodedCredentials), http.StatusOK, &deliveriesResponse) {
+			assert.Len(t, deliveriesResponse.Data, 4)
+		}
+
+		testSuccessfulResponse(t, performRequest(r, "DELETE", webhookPath, "", encodedCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "POST", webhookPath+"/test", "", encodedCredentials), http.StatusNotFound)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	const OWNER_NAME = "Test Owner"
+	const MEMBER_NAME = "Test Member"
+	const USER_PASSWORD = "testPassword123"
+
+	assert.True(t, models.RoleAllows(models.RoleOwner, models.RoleEditor))
+	assert.True(t, models.RoleAllows(models.RoleEditor, models.RoleEditor))
+	assert.False(t, models.RoleAllows(models.RoleViewer, models.RoleEditor))
+	assert.False(t, models.RoleAllows("", models.RoleViewer))
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	ownerReg := performRequest(r, "POST", "/v1/users", `{"name": "`+OWNER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	memberReg := performRequest(r, "POST", "/v1/users", `{"name": "`+MEMBER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, ownerReg) && testRegistrationResponse(t, memberReg) {
+		ownerCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(OWNER_NAME, USER_PASSWORD),
+		}
+		memberCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(MEMBER_NAME, USER_PASSWORD),
+		}
+
+		var workspacesResponse struct {
+			Data   []models.WorkspaceResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/workspaces", "", ownerCredentials), http.StatusOK, &workspacesResponse) &&
+			assert.Len(t, workspacesResponse.Data, 1) {
+			assert.True(t, workspacesResponse.Data[0].Personal)
+			assert.Equal(t, models.RoleOwner, workspacesResponse.Data[0].Role)
+			testFailedResponse(t, performRequest(r, "PUT", "/v1/workspaces/"+strconv.FormatUint(workspacesResponse.Data[0].ID, 10)+"/members",
+				`{"name":"`+MEMBER_NAME+`","role":"viewer"}`, ownerCredentials), http.StatusBadRequest)
+		}
+
+		var workspaceResponse struct {
+			Data   models.WorkspaceResponseData `json:"data"`
+			Result string                       `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/workspaces", `{"name":"Team"}`, ownerCredentials), http.StatusCreated, &workspaceResponse) {
+			return
+		}
+		workspaceID := strconv.FormatUint(workspaceResponse.Data.ID, 10)
+		workspacePath := "/v1/workspaces/" + workspaceID
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/team","workspaceId":`+workspaceID+`}`, ownerCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		assert.Equal(t, workspaceResponse.Data.ID, shortlinkResponse.Data.WorkspaceID)
+		shortlinkPath := "/v1/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+
+		// Links of other workspaces are not visible
+		testFailedResponse(t, performRequest(r, "GET", shortlinkPath, "", memberCredentials), http.StatusNotFound)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/other","workspaceId":`+workspaceID+`}`, memberCredentials), http.StatusNotFound)
+
+		// Viewers read links and stats only
+		testSuccessfulResponse(t, performRequest(r, "PUT", workspacePath+"/members", `{"name":"`+MEMBER_NAME+`","role":"viewer"}`, ownerCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "GET", shortlinkPath, "", memberCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "GET", shortlinkPath+"/stats", "", memberCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"title":"Team"}`, memberCredentials), http.StatusForbidden)
+		testFailedResponse(t, performRequest(r, "DELETE", shortlinkPath, "", memberCredentials), http.StatusForbidden)
+		testFailedResponse(t, performRequest(r, "PUT", workspacePath+"/members", `{"name":"`+MEMBER_NAME+`","role":"owner"}`, memberCredentials), http.StatusForbidden)
+
+		var shortlinksResponse models.ShortlinksResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts?workspace="+workspaceID, "", memberCredentials), http.StatusOK, &shortlinksResponse) {
+			assert.Len(t, shortlinksResponse.Data, 1)
+		}
+
+		// Editors change links
+		testSuccessfulResponse(t, performRequest(r, "PUT", workspacePath+"/members", `{"name":"`+MEMBER_NAME+`","role":"editor"}`, ownerCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"title":"Team"}`, memberCredentials), http.StatusOK)
+
+		var membersResponse struct {
+			Data   []models.WorkspaceMemberResponseData `json:"data"`
+			Result string                               `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", workspacePath+"/members", "", memberCredentials), http.StatusOK, &membersResponse) {
+			assert.Len(t, membersResponse.Data, 2)
+		}
+
+		// The last owner can not leave and workspaces with links can not be deleted
+		var ownerResponse models.UserResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", ownerCredentials), http.StatusOK, &ownerResponse) {
+			testFailedResponse(t, performRequest(r, "DELETE", workspacePath+"/members/"+strconv.FormatUint(ownerResponse.Data.ID, 10), "", ownerCredentials), http.StatusBadRequest)
+		}
+		testFailedResponse(t, performRequest(r, "DELETE", workspacePath, "", ownerCredentials), http.StatusBadRequest)
+
+		testSuccessfulResponse(t, performRequest(r, "DELETE", shortlinkPath, "", memberCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "DELETE", workspacePath, "", memberCredentials), http.StatusForbidden)
+		testSuccessfulResponse(t, performRequest(r, "DELETE", workspacePath, "", ownerCredentials), http.StatusOK)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	const ADMIN_NAME = "Test Admin"
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	adminReg := performRequest(r, "POST", "/v1/users", `{"name": "`+ADMIN_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	userReg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, adminReg) && testRegistrationResponse(t, userReg) {
+		adminCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(ADMIN_NAME, USER_PASSWORD),
+		}
+		userCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		testFailedResponse(t, performRequest(r, "GET", "/v1/admin/users", "", adminCredentials), http.StatusForbidden)
+
+		// The first administrator is set up with ADMIN_USERS, users listed there are promoted at startup
+		promoted, err := models.PromoteAdmins(database.DB, " "+ADMIN_NAME+", ")
+		if !assert.Nil(t, err) || !assert.Equal(t, int64(1), promoted) {
+			return
+		}
+
+		var usersResponse struct {
+			Data   []models.AdminUserResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "GET", "/v1/admin/users?q="+url.QueryEscape(USER_NAME), "", adminCredentials), http.StatusOK, &usersResponse) ||
+			!assert.Len(t, usersResponse.Data, 1) {
+			return
+		}
+		userPath := "/v1/admin/users/" + strconv.FormatUint(usersResponse.Data[0].ID, 10)
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/abuse"}`, userCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		shortlinkPath := "/v1/admin/shorts/" + strconv.FormatUint(shortlinkResponse.Data.ID, 10)
+		short := "/v1/s/" + shortlinkResponse.Data.Short
+
+		// Disabled links are gone for visitors
+		testSuccessfulResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"disabled":true,"reason":"spam"}`, adminCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", short, "", getEmptyStringMap()), http.StatusGone)
+		testSuccessfulResponse(t, performRequest(r, "PATCH", shortlinkPath, `{"disabled":false}`, adminCredentials), http.StatusOK)
+		assert.Equal(t, http.StatusMovedPermanently, performRequest(r, "GET", short, "", getEmptyStringMap()).Code)
+
+		var statsResponse struct {
+			Data   models.SystemStatsResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/admin/stats", "", adminCredentials), http.StatusOK, &statsResponse) {
+			assert.True(t, statsResponse.Data.UsersCount >= 2)
+			assert.True(t, statsResponse.Data.LinksCount >= 1)
+		}
+
+		// Suspended users can not sign in
+		testSuccessfulResponse(t, performRequest(r, "PATCH", userPath, `{"suspended":true}`, adminCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", userCredentials), http.StatusForbidden)
+		testSuccessfulResponse(t, performRequest(r, "PATCH", userPath, `{"suspended":false,"role":"admin"}`, adminCredentials), http.StatusOK)
+
+		// Promoted users are administrators too
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/admin/stats", "", userCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "PATCH", userPath, `{"role":"user"}`, userCredentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "PATCH", userPath, `{"role":"user"}`, adminCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/admin/stats", "", userCredentials), http.StatusForbidden)
+
+		testSuccessfulResponse(t, performRequest(r, "DELETE", userPath, "", adminCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", userCredentials), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "GET", short, "", getEmptyStringMap()), http.StatusNotFound)
+
+		var auditResponse struct {
+			Data   []models.AuditEntry `json:"data"`
+			Result string              `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/admin/audit?target_type=user&target_id="+strconv.FormatUint(usersResponse.Data[0].ID, 10), "", adminCredentials), http.StatusOK, &auditResponse) &&
+			assert.Len(t, auditResponse.Data, 4) {
+			assert.Equal(t, models.AuditUserDeleted, auditResponse.Data[0].Action)
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/admin/audit?action=link.disabled", "", adminCredentials), http.StatusOK, &auditResponse) &&
+			assert.NotEmpty(t, auditResponse.Data) {
+			assert.Contains(t, auditResponse.Data[0].Details, "spam")
+		}
+	}
+}
+
+func TestAccountManagement(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const OTHER_NAME = "Test Other"
+	const RENAMED_NAME = "Test Renamed"
+	const USER_PASSWORD = "correct horse battery staple"
+	const NEW_PASSWORD = "testPassword123"
+
+	// Passwords are stored as bcrypt hashes
+	hash, err := models.HashPassword(USER_PASSWORD)
+	assert.Nil(t, err)
+	assert.NotEqual(t, USER_PASSWORD, hash)
+	hashed := models.User{Password: hash}
+	assert.True(t, hashed.CheckPassword(USER_PASSWORD))
+	assert.False(t, hashed.CheckPassword(NEW_PASSWORD))
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	// Passphrases longer than 16 characters are accepted
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	otherReg := performRequest(r, "POST", "/v1/users", `{"name": "`+OTHER_NAME+`", "password": "`+NEW_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) && testRegistrationResponse(t, otherReg) {
+		credentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		// API tokens are revoked with the old password
+		tokenCredentials := issueAPIToken(t, r, USER_NAME, USER_PASSWORD)
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/me/password", `{"currentPassword":"wrong password","newPassword":"`+NEW_PASSWORD+`"}`, credentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/me/password", `{"currentPassword":"`+USER_PASSWORD+`","newPassword":"`+NEW_PASSWORD+`"}`, tokenCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", tokenCredentials), http.StatusUnauthorized)
+		credentials["Authorization"] = "Basic " + encodeCredentials(USER_NAME, NEW_PASSWORD)
+
+		testFailedResponse(t, performRequest(r, "PUT", "/v1/me/name", `{"name":"`+OTHER_NAME+`"}`, credentials), http.StatusConflict)
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/me/name", `{"name":"`+RENAMED_NAME+`"}`, credentials), http.StatusOK)
+		credentials["Authorization"] = "Basic " + encodeCredentials(RENAMED_NAME, NEW_PASSWORD)
+		otherCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(OTHER_NAME, NEW_PASSWORD),
+		}
+
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/export"}`, credentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+
+		var exportResponse struct {
+			Data   models.AccountExport `json:"data"`
+			Result string               `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me/export", "", credentials), http.StatusOK, &exportResponse) {
+			assert.Equal(t, RENAMED_NAME, exportResponse.Data.User.Name)
+			_ = assert.Len(t, exportResponse.Data.Links, 1) && assert.Equal(t, "https://example.com/export", exportResponse.Data.Links[0].Full)
+			_ = assert.Len(t, exportResponse.Data.Workspaces, 1) && assert.Equal(t, RENAMED_NAME, exportResponse.Data.Workspaces[0].Name)
+		}
+
+		// Links are transferred only to owners of workspaces the user is a member of
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me", `{"password":"`+NEW_PASSWORD+`","links":"transfer","transferTo":"`+OTHER_NAME+`"}`, credentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me", `{"password":"`+NEW_PASSWORD+`","links":"transfer","transferTo":""}`, credentials), http.StatusBadRequest)
+
+		var workspaceResponse struct {
+			Data   models.WorkspaceResponseData `json:"data"`
+			Result string                       `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/workspaces", `{"name":"Own Team"}`, credentials), http.StatusCreated, &workspaceResponse) {
+			return
+		}
+		ownWorkspacePath := "/v1/workspaces/" + strconv.FormatUint(workspaceResponse.Data.ID, 10)
+		testSuccessfulResponse(t, performRequest(r, "PUT", ownWorkspacePath+"/members", `{"name":"`+OTHER_NAME+`","role":"viewer"}`, credentials), http.StatusOK)
+		// Adding the recipient to the user's own workspace does not make the recipient accept links
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me", `{"password":"`+NEW_PASSWORD+`","links":"transfer","transferTo":"`+OTHER_NAME+`"}`, credentials), http.StatusBadRequest)
+
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/workspaces", `{"name":"Team"}`, otherCredentials), http.StatusCreated, &workspaceResponse) {
+			return
+		}
+		workspaceID := strconv.FormatUint(workspaceResponse.Data.ID, 10)
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/workspaces/"+workspaceID+"/members", `{"name":"`+RENAMED_NAME+`","role":"editor"}`, otherCredentials), http.StatusOK)
+		var teamLinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/team","workspaceId":`+workspaceID+`}`, credentials), http.StatusCreated, &teamLinkResponse) {
+			return
+		}
+
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me", `{"password":"wrong password","links":"delete"}`, credentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "DELETE", "/v1/me", `{"password":"`+NEW_PASSWORD+`","links":"transfer","transferTo":"`+OTHER_NAME+`"}`, credentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusUnauthorized)
+
+		// The recipient owns the link of the recipient's workspace, the personal link was deleted
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/shorts/"+strconv.FormatUint(teamLinkResponse.Data.ID, 10), "", otherCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/shorts/"+strconv.FormatUint(shortlinkResponse.Data.ID, 10), "", otherCredentials), http.StatusNotFound)
+		var workspacesResponse struct {
+			Data   []models.WorkspaceResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/workspaces", "", otherCredentials), http.StatusOK, &workspacesResponse) && assert.Len(t, workspacesResponse.Data, 3) {
+			assert.Equal(t, models.RoleOwner, workspacesResponse.Data[1].Role)
+		}
+		testSuccessfulResponse(t, performRequest(r, "DELETE", ownWorkspacePath, "", otherCredentials), http.StatusOK)
+	}
+}
+
+// recordingMailer : Keeps sent messages in memory
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(message mailer.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestEmailVerification(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const NEW_PASSWORD = "newPassword123"
+	const USER_EMAIL = "Test@Example.com"
+
+	// File outbox works offline
+	outbox, err := ioutil.TempDir("", "outbox")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(outbox)
+	if assert.Nil(t, mailer.FileMailer{Dir: outbox, From: "shorts@localhost"}.Send(mailer.Message{To: "a@example.com", Subject: "Hi\r\nBcc: b@example.com", Body: "Text"})) {
+		files, _ := ioutil.ReadDir(outbox)
+		if assert.Len(t, files, 1) {
+			content, _ := ioutil.ReadFile(filepath.Join(outbox, files[0].Name()))
+			assert.Contains(t, string(content), "To: a@example.com\r\n")
+			assert.NotContains(t, string(content), "\r\nBcc:")
+		}
+	}
+
+	tokenPattern := regexp.MustCompile(`[0-9a-f]{64}`)
+	sent := &recordingMailer{}
+	mailer.Default = sent
+	defer func() { mailer.Default = mailer.FileMailer{Dir: mailer.DefaultOutboxDir} }()
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	os.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+	defer os.Setenv("REQUIRE_EMAIL_VERIFICATION", "false")
+
+	testFailedResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap()), http.StatusBadRequest)
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`", "email": "`+USER_EMAIL+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) && assert.Len(t, sent.messages, 1) {
+		credentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		assert.Equal(t, "test@example.com", sent.messages[0].To)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusForbidden)
+
+		// Unverified email does not block other users, the first one verifying it gets it
+		testRegistrationResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "Test Other", "password": "`+USER_PASSWORD+`", "email": "TEST@example.com"}`, getEmptyStringMap()))
+
+		// Only the latest link is valid and only once
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/users/verify/resend", `{"email":"`+USER_EMAIL+`"}`, getEmptyStringMap()), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/users/verify/resend", `{"email":"unknown@example.com"}`, getEmptyStringMap()), http.StatusOK)
+		if !assert.Len(t, sent.messages, 4) {
+			return
+		}
+		firstToken := tokenPattern.FindString(sent.messages[0].Body)
+		token := tokenPattern.FindString(sent.messages[2].Body)
+		otherToken := tokenPattern.FindString(sent.messages[3].Body)
+		// Opening the link only shows the confirmation page, posting its form verifies the email
+		page := performRequest(r, "GET", "/v1/users/verify?token="+token, "", getEmptyStringMap())
+		assert.Equal(t, http.StatusOK, page.Code)
+		assert.Contains(t, page.Body.String(), `name="token" value="`+token+`"`)
+		formHeaders := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users/verify", `{"token":"`+firstToken+`"}`, getEmptyStringMap()), http.StatusBadRequest)
+		verified := performRequest(r, "POST", "/v1/users/verify", "token="+url.QueryEscape(token), formHeaders)
+		assert.Equal(t, http.StatusOK, verified.Code)
+		assert.Contains(t, verified.Body.String(), "Your email is verified")
+		assert.Equal(t, http.StatusBadRequest, performRequest(r, "POST", "/v1/users/verify", "token="+url.QueryEscape(token), formHeaders).Code)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users/verify", `{"token":"`+token+`"}`, getEmptyStringMap()), http.StatusBadRequest)
+
+		// The email is taken once verified
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users/verify", `{"token":"`+otherToken+`"}`, getEmptyStringMap()), http.StatusConflict)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "Test Third", "password": "`+USER_PASSWORD+`", "email": "TEST@example.com"}`, getEmptyStringMap()), http.StatusConflict)
+
+		var userResponse models.UserResponse
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK, &userResponse) && assert.NotNil(t, userResponse.Data.Email) {
+			assert.True(t, userResponse.Data.EmailVerified)
+			assert.Equal(t, "test@example.com", *userResponse.Data.Email)
+			assert.Nil(t, userResponse.Data.PendingEmail)
+		}
+
+		// Password reset
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/users/password-reset", `{"email":"`+USER_EMAIL+`"}`, getEmptyStringMap()), http.StatusOK)
+		if !assert.Len(t, sent.messages, 5) {
+			return
+		}
+		token = tokenPattern.FindString(sent.messages[4].Body)
+		tokenCredentials := issueAPIToken(t, r, USER_NAME, USER_PASSWORD)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users/password-reset/confirm", `{"token":"`+strings.Repeat("0", 64)+`","newPassword":"`+NEW_PASSWORD+`"}`, getEmptyStringMap()), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/users/password-reset/confirm", `{"token":"`+token+`","newPassword":"`+NEW_PASSWORD+`"}`, getEmptyStringMap()), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/users/password-reset/confirm", `{"token":"`+token+`","newPassword":"`+USER_PASSWORD+`"}`, getEmptyStringMap()), http.StatusBadRequest)
+
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", tokenCredentials), http.StatusUnauthorized)
+		credentials["Authorization"] = "Basic " + encodeCredentials(USER_NAME, NEW_PASSWORD)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK)
+
+		// Changed email has to be verified, the current one is kept until then
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/me/email", `{"email":"new@example.com","password":"`+NEW_PASSWORD+`"}`, credentials), http.StatusOK)
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK, &userResponse) && assert.NotNil(t, userResponse.Data.PendingEmail) {
+			assert.Equal(t, "test@example.com", *userResponse.Data.Email)
+			assert.Equal(t, "new@example.com", *userResponse.Data.PendingEmail)
+		}
+		if !assert.Len(t, sent.messages, 6) || !assert.Equal(t, "new@example.com", sent.messages[5].To) {
+			return
+		}
+		token = tokenPattern.FindString(sent.messages[5].Body)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/users/verify", `{"token":"`+token+`"}`, getEmptyStringMap()), http.StatusOK)
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK, &userResponse) && assert.NotNil(t, userResponse.Data.Email) {
+			assert.Equal(t, "new@example.com", *userResponse.Data.Email)
+			assert.Nil(t, userResponse.Data.PendingEmail)
+		}
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// RFC 6238 test vectors (SHA-1, last 6 digits)
+	rfcSecret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for timestamp, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := totp.CodeAt(rfcSecret, totp.Step(time.Unix(timestamp, 0)))
+		_ = assert.Nil(t, err) && assert.Equal(t, expected, code)
+	}
+	if step, ok := totp.Validate(rfcSecret, "287082", time.Unix(59+30, 0)); assert.True(t, ok) {
+		assert.Equal(t, int64(1), step)
+	}
+	_, ok := totp.Validate(rfcSecret, "287082", time.Unix(59+90, 0))
+	assert.False(t, ok)
+	if uri, err := url.Parse(totp.ProvisioningURI("Shorts", USER_NAME, rfcSecret)); assert.Nil(t, err) {
+		_ = assert.Equal(t, "otpauth", uri.Scheme) && assert.Equal(t, rfcSecret, uri.Query().Get("secret"))
+	}
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		credentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+		login := `{"name":"` + USER_NAME + `","password":"` + USER_PASSWORD + `"`
+
+		var setupResponse struct {
+			Data   models.TwoFactorSetupResponseData `json:"data"`
+			Result string                            `json:"result"`
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/me/2fa", `{"password":"wrong password"}`, credentials), http.StatusBadRequest)
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/me/2fa", `{"password":"`+USER_PASSWORD+`"}`, credentials), http.StatusOK, &setupResponse) {
+			return
+		}
+		secret := setupResponse.Data.Secret
+		assert.True(t, strings.HasPrefix(setupResponse.Data.QRCode, "data:image/svg+xml;base64,"))
+
+		// Pending secret does not change signing in
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK)
+
+		earlyCredentials := issueAPIToken(t, r, USER_NAME, USER_PASSWORD)
+
+		code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+		testFailedResponse(t, performRequest(r, "POST", "/v1/me/2fa/confirm", `{"code":"000000"}`, credentials), http.StatusBadRequest)
+		var recoveryResponse struct {
+			Data   models.RecoveryCodesResponseData `json:"data"`
+			Result string                           `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/me/2fa/confirm", `{"code":"`+code+`"}`, credentials), http.StatusOK, &recoveryResponse) ||
+			!assert.Len(t, recoveryResponse.Data.RecoveryCodes, models.RecoveryCodesCount) {
+			return
+		}
+
+		// Password alone is not enough anymore, tokens issued without the code are revoked
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", earlyCredentials), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/login", login+`}`, getEmptyStringMap()), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/login", login+`,"code":"`+code+`"}`, getEmptyStringMap()), http.StatusUnauthorized)
+
+		var loginResponse struct {
+			Data   models.LoginResponseData `json:"data"`
+			Result string                   `json:"result"`
+		}
+		nextCode, _ := totp.CodeAt(secret, totp.Step(time.Now())+1)
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/login", login+`,"code":"`+nextCode+`","tokenName":"cli"}`, getEmptyStringMap()), http.StatusCreated, &loginResponse) {
+			return
+		}
+		tokenCredentials := map[string]string{"Authorization": "Bearer " + loginResponse.Data.Token}
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", tokenCredentials), http.StatusOK)
+
+		// Recovery codes are single-use
+		recovery := login + `,"recoveryCode":"` + strings.ToUpper(recoveryResponse.Data.RecoveryCodes[0]) + `","expiresInDays":1}`
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/login", recovery, getEmptyStringMap()), http.StatusCreated)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/login", recovery, getEmptyStringMap()), http.StatusUnauthorized)
+
+		var tokensResponse struct {
+			Data   []models.APIToken `json:"data"`
+			Result string            `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me/tokens", "", tokenCredentials), http.StatusOK, &tokensResponse) && assert.Len(t, tokensResponse.Data, 2) {
+			assert.Equal(t, "cli", tokensResponse.Data[0].Name)
+			assert.NotNil(t, tokensResponse.Data[1].ExpiresAt)
+
+			testSuccessfulResponse(t, performRequest(r, "DELETE", "/v1/me/tokens/"+strconv.FormatUint(tokensResponse.Data[0].ID, 10), "", tokenCredentials), http.StatusOK)
+			testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", tokenCredentials), http.StatusUnauthorized)
+		}
+
+		// Codes can not be guessed with the right password, wrong codes lock the name out
+		os.Setenv("LOGIN_MAX_FAILURES", "3")
+		defer os.Setenv("LOGIN_MAX_FAILURES", "1000")
+		for _, wrongCode := range []string{"000000", "000001", "000002"} {
+			if wrongCode != nextCode {
+				testFailedResponse(t, performRequest(r, "POST", "/v1/login", login+`,"code":"`+wrongCode+`"}`, getEmptyStringMap()), http.StatusUnauthorized)
+			}
+		}
+		lastCode, _ := totp.CodeAt(secret, totp.Step(time.Now())+2)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/login", login+`,"code":"`+lastCode+`"}`, getEmptyStringMap()), http.StatusTooManyRequests)
+		assert.Nil(t, models.UnlockUser(database.DB, USER_NAME))
+
+		// Codes can not be guessed through the account routes either
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/login", login+`,"recoveryCode":"`+recoveryResponse.Data.RecoveryCodes[1]+`"}`, getEmptyStringMap()), http.StatusCreated, &loginResponse) {
+			return
+		}
+		recoveryCredentials := map[string]string{"Authorization": "Bearer " + loginResponse.Data.Token}
+		disable := func(code string) string {
+			return `{"password":"` + USER_PASSWORD + `","code":"` + code + `"}`
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/me/2fa/recovery-codes", `{"code":"000003"}`, recoveryCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/me/2fa/recovery-codes", `{"code":"000004"}`, recoveryCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me/2fa", disable("000005"), recoveryCredentials), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "DELETE", "/v1/me/2fa", disable(recoveryResponse.Data.RecoveryCodes[2]), recoveryCredentials), http.StatusTooManyRequests)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/me/2fa/recovery-codes", `{"code":"`+lastCode+`"}`, recoveryCredentials), http.StatusTooManyRequests)
+		assert.Nil(t, models.UnlockUser(database.DB, USER_NAME))
+
+		// Disabling two-factor authentication revokes API tokens
+		testSuccessfulResponse(t, performRequest(r, "DELETE", "/v1/me/2fa", disable(recoveryResponse.Data.RecoveryCodes[2]), recoveryCredentials), http.StatusOK)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", recoveryCredentials), http.StatusUnauthorized)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK)
+	}
+}
+
+// mockOIDCProvider : Identity provider signing ID tokens with its own key, the authorization endpoint signs in immediately
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// Claims of the user signing in next
+	claims map[string]interface{}
+	// Authorization requests by issued codes
+	requests map[string]url.Values
+}
+
+func newMockOIDCProvider() *mockOIDCProvider {
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	provider := &mockOIDCProvider{key: key, requests: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	provider.server = httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidc.Metadata{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JWKSURI:               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := strconv.Itoa(len(provider.requests) + 1)
+		provider.requests[code] = r.URL.Query()
+		http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(r.URL.Query().Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		request, exists := provider.requests[r.PostFormValue("code")]
+		if !exists || oidc.Challenge(r.PostFormValue("code_verifier")) != request.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(provider.requests, r.PostFormValue("code"))
+
+		claims := map[string]interface{}{
+			"iss":   provider.server.URL,
+			"aud":   request.Get("client_id"),
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": request.Get("nonce"),
+		}
+		for name, value := range provider.claims {
+			claims[name] = value
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": provider.sign(claims)})
+	})
+
+	return provider
+}
+
+// sign : Returns RS256-signed JWT with the claims
+func (p *mockOIDCProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(cryptorand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize : Opens login page URL at the provider and returns the callback URL it redirects to
+func (p *mockOIDCProvider) authorize(loginURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(loginURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return response.Location()
+}
+
+func TestOIDC(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// RFC 7636 example
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	if groupRoles, err := models.ParseGroupRoles("engineering=12:editor, eng=leads=12:owner"); assert.Nil(t, err) && assert.Len(t, groupRoles, 2) {
+		assert.Equal(t, models.GroupRole{Group: "eng=leads", WorkspaceID: 12, Role: models.RoleOwner}, groupRoles[1])
+	}
+	for _, invalid := range []string{"engineering", "engineering=12", "engineering=twelve:editor", "engineering=12:admin"} {
+		_, err := models.ParseGroupRoles(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	mock := newMockOIDCProvider()
+	defer mock.server.Close()
+
+	provider := &oidc.Provider{
+		Issuer:      mock.server.URL,
+		ClientID:    "shorts",
+		RedirectURL: "http://localhost:8080/v1/oidc/callback",
+		Scopes:      []string{"openid", "profile", "email"},
+		GroupsClaim: "groups",
+	}
+	mock.claims = map[string]interface{}{"sub": "alice", "preferred_username": "Test OIDC", "groups": []string{"engineering"}}
+
+	// ID tokens are accepted only with the PKCE verifier, the nonce of the request and the provider's signature
+	verifier, _ := oidc.NewRandom()
+	loginURL, err := provider.AuthURL("state", "nonce", verifier)
+	if !assert.Nil(t, err) {
+		return
+	}
+	callback, err := mock.authorize(loginURL)
+	if !assert.Nil(t, err) || !assert.Equal(t, "state", callback.Query().Get("state")) {
+		return
+	}
+	_, err = provider.Exchange(callback.Query().Get("code"), "wrong verifier", "nonce")
+	assert.NotNil(t, err)
+
+	callback, _ = mock.authorize(loginURL)
+	_, err = provider.Exchange(callback.Query().Get("code"), verifier, "another nonce")
+	assert.NotNil(t, err)
+
+	callback, _ = mock.authorize(loginURL)
+	if claims, err := provider.Exchange(callback.Query().Get("code"), verifier, "nonce"); assert.Nil(t, err) {
+		_ = assert.Equal(t, "alice", claims.Subject) && assert.Equal(t, []string{"engineering"}, claims.Groups)
+	}
+
+	token := mock.sign(map[string]interface{}{"iss": mock.server.URL, "aud": "shorts", "sub": "alice", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()})
+	_, err = provider.Verify(token, "", time.Now())
+	assert.Nil(t, err)
+	_, err = provider.Verify(token, "", time.Now().Add(2*time.Hour))
+	assert.NotNil(t, err)
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+mock.server.URL+`","aud":"shorts","sub":"bob"}`)) + "." + parts[2]
+	_, err = provider.Verify(forged, "", time.Now())
+	assert.NotNil(t, err)
+
+	// Init local env
+	err = godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	oidc.Current = provider
+	defer func() {
+		oidc.Current = nil
+		models.GroupRoles = nil
+	}()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	// stateCookie : Returns headers sending the state cookie the browser got at the start of sign-on
+	stateCookie := func(start *httptest.ResponseRecorder) map[string]string {
+		for _, cookie := range start.Result().Cookies() {
+			if cookie.Name == "oidc_state" {
+				assert.True(t, cookie.HttpOnly)
+				return map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
+			}
+		}
+		return getEmptyStringMap()
+	}
+
+	// signIn : Goes through the provider's login page and returns the callback response
+	signIn := func(start *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		loginURL := start.Header().Get("Location")
+		if start.Code == http.StatusOK {
+			var linkResponse struct {
+				Data   models.OIDCLinkResponseData `json:"data"`
+				Result string                      `json:"result"`
+			}
+			_ = json.Unmarshal(start.Body.Bytes(), &linkResponse)
+			loginURL = linkResponse.Data.URL
+		}
+
+		callback, err := mock.authorize(loginURL)
+		if !assert.Nil(t, err) {
+			return httptest.NewRecorder()
+		}
+		return performRequest(r, "GET", callback.RequestURI(), "", stateCookie(start))
+	}
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		credentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		var workspaceResponse struct {
+			Data   models.WorkspaceResponseData `json:"data"`
+			Result string                       `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/workspaces", `{"name":"Engineering"}`, credentials), http.StatusCreated, &workspaceResponse) {
+			return
+		}
+		workspaceID := strconv.FormatUint(workspaceResponse.Data.ID, 10)
+		models.GroupRoles, _ = models.ParseGroupRoles("engineering=" + workspaceID + ":editor")
+
+		start := performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())
+		if !assert.Equal(t, http.StatusFound, start.Code) {
+			return
+		}
+		assert.True(t, strings.HasPrefix(start.Header().Get("Location"), mock.server.URL+"/authorize?"))
+
+		// The first sign-on creates the user with the group's role
+		var loginResponse struct {
+			Data   models.LoginResponseData `json:"data"`
+			Result string                   `json:"result"`
+		}
+		if !testDataResponse(t, signIn(start), http.StatusCreated, &loginResponse) {
+			return
+		}
+		ssoCredentials := map[string]string{"Authorization": "Bearer " + loginResponse.Data.Token}
+		assert.NotNil(t, loginResponse.Data.ExpiresAt)
+
+		var userResponse struct {
+			Data   models.UserResponseData `json:"data"`
+			Result string                  `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", ssoCredentials), http.StatusOK, &userResponse) {
+			assert.Equal(t, "Test OIDC", userResponse.Data.Name)
+		}
+		assert.Equal(t, http.StatusOK, performRequest(r, "GET", "/v1/workspaces/"+workspaceID+"/members", "", ssoCredentials).Code)
+
+		// State is single-use
+		mock.claims["groups"] = []string{}
+		start = performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())
+		callback, _ := mock.authorize(start.Header().Get("Location"))
+		testSuccessfulResponse(t, performRequest(r, "GET", callback.RequestURI(), "", stateCookie(start)), http.StatusCreated)
+		testFailedResponse(t, performRequest(r, "GET", callback.RequestURI(), "", stateCookie(start)), http.StatusBadRequest)
+
+		// Callback is accepted only in the browser that started the sign-on
+		start = performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())
+		callback, _ = mock.authorize(start.Header().Get("Location"))
+		another := performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())
+		testFailedResponse(t, performRequest(r, "GET", callback.RequestURI(), "", getEmptyStringMap()), http.StatusBadRequest)
+		testFailedResponse(t, performRequest(r, "GET", callback.RequestURI(), "", stateCookie(another)), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "GET", callback.RequestURI(), "", stateCookie(start)), http.StatusCreated)
+
+		// Leaving the group removes the membership, the user is the same
+		testFailedResponse(t, performRequest(r, "GET", "/v1/workspaces/"+workspaceID+"/members", "", ssoCredentials), http.StatusNotFound)
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me", "", ssoCredentials), http.StatusOK, &userResponse) {
+			assert.Equal(t, "Test OIDC", userResponse.Data.Name)
+		}
+
+		// Existing users link their accounts and sign in with them afterwards
+		mock.claims = map[string]interface{}{"sub": "bob", "groups": []string{"engineering"}}
+		testSuccessfulResponse(t, signIn(performRequest(r, "POST", "/v1/me/oidc", "", credentials)), http.StatusOK)
+		if testDataResponse(t, signIn(performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())), http.StatusCreated, &loginResponse) &&
+			testDataResponse(t, performRequest(r, "GET", "/v1/me", "", map[string]string{"Authorization": "Bearer " + loginResponse.Data.Token}), http.StatusOK, &userResponse) {
+			assert.Equal(t, USER_NAME, userResponse.Data.Name)
+		}
+
+		// Owner's role is not lowered by the mapping
+		var membersResponse struct {
+			Data   []models.WorkspaceMemberResponseData `json:"data"`
+			Result string                               `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/workspaces/"+workspaceID+"/members", "", credentials), http.StatusOK, &membersResponse) &&
+			assert.Len(t, membersResponse.Data, 1) {
+			assert.Equal(t, models.RoleOwner, membersResponse.Data[0].Role)
+		}
+
+		// Users with two-factor authentication are not linked by the verified email, they link identities themselves
+		email := "oidc-2fa@example.com"
+		passwordHash, _ := models.HashPassword(USER_PASSWORD)
+		victim := models.User{Name: "Test OIDC 2FA", Password: passwordHash, Email: &email, EmailVerified: true, TOTPEnabled: true}
+		if assert.Nil(t, database.DB.Create(&victim).Error) {
+			mock.claims = map[string]interface{}{"sub": "mallory", "email": email, "email_verified": true}
+			if w := signIn(performRequest(r, "GET", "/v1/oidc/login", "", getEmptyStringMap())); testFailedResponse(t, w, http.StatusConflict) {
+				assert.Contains(t, w.Body.String(), "identity_link_required")
+			}
+			var count int
+			database.DB.Model(&models.UserIdentity{}).Where("user_id = ?", victim.ID).Count(&count)
+			assert.Equal(t, 0, count)
+		}
+
+		// Identity can not be linked to two users
+		mock.claims = map[string]interface{}{"sub": "alice"}
+		testFailedResponse(t, signIn(performRequest(r, "POST", "/v1/me/oidc", "", credentials)), http.StatusConflict)
+
+		var identitiesResponse struct {
+			Data   []models.UserIdentity `json:"data"`
+			Result string                `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me/oidc", "", credentials), http.StatusOK, &identitiesResponse) && assert.Len(t, identitiesResponse.Data, 1) {
+			assert.Equal(t, "bob", identitiesResponse.Data[0].Subject)
+			testSuccessfulResponse(t, performRequest(r, "DELETE", "/v1/me/oidc/"+strconv.FormatUint(identitiesResponse.Data[0].ID, 10), "", credentials), http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	if policy, err := ratelimit.ParsePolicy("create", "60/1m"); assert.Nil(t, err) {
+		_ = assert.Equal(t, 60, policy.Limit) && assert.Equal(t, time.Minute, policy.Period)
+	}
+	if policy, err := ratelimit.ParsePolicy("create", "off"); assert.Nil(t, err) {
+		assert.True(t, policy.Disabled())
+	}
+	for _, invalid := range []string{"60", "60/minute", "-1/1m", "60/0s"} {
+		_, err := ratelimit.ParsePolicy("create", invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	// Bucket of 2 tokens gets a new token every 30 seconds
+	now := time.Now()
+	store := ratelimit.NewMemoryStore()
+	store.Clock = func() time.Time { return now }
+	policy := ratelimit.Policy{Name: "test", Limit: 2, Period: time.Minute}
+
+	assert.Equal(t, ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, Reset: 30 * time.Second}, store.Take("client", policy, 1))
+	assert.Equal(t, ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Minute}, store.Take("client", policy, 1))
+	assert.Equal(t, ratelimit.Result{Allowed: false, Limit: 2, Remaining: 0, Reset: time.Minute, RetryAfter: 30 * time.Second}, store.Take("client", policy, 1))
+	assert.True(t, store.Take("another client", policy, 1).Allowed)
+
+	now = now.Add(30 * time.Second)
+	assert.True(t, store.Take("client", policy, 0).Allowed)
+	assert.True(t, store.Take("client", policy, 1).Allowed)
+	assert.False(t, store.Take("client", policy, 0).Allowed)
+
+	// The least recently used bucket is evicted when the store is full
+	store = ratelimit.NewMemoryStore()
+	store.Clock = func() time.Time { return now }
+	store.MaxKeys = 2
+	store.Take("first", policy, 2)
+	now = now.Add(time.Second)
+	store.Take("second", policy, 2)
+	now = now.Add(time.Second)
+	store.Take("third", policy, 2)
+	assert.True(t, store.Take("first", policy, 1).Allowed)
+	assert.False(t, store.Take("third", policy, 1).Allowed)
+	// Using a bucket makes it the most recently used one
+	store.Take("third", policy, 0)
+	store.Take("fourth", policy, 2)
+	assert.False(t, store.Take("third", policy, 1).Allowed)
+	assert.True(t, store.Take("first", policy, 1).Allowed)
+
+	// IPv6 clients are limited per /64 network
+	assert.Equal(t, "198.51.100.1", h.ClientNetwork("198.51.100.1"))
+	assert.Equal(t, "2001:db8:0:1::/64", h.ClientNetwork("2001:db8:0:1:aaaa::1"))
+	assert.Equal(t, "invalid", h.ClientNetwork("invalid"))
+
+	// Forwarding headers are used only for requests of trusted proxies
+	defer func(previous ratelimit.Store) { ratelimit.Default = previous }(ratelimit.Default)
+	os.Setenv("RATE_LIMIT_AUTH", "1/1m")
+	for _, test := range []struct {
+		trustedProxies string
+		forwardedFor   []string
+		status         []int
+	}{
+		{"", []string{"198.51.100.1", "198.51.100.2"}, []int{http.StatusBadRequest, http.StatusTooManyRequests}},
+		{"127.0.0.1", []string{"198.51.100.1", "198.51.100.2", "203.0.113.9, 198.51.100.2"}, []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests}},
+		{"127.0.0.0/8,10.0.0.1", []string{"198.51.100.1, 10.0.0.1", "198.51.100.1"}, []int{http.StatusBadRequest, http.StatusTooManyRequests}},
+		{"127.0.0.1", []string{"2001:db8::1", "2001:db8::ffff:2", "2001:db8:0:1::1"}, []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusBadRequest}},
+	} {
+		ratelimit.Default = ratelimit.NewMemoryStore()
+		os.Setenv("TRUSTED_PROXIES", test.trustedProxies)
+		limited := router.SetupRouter()
+		for i, forwardedFor := range test.forwardedFor {
+			w := performRequest(limited, "POST", "/v1/login", `{}`, map[string]string{"X-Forwarded-For": forwardedFor})
+			assert.Equal(t, test.status[i], w.Code, test.trustedProxies+" "+forwardedFor)
+		}
+	}
+	os.Unsetenv("RATE_LIMIT_AUTH")
+	os.Unsetenv("TRUSTED_PROXIES")
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	limits := map[string]string{"RATE_LIMIT_CREATE": "2/1m", "RATE_LIMIT_AUTH": "2/1m", "RATE_LIMIT_REDIRECT": "3/1m"}
+	for key, value := range limits {
+		previous := os.Getenv(key)
+		os.Setenv(key, value)
+		defer os.Setenv(key, previous)
+	}
+	ratelimit.Default = ratelimit.NewMemoryStore()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		_ = assert.Equal(t, "2", reg.Header().Get("RateLimit-Limit")) && assert.Equal(t, "1", reg.Header().Get("RateLimit-Remaining")) &&
+			assert.Equal(t, "2;w=60", reg.Header().Get("RateLimit-Policy"))
+
+		credentials := map[string]string{
+			"Authorization":   "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+			"X-Forwarded-For": "198.51.100.1",
+		}
+
+		// Failed attempts stop the client, even with the right password afterwards
+		guess := map[string]string{"Authorization": "Basic " + encodeCredentials(USER_NAME, "guess"), "X-Forwarded-For": "198.51.100.2"}
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", guess), http.StatusUnauthorized)
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", guess), http.StatusUnauthorized)
+		limited := performRequest(r, "GET", "/v1/me", "", guess)
+		if testFailedResponse(t, limited, http.StatusTooManyRequests) {
+			assert.Equal(t, "30", limited.Header().Get("Retry-After"))
+		}
+		guess["Authorization"] = credentials["Authorization"]
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", guess), http.StatusTooManyRequests)
+
+		// Successful requests do not count as failed attempts
+		for i := 0; i < 3; i++ {
+			testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials), http.StatusOK)
+		}
+
+		// Creation is limited per user, regardless of the IP
+		var shortlinkResponse struct {
+			Data   models.ShortlinkResponseData `json:"data"`
+			Result string                       `json:"result"`
+		}
+		testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/rate"}`, credentials), http.StatusCreated, &shortlinkResponse)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/rate"}`, credentials), http.StatusCreated)
+		credentials["X-Forwarded-For"] = "198.51.100.3"
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/rate"}`, credentials), http.StatusTooManyRequests)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/shorts", "", credentials), http.StatusOK)
+
+		// Redirects are limited per IP
+		visitor := map[string]string{"X-Forwarded-For": "198.51.100.4"}
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusMovedPermanently, performRequest(r, "GET", "/v1/s/"+shortlinkResponse.Data.Short, "", visitor).Code)
+		}
+		testFailedResponse(t, performRequest(r, "GET", "/v1/s/"+shortlinkResponse.Data.Short, "", visitor), http.StatusTooManyRequests)
+		visitor["X-Forwarded-For"] = "198.51.100.5"
+		assert.Equal(t, http.StatusMovedPermanently, performRequest(r, "GET", "/v1/s/"+shortlinkResponse.Data.Short, "", visitor).Code)
+	}
+}
+
+func TestPlans(t *testing.T) {
+	const ADMIN_NAME = "Test Admin"
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	adminReg := performRequest(r, "POST", "/v1/users", `{"name": "`+ADMIN_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	userReg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, adminReg) && testRegistrationResponse(t, userReg) {
+		adminCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(ADMIN_NAME, USER_PASSWORD),
+		}
+		userCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		models.PromoteAdmins(database.DB, ADMIN_NAME)
+
+		var planResponse struct {
+			Data   models.Plan `json:"data"`
+			Result string      `json:"result"`
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/admin/plans", `{"name":"Test Free"}`, userCredentials), http.StatusForbidden)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/admin/plans", `{"name":"Test Free","maxLinks":-1}`, adminCredentials), http.StatusBadRequest)
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/admin/plans", `{"name":"Test Free","maxLinks":2,"maxCustomAliases":1,"maxDomains":1}`, adminCredentials), http.StatusCreated, &planResponse) {
+			return
+		}
+		freePlan := planResponse.Data
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/admin/plans", `{"name":"Test Team","maxLinks":1}`, adminCredentials), http.StatusCreated, &planResponse) {
+			return
+		}
+		teamPlan := planResponse.Data
+
+		var usersResponse struct {
+			Data   []models.AdminUserResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "GET", "/v1/admin/users?q="+url.QueryEscape(USER_NAME), "", adminCredentials), http.StatusOK, &usersResponse) ||
+			!assert.Len(t, usersResponse.Data, 1) {
+			return
+		}
+		userPath := "/v1/admin/users/" + strconv.FormatUint(usersResponse.Data[0].ID, 10)
+		testFailedResponse(t, performRequest(r, "PATCH", userPath, `{"planId":999999999}`, adminCredentials), http.StatusBadRequest)
+		testSuccessfulResponse(t, performRequest(r, "PATCH", userPath, `{"planId":`+strconv.FormatUint(freePlan.ID, 10)+`}`, adminCredentials), http.StatusOK)
+
+		// Limits of links, custom aliases and domains
+		var shortlinkResponse models.ShortlinkResponse
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/plan","short":"test-plan-alias"}`, userCredentials), http.StatusCreated, &shortlinkResponse) {
+			return
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/plan","short":"test-plan-alias2"}`, userCredentials), http.StatusPaymentRequired)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/plan"}`, userCredentials), http.StatusCreated)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/plan"}`, userCredentials), http.StatusPaymentRequired)
+		// Reusing existing link does not create anything
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/plan","reuseExisting":true}`, userCredentials), http.StatusOK)
+
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/domains", `{"host":"plan.example.com"}`, userCredentials), http.StatusCreated)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/domains", `{"host":"plan2.example.com"}`, userCredentials), http.StatusPaymentRequired)
+
+		// Links of workspaces with their own plan count against it
+		var workspaceResponse struct {
+			Data   models.WorkspaceResponseData `json:"data"`
+			Result string                       `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "POST", "/v1/workspaces", `{"name":"Team"}`, userCredentials), http.StatusCreated, &workspaceResponse) {
+			return
+		}
+		workspaceID := strconv.FormatUint(workspaceResponse.Data.ID, 10)
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/admin/workspaces/"+workspaceID+"/plan", `{"planId":`+strconv.FormatUint(teamPlan.ID, 10)+`}`, adminCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/team","workspaceId":`+workspaceID+`}`, userCredentials), http.StatusCreated)
+		testFailedResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":"https://example.com/team2","workspaceId":`+workspaceID+`}`, userCredentials), http.StatusPaymentRequired)
+
+		var usageResponse struct {
+			Data   models.UsageResponseData `json:"data"`
+			Result string                   `json:"result"`
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/me/usage", "", userCredentials), http.StatusOK, &usageResponse) {
+			usage := usageResponse.Data
+			assert.Equal(t, "Test Free", usage.Plan.Name)
+			assert.Equal(t, models.QuotaUsage{Used: 2, Limit: 2}, usage.Links)
+			assert.Equal(t, models.QuotaUsage{Used: 1, Limit: 1}, usage.CustomAliases)
+			assert.Equal(t, models.QuotaUsage{Used: 1, Limit: 1}, usage.Domains)
+			assert.True(t, usage.APICalls.Used > 0)
+			if assert.Len(t, usage.Workspaces, 1) {
+				_ = assert.Equal(t, "Test Team", usage.Workspaces[0].Plan.Name) && assert.Equal(t, models.QuotaUsage{Used: 1, Limit: 1}, usage.Workspaces[0].Links)
+			}
+		}
+
+		// Uses older than retention are deleted
+		oldUse := models.ShortlinkUse{LinkID: shortlinkResponse.Data.ID, UseTime: time.Now().AddDate(0, 0, -3)}
+		newUse := models.ShortlinkUse{LinkID: shortlinkResponse.Data.ID, UseTime: time.Now()}
+		_ = assert.Nil(t, database.DB.Create(&oldUse).Error) && assert.Nil(t, database.DB.Create(&newUse).Error)
+		testSuccessfulResponse(t, performRequest(r, "PUT", "/v1/admin/plans/"+strconv.FormatUint(freePlan.ID, 10),
+			`{"name":"Test Free","maxLinks":2,"maxCustomAliases":1,"maxDomains":1,"retentionDays":1,"maxApiCalls":1}`, adminCredentials), http.StatusOK)
+		if deleted, err := models.PruneUses(database.DB); assert.Nil(t, err) {
+			assert.True(t, deleted >= 1)
+		}
+		var usesCount int
+		database.DB.Model(&models.ShortlinkUse{}).Where("link_id = ?", shortlinkResponse.Data.ID).Count(&usesCount)
+		assert.Equal(t, 1, usesCount)
+
+		// Monthly API calls are used up, usage is still shown
+		limited := performRequest(r, "GET", "/v1/shorts", "", userCredentials)
+		if testFailedResponse(t, limited, http.StatusTooManyRequests) {
+			assert.NotEmpty(t, limited.Header().Get("Retry-After"))
+		}
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me/usage", "", userCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me/export", "", userCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me/tokens", "", userCredentials), http.StatusOK)
+
+		// Calls are counted in memory until they are flushed
+		assert.Nil(t, models.FlushAPICalls(database.DB))
+		var apiUsage models.APIUsage
+		if assert.Nil(t, database.DB.Where("user_id = ?", usersResponse.Data[0].ID).First(&apiUsage).Error) {
+			assert.True(t, apiUsage.Calls > 1)
+		}
+		database.DB.Where("user_id = ?", usersResponse.Data[0].ID).Delete(&models.APIUsage{})
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/shorts", "", userCredentials), http.StatusOK)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	const ADMIN_NAME = "Test Admin"
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+	const USER_EMAIL = "lockout@example.com"
+
+	sent := &recordingMailer{}
+	mailer.Default = sent
+	defer func() { mailer.Default = mailer.FileMailer{Dir: mailer.DefaultOutboxDir} }()
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	settings := map[string]string{"LOGIN_MAX_FAILURES": "3", "LOGIN_MAX_IP_FAILURES": "1000", "LOGIN_DELAY_SECONDS": "0"}
+	for key, value := range settings {
+		previous := os.Getenv(key)
+		os.Setenv(key, value)
+		defer os.Setenv(key, previous)
+	}
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	adminReg := performRequest(r, "POST", "/v1/users", `{"name": "`+ADMIN_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	userReg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`", "email": "`+USER_EMAIL+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, adminReg) && testRegistrationResponse(t, userReg) {
+		database.DB.Model(&models.User{}).Where("name = ?", USER_NAME).Updates(map[string]interface{}{"email": USER_EMAIL, "email_verified": true, "pending_email": nil})
+		models.PromoteAdmins(database.DB, ADMIN_NAME)
+		sent.messages = nil
+
+		adminCredentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(ADMIN_NAME, USER_PASSWORD),
+		}
+		credentials := func(password, ip string) map[string]string {
+			return map[string]string{"Authorization": "Basic " + encodeCredentials(USER_NAME, password), "X-Forwarded-For": ip}
+		}
+		login := `{"name":"` + USER_NAME + `","password":"` + USER_PASSWORD + `"}`
+
+		// Failures of the name from any IP lock it out, the right password is refused too
+		for i := 1; i <= 3; i++ {
+			testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials("guess", "198.51.100."+strconv.Itoa(i))), http.StatusUnauthorized)
+		}
+		locked := performRequest(r, "GET", "/v1/me", "", credentials(USER_PASSWORD, "198.51.100.10"))
+		if testFailedResponse(t, locked, http.StatusTooManyRequests) {
+			retryAfter, _ := strconv.Atoi(locked.Header().Get("Retry-After"))
+			assert.True(t, retryAfter > 0 && retryAfter <= 15*60)
+		}
+		testFailedResponse(t, performRequest(r, "POST", "/v1/login", login, map[string]string{"X-Forwarded-For": "198.51.100.10"}), http.StatusTooManyRequests)
+		// The name is matched regardless of case
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", map[string]string{"Authorization": "Basic " + encodeCredentials(strings.ToUpper(USER_NAME), "guess")}), http.StatusTooManyRequests)
+
+		if assert.Len(t, sent.messages, 1) {
+			_ = assert.Equal(t, USER_EMAIL, sent.messages[0].To) && assert.Contains(t, sent.messages[0].Body, "198.51.100.3")
+		}
+
+		var usersResponse struct {
+			Data   []models.AdminUserResponseData `json:"data"`
+			Result string                         `json:"result"`
+		}
+		if !testDataResponse(t, performRequest(r, "GET", "/v1/admin/users?q="+url.QueryEscape(USER_NAME), "", adminCredentials), http.StatusOK, &usersResponse) ||
+			!assert.Len(t, usersResponse.Data, 1) {
+			return
+		}
+		_ = assert.Equal(t, 3, usersResponse.Data[0].FailedLogins) && assert.NotNil(t, usersResponse.Data[0].LockedUntil)
+
+		unlockPath := "/v1/admin/users/" + strconv.FormatUint(usersResponse.Data[0].ID, 10) + "/lock"
+		testFailedResponse(t, performRequest(r, "DELETE", unlockPath, "", credentials(USER_PASSWORD, "198.51.100.10")), http.StatusTooManyRequests)
+		testSuccessfulResponse(t, performRequest(r, "DELETE", unlockPath, "", adminCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials(USER_PASSWORD, "198.51.100.10")), http.StatusOK)
+
+		// Every failure delays the next attempt, signing in forgets failures of the name
+		os.Setenv("LOGIN_DELAY_SECONDS", "60")
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials("guess", "198.51.100.11")), http.StatusUnauthorized)
+		delayed := performRequest(r, "GET", "/v1/me", "", credentials(USER_PASSWORD, "198.51.100.12"))
+		if testFailedResponse(t, delayed, http.StatusTooManyRequests) {
+			retryAfter, _ := strconv.Atoi(delayed.Header().Get("Retry-After"))
+			assert.True(t, retryAfter > 0 && retryAfter <= 60)
+		}
+		testSuccessfulResponse(t, performRequest(r, "DELETE", unlockPath, "", adminCredentials), http.StatusOK)
+		testSuccessfulResponse(t, performRequest(r, "POST", "/v1/login", login, map[string]string{"X-Forwarded-For": "198.51.100.12"}), http.StatusCreated)
+		os.Setenv("LOGIN_DELAY_SECONDS", "0")
+
+		if testDataResponse(t, performRequest(r, "GET", "/v1/admin/users?q="+url.QueryEscape(USER_NAME), "", adminCredentials), http.StatusOK, &usersResponse) &&
+			assert.Len(t, usersResponse.Data, 1) {
+			_ = assert.Equal(t, 0, usersResponse.Data[0].FailedLogins) && assert.Nil(t, usersResponse.Data[0].LockedUntil)
+		}
+
+		// Empty name matches no user, even with a password of an existing one, and is not throttled under a shared key
+		for i := 0; i < 4; i++ {
+			empty := map[string]string{"Authorization": "Basic " + encodeCredentials("", USER_PASSWORD), "X-Forwarded-For": "198.51.100.15"}
+			testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", empty), http.StatusUnauthorized)
+		}
+		var emptyThrottles int
+		database.DB.Model(&models.LoginThrottle{}).Where("throttle_key = ?", "name:").Count(&emptyThrottles)
+		assert.Equal(t, 0, emptyThrottles)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", adminCredentials), http.StatusOK)
+
+		// Guessing different names from one IP locks the IP out
+		os.Setenv("LOGIN_MAX_IP_FAILURES", "2")
+		for _, name := range []string{"Nobody One", "Nobody Two"} {
+			guess := map[string]string{"Authorization": "Basic " + encodeCredentials(name, "guess"), "X-Forwarded-For": "198.51.100.20"}
+			testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", guess), http.StatusUnauthorized)
+		}
+		testFailedResponse(t, performRequest(r, "GET", "/v1/me", "", credentials(USER_PASSWORD, "198.51.100.20")), http.StatusTooManyRequests)
+		testSuccessfulResponse(t, performRequest(r, "GET", "/v1/me", "", credentials(USER_PASSWORD, "198.51.100.21")), http.StatusOK)
+		database.DB.Where("throttle_key IN (?)", []string{"ip:198.51.100.20", "name:nobody one", "name:nobody two"}).Delete(&models.LoginThrottle{})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const USER_NAME = "Test Test"
+	const USER_PASSWORD = "testPassword123"
+
+	// Database errors are replaced, their texts are not exposed
+	for _, test := range []struct {
+		err           error
+		handlerStatus int
+		status        int
+		code          string
+	}{
+		{&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint \"users_name_key\""}, http.StatusBadRequest, http.StatusConflict, "already_exists"},
+		{gorm.Errors{&pq.Error{Code: "23503"}}, http.StatusBadRequest, http.StatusConflict, "conflict"},
+		{&pq.Error{Code: "22P02"}, http.StatusNotFound, http.StatusBadRequest, "invalid_value"},
+		{&pq.Error{Code: "42P01", Message: "relation \"users\" does not exist"}, http.StatusBadRequest, http.StatusInternalServerError, "internal_error"},
+		{gorm.ErrRecordNotFound, http.StatusBadRequest, http.StatusNotFound, "not_found"},
+		{h.NewShortTakenError(), http.StatusBadRequest, http.StatusConflict, "short_taken"},
+		{h.NewLinkExpiredError(), http.StatusGone, http.StatusGone, "link_expired"},
+		{strconv.ErrSyntax, http.StatusBadRequest, http.StatusBadRequest, "bad_request"},
+	} {
+		appErr, status := h.TranslateError(database.TranslateError(test.err), test.handlerStatus)
+		_ = assert.Equal(t, test.status, status, test.code) && assert.Equal(t, test.code, appErr.Code)
+		assert.NotContains(t, appErr.Message, "users")
+	}
+	assert.True(t, h.ProblemDetailsWanted("application/json;q=0.9, application/problem+json"))
+	assert.False(t, h.ProblemDetailsWanted("application/json"))
+
+	// Init local env
+	err := godotenv.Load(".env.test")
+	if err != nil {
+		log.Fatal("Error loading .env.test file")
+	}
+
+	db, err := InitDatabase()
+	if !assert.Nil(t, err) {
+		fmt.Println("Cannot connect to the database:" + err.Error())
+		return
+	}
+	defer db.Close()
+
+	cleaner := DeleteCreatedEntities(database.DB)
+	defer cleaner()
+
+	// Initialize WebServer
+	r := router.SetupRouter()
+
+	var errorResponse h.ResponseError
+	if testDataResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`"}`, getEmptyStringMap()), http.StatusBadRequest, &errorResponse) {
+		_ = assert.Equal(t, "validation_failed", errorResponse.Code) && assert.Equal(t, []map[string]string{{"password": "required"}}, errorResponse.Fields)
+	}
+	if testDataResponse(t, performRequest(r, "GET", "/v1/unknown", "", getEmptyStringMap()), http.StatusNotFound, &errorResponse) {
+		assert.Equal(t, "page_not_found", errorResponse.Code)
+	}
+
+	reg := performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap())
+	if testRegistrationResponse(t, reg) {
+		credentials := map[string]string{
+			"Authorization": "Basic " + encodeCredentials(USER_NAME, USER_PASSWORD),
+		}
+
+		// Unique violation of the database is a conflict
+		if testDataResponse(t, performRequest(r, "POST", "/v1/users", `{"name": "`+USER_NAME+`", "password": "`+USER_PASSWORD+`"}`, getEmptyStringMap()), http.StatusConflict, &errorResponse) {
+			_ = assert.Equal(t, "already_exists", errorResponse.Code) && assert.NotContains(t, errorResponse.Error, "users_name_key")
+		}
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{"full":}`, credentials), http.StatusBadRequest, &errorResponse) {
+			assert.Equal(t, "invalid_body", errorResponse.Code)
+		}
+		if testDataResponse(t, performRequest(r, "GET", "/v1/shorts/999999999", "", credentials), http.StatusNotFound, &errorResponse) {
+			assert.Equal(t, "not_found", errorResponse.Code)
+		}
+
+		// Problem details are returned to clients accepting them
+		credentials["Accept"] = h.ProblemContentType
+		w := performRequest(r, "POST", "/v1/shorts", `{"full":"ftp://example.com/"}`, credentials)
+		var problem h.ProblemDetails
+		if testDataResponse(t, w, http.StatusBadRequest, &problem) {
+			assert.Equal(t, h.ProblemContentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, h.ProblemDetails{
+				Type:     "urn:shorts:error:scheme_not_allowed",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   h.NewSchemeNotAllowedError(urlcheck.AllowedSchemes()).Error(),
+				Instance: "/v1/shorts",
+				Code:     "scheme_not_allowed",
+			}, problem)
+		}
+		delete(credentials, "Accept")
+
+		os.Setenv("ERROR_FORMAT", "problem")
+		defer os.Setenv("ERROR_FORMAT", "")
+		if testDataResponse(t, performRequest(r, "POST", "/v1/shorts", `{}`, credentials), http.StatusBadRequest, &problem) {
+			_ = assert.Equal(t, "validation_failed", problem.Code) && assert.Equal(t, []map[string]string{{"full": "required"}}, problem.InvalidParams)
+		}
+	}
+}
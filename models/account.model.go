@@ -0,0 +1,134 @@
+package models
+
+import (
+	"time"
+
+	"shorts/database"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Ways to handle links of deleted account
+const (
+	AccountLinksDelete   = "delete"
+	AccountLinksTransfer = "transfer"
+)
+
+// ChangePasswordData structure
+// swagger:parameters changePassword
+type ChangePasswordData struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=5,max=72"`
+}
+
+// ChangeNameData structure
+// swagger:parameters changeName
+type ChangeNameData struct {
+	Name string `json:"name" binding:"required,min=5"`
+}
+
+// SetEmailData structure
+// swagger:parameters setEmail
+type SetEmailData struct {
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyEmailData : Token from the verification link, posted as JSON or by the form of the confirmation page
+// swagger:parameters verifyEmail
+type VerifyEmailData struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// EmailData : Request of email with verification link or password reset token
+// swagger:parameters resendVerification requestPasswordReset
+type EmailData struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+// ResetPasswordData structure
+// swagger:parameters resetPassword
+type ResetPasswordData struct {
+	// Token from the password reset email
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required,min=5,max=72"`
+}
+
+// DeleteAccountData structure
+// swagger:parameters deleteAccount
+type DeleteAccountData struct {
+	Password string `json:"password" binding:"required"`
+	// Delete links with the account or transfer them (with custom domains) to another user
+	Links string `json:"links" binding:"required,oneof=delete transfer"`
+	// Name of the user receiving links in workspaces the user owns, current user must be a member of one of them.
+	// Other links are deleted
+	TransferTo string `json:"transferTo"`
+}
+
+// AccountExport : All data of a user
+type AccountExport struct {
+	User       UserResponseData        `json:"user"`
+	CreatedAt  time.Time               `json:"createdAt"`
+	Workspaces []WorkspaceResponseData `json:"workspaces"`
+	Links      []Shortlink             `json:"links"`
+	Domains    []Domain                `json:"domains"`
+	Webhooks   []Webhook               `json:"webhooks"`
+	ExportedAt time.Time               `json:"exportedAt"`
+}
+
+// ExportAccount : Collects user with the user's workspaces, links (with uses, rules and destinations), domains and webhooks
+func ExportAccount(user User) (*AccountExport, error) {
+	export := AccountExport{
+		User:       UserResponseData{ID: user.ID, Name: user.Name},
+		CreatedAt:  user.CreatedAt,
+		Links:      make([]Shortlink, 0),
+		Domains:    make([]Domain, 0),
+		Webhooks:   make([]Webhook, 0),
+		ExportedAt: time.Now(),
+	}
+
+	var err error
+	if export.Workspaces, err = UserWorkspaces(user.ID); err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.Preload("Uses").Preload("Rules").Preload("Destinations").Where(&Shortlink{OwnerID: user.ID}).Order("id").Find(&export.Links).Error; err != nil {
+		return nil, err
+	}
+	for i := range export.Links {
+		export.Links[i].URL = export.Links[i].PublicURL()
+	}
+
+	if err := database.DB.Where(&Domain{OwnerID: user.ID}).Order("id").Find(&export.Domains).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where(&Webhook{OwnerID: user.ID}).Order("id").Find(&export.Webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// OwnsSharedWorkspace : Checks if the owner is an owner of a workspace the user is a member of
+func OwnsSharedWorkspace(userID, ownerID uint64) (bool, error) {
+	var count int
+	if err := database.DB.Table("workspace_members").Where("user_id = ? AND role = ? AND workspace_id IN (?)", ownerID, RoleOwner,
+		database.DB.Table("workspace_members").Select("workspace_id").Where("user_id = ?", userID).SubQuery()).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// TransferUserLinks : Makes another user owner of the user's links in workspaces the new owner owns, so nothing is pushed
+// on users who do not control it. Custom domains of the transferred links are transferred with them, other links are left for deletion
+func TransferUserLinks(db *gorm.DB, fromID, toID uint64) error {
+	ownedWorkspaces := db.Table("workspace_members").Select("workspace_id").Where("user_id = ? AND role = ?", toID, RoleOwner).SubQuery()
+	transferredDomains := db.Table("shortlinks").Select("domain_id").Where("owner_id = ? AND workspace_id IN (?)", fromID, ownedWorkspaces).SubQuery()
+
+	if err := db.Model(&Domain{}).Where("owner_id = ? AND id IN (?)", fromID, transferredDomains).Update("owner_id", toID).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&Shortlink{}).Where("owner_id = ? AND workspace_id IN (?)", fromID, ownedWorkspaces).Update("owner_id", toID).Error
+}
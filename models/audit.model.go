@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Actions of administrators written to the audit log
+const (
+	AuditUserUpdated      = "user.updated"
+	AuditUserDeleted      = "user.deleted"
+	AuditUserUnlocked     = "user.unlocked"
+	AuditLinkDisabled     = "link.disabled"
+	AuditLinkEnabled      = "link.enabled"
+	AuditBlocklistAdded   = "blocklist.added"
+	AuditBlocklistDeleted = "blocklist.deleted"
+	AuditPlanCreated      = "plan.created"
+	AuditPlanUpdated      = "plan.updated"
+	AuditWorkspaceUpdated = "workspace.updated"
+)
+
+// Types of audit log targets
+const (
+	AuditTargetUser      = "user"
+	AuditTargetLink      = "link"
+	AuditTargetBlocklist = "blocklist"
+	AuditTargetPlan      = "plan"
+	AuditTargetWorkspace = "workspace"
+)
+
+// AuditEntry : Action of an administrator
+type AuditEntry struct {
+	ID      uint64 `json:"id" gorm:"primary_key"`
+	ActorID uint64 `json:"actorId" gorm:"index;not null"`
+	Action  string `json:"action" gorm:"index;not null"`
+	// Type and ID of the changed entity
+	TargetType string `json:"targetType" gorm:"not null"`
+	TargetID   uint64 `json:"targetId" gorm:"not null"`
+	// JSON encoded request data or removed entity
+	Details   string    `json:"details" gorm:"type:text;not null;default:''"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+// AuditFilter : Query parameters of audit log
+// swagger:parameters getAuditLog
+type AuditFilter struct {
+	// in: query
+	Action string `form:"action" json:"action"`
+	// in: query
+	ActorID uint64 `form:"actor" json:"actor"`
+	// in: query
+	TargetType string `form:"target_type" json:"target_type"`
+	// in: query
+	TargetID uint64 `form:"target_id" json:"target_id"`
+}
+
+// WriteAudit : Writes action of the administrator to the audit log, usually within the transaction making the change
+func WriteAudit(db *gorm.DB, actorID uint64, action, targetType string, targetID uint64, details interface{}) error {
+	entry := AuditEntry{ActorID: actorID, Action: action, TargetType: targetType, TargetID: targetID}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Details = string(encoded)
+	}
+
+	return db.Create(&entry).Error
+}
+
+// AuditLog : Returns latest audit log entries matching the filter
+func AuditLog(db *gorm.DB, filter AuditFilter, limit, offset int) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	if err := db.Where(&AuditEntry{
+		Action:     filter.Action,
+		ActorID:    filter.ActorID,
+		TargetType: filter.TargetType,
+		TargetID:   filter.TargetID,
+	}).Order("id desc").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
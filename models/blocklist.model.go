@@ -0,0 +1,62 @@
+package models
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+
+	"shorts/database"
+	"shorts/urlcheck"
+)
+
+// Kinds of blocklist entries
+const (
+	BlocklistDomain = "domain"
+	BlocklistRegex  = "regex"
+)
+
+// BlocklistEntry structure
+type BlocklistEntry struct {
+	ID        uint64    `json:"id" gorm:"primary_key"`
+	Kind      string    `json:"kind" gorm:"not null"`
+	Pattern   string    `json:"pattern" gorm:"not null"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AddBlocklistEntryData structure
+// swagger:parameters addBlocklistEntry
+type AddBlocklistEntryData struct {
+	Kind    string `json:"kind" binding:"required,oneof=domain regex"`
+	Pattern string `json:"pattern" binding:"required"`
+	Reason  string `json:"reason"`
+}
+
+// Matches : Checks if link is blocked by the entry
+func (e BlocklistEntry) Matches(u *url.URL) bool {
+	switch e.Kind {
+	case BlocklistDomain:
+		return urlcheck.MatchesHost(u.Host, e.Pattern)
+	case BlocklistRegex:
+		pattern, err := regexp.Compile(e.Pattern)
+		return err == nil && pattern.MatchString(u.String())
+	}
+
+	return false
+}
+
+// FindBlocklistEntry : Returns blocklist entry that blocks the link or nil
+func FindBlocklistEntry(u *url.URL) (*BlocklistEntry, error) {
+	var entries []BlocklistEntry
+	if err := database.DB.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Matches(u) {
+			return &entry, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+
+	"github.com/jinzhu/gorm"
+)
+
+// domainVerificationPrefix : Prefix of the host the TXT record with the verification token is looked up at
+const domainVerificationPrefix = "_shorts-verification."
+
+// LookupTXT : Resolves TXT records of domain verification, replaced in tests
+var LookupTXT = net.DefaultResolver.LookupTXT
+
+// Domain structure. Several users may claim the same host, only verified domains serve short links
+// and at most one of them can be verified (see idx_domains_verified_host)
+type Domain struct {
+	ID      uint64 `json:"id" gorm:"primary_key"`
+	Host    string `json:"host" gorm:"unique_index:idx_domains_owner_host;not null"`
+	OwnerID uint64 `json:"ownerId" gorm:"unique_index:idx_domains_owner_host;not null"`
+	// Owner proves control of the host with TXT record containing the token at VerificationName
+	VerificationToken string `json:"verificationToken" gorm:"not null;default:''"`
+	Verified          bool   `json:"verified" gorm:"not null;default:false"`
+
+	DomainAppData
+}
+
+// DomainAppData : Native apps that handle short links of the domain (served in apple-app-site-association and assetlinks.json)
+type DomainAppData struct {
+	// Comma separated iOS app IDs ("TEAMID.com.example.app")
+	AppleAppIDs string `json:"appleAppIds"`
+	// Android application package name
+	AndroidPackage string `json:"androidPackage"`
+	// Comma separated SHA-256 fingerprints of the Android app signing certificates
+	AndroidCertFingerprints string `json:"androidCertFingerprints"`
+}
+
+// AppleAppSiteAssociation : Returns content of apple-app-site-association file
+func (d DomainAppData) AppleAppSiteAssociation() map[string]interface{} {
+	details := make([]map[string]interface{}, 0)
+	for _, appID := range splitList(d.AppleAppIDs) {
+		details = append(details, map[string]interface{}{"appID": appID, "paths": []string{"*"}})
+	}
+
+	return map[string]interface{}{
+		"applinks": map[string]interface{}{
+			"apps":    []string{},
+			"details": details,
+		},
+	}
+}
+
+// AssetLinks : Returns content of assetlinks.json file
+func (d DomainAppData) AssetLinks() []map[string]interface{} {
+	return []map[string]interface{}{{
+		"relation": []string{"delegate_permission/common.handle_all_urls"},
+		"target": map[string]interface{}{
+			"namespace":                "android_app",
+			"package_name":             d.AndroidPackage,
+			"sha256_cert_fingerprints": splitList(d.AndroidCertFingerprints),
+		},
+	}}
+}
+
+// AddDomainData structure
+// swagger:parameters addDomain
+type AddDomainData struct {
+	Host string `json:"host" binding:"required,max=253"`
+
+	DomainAppData
+}
+
+// UpdateDomainData structure
+// swagger:parameters updateDomain
+type UpdateDomainData struct {
+	DomainAppData
+}
+
+// VerificationName : Returns host the TXT record with the verification token has to be published at
+func (d Domain) VerificationName() string {
+	return domainVerificationPrefix + d.Host
+}
+
+// NewDomain : Returns unverified domain of the user with a new verification token
+func NewDomain(host string, ownerID uint64, appData DomainAppData) (Domain, error) {
+	token, err := randomHex(16)
+	if err != nil {
+		return Domain{}, err
+	}
+
+	return Domain{Host: host, OwnerID: ownerID, VerificationToken: token, DomainAppData: appData}, nil
+}
+
+// VerifyDomain : Marks the domain as verified if its TXT record contains the verification token.
+// Returns NewDomainTakenError if another user verified the host first
+func VerifyDomain(ctx context.Context, db *gorm.DB, domain *Domain) error {
+	if domain.Verified {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	found := false
+	if records, err := LookupTXT(ctx, domain.VerificationName()); err == nil {
+		for _, record := range records {
+			if record == domain.VerificationToken {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return h.NewDomainNotVerifiedError(domain.VerificationName())
+	}
+
+	var count int
+	if err := db.Model(&Domain{}).Where("host = ? AND verified AND id <> ?", domain.Host, domain.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return h.NewDomainTakenError()
+	}
+
+	// The unique index decides if another user verifies the host at the same time
+	if err := db.Model(domain).Update("verified", true).Error; err != nil {
+		return err
+	}
+	domain.Verified = true
+
+	return nil
+}
+
+// IsBaseHost : Checks if host is the default domain of the service (from BASE_URL)
+func IsBaseHost(host string) bool {
+	baseURL, err := url.Parse(h.GetEnv("BASE_URL", ""))
+	return err == nil && baseURL.Host != "" && h.NormalizeHost(baseURL.Host) == h.NormalizeHost(host)
+}
+
+// FindDomainByHost : Returns verified custom domain of the host. The host of the service itself is never a custom domain
+func FindDomainByHost(host string) (Domain, error) {
+	var domain Domain
+	if IsBaseHost(host) {
+		return domain, gorm.ErrRecordNotFound
+	}
+
+	err := database.DB.Where("host = ? AND verified", h.NormalizeHost(host)).First(&domain).Error
+
+	return domain, err
+}
+
+// DefaultDomainID : ID of the domain the service itself is running on
+const DefaultDomainID uint64 = 0
+
+// FindDomainIDByHost : Returns ID of the custom domain registered for the host or DefaultDomainID
+func FindDomainIDByHost(host string) uint64 {
+	domain, err := FindDomainByHost(host)
+	if err != nil {
+		return DefaultDomainID
+	}
+
+	return domain.ID
+}
+
+// IsOwnHost : Checks if host is served by this service (default domain from BASE_URL or one of verified custom domains)
+func IsOwnHost(host string) bool {
+	return IsBaseHost(host) || FindDomainIDByHost(host) != DefaultDomainID
+}
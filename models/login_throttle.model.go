@@ -0,0 +1,200 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	h "shorts/helper"
+	"shorts/mailer"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Defaults of sign-in throttling if LOGIN_* variables are not set
+const (
+	defaultLoginMaxFailures   = 5
+	defaultLoginMaxIPFailures = 20
+	defaultLoginLockout       = 15 * time.Minute
+	defaultLoginDelay         = time.Second
+)
+
+// LoginThrottle : Failed sign-in attempts of a user name ("name:<name>") or an IP ("ip:<ip>", the /64 network for IPv6)
+type LoginThrottle struct {
+	ID            uint64    `gorm:"primary_key"`
+	ThrottleKey   string    `gorm:"unique_index;not null"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+}
+
+// dummyPasswordHash : Hash checked for unknown user names, no password matches it
+var dummyPasswordHash = "$2a$10$" + strings.Repeat("0", 53)
+
+// loginSettings : Returns failures before lockout per name and per IP, lockout duration and delay after the first failure
+func loginSettings() (maxFailures, maxIPFailures int, lockout, delay time.Duration) {
+	maxFailures, maxIPFailures, lockout, delay = defaultLoginMaxFailures, defaultLoginMaxIPFailures, defaultLoginLockout, defaultLoginDelay
+
+	if value, err := strconv.Atoi(h.GetEnv("LOGIN_MAX_FAILURES", "")); err == nil && value > 0 {
+		maxFailures = value
+	}
+	if value, err := strconv.Atoi(h.GetEnv("LOGIN_MAX_IP_FAILURES", "")); err == nil && value > 0 {
+		maxIPFailures = value
+	}
+	if value, err := strconv.Atoi(h.GetEnv("LOGIN_LOCKOUT_MINUTES", "")); err == nil && value > 0 {
+		lockout = time.Duration(value) * time.Minute
+	}
+	if value, err := strconv.Atoi(h.GetEnv("LOGIN_DELAY_SECONDS", "")); err == nil && value >= 0 {
+		delay = time.Duration(value) * time.Second
+	}
+
+	return
+}
+
+// nameThrottleKey : Returns throttle key of the user name, names differing in case share it
+func nameThrottleKey(name string) string {
+	return "name:" + strings.ToLower(name)
+}
+
+// blockedUntil : Returns time the next attempt is allowed at, failures double the delay until the lockout
+func (t *LoginThrottle) blockedUntil(lockout, delay time.Duration) time.Time {
+	if t.LockedUntil != nil {
+		return *t.LockedUntil
+	}
+	if t.Failures == 0 || delay == 0 {
+		return time.Time{}
+	}
+
+	progressive := time.Duration(float64(delay) * math.Pow(2, math.Min(float64(t.Failures-1), 30)))
+	if progressive > lockout {
+		progressive = lockout
+	}
+
+	return t.LastFailureAt.Add(progressive)
+}
+
+// SignIn : Checks user name and password of the client with the IP. Failed attempts of the name and of the IP are delayed progressively
+// and locked out temporarily. Returns the user, nil if credentials are wrong, or time until the next attempt is allowed.
+// Users with two-factor authentication have to be finished with ClearLoginFailures or RecordSecondFactorFailure
+func SignIn(db *gorm.DB, name, password, ip string) (*User, time.Duration, error) {
+	// No user has an empty name, failures of it would share one throttle key of no account
+	if name == "" {
+		return nil, 0, nil
+	}
+
+	// Even the right password is refused, so guessing can not go on during the lockout
+	if retryAfter, err := LoginRetryAfter(db, name, ip); err != nil || retryAfter > 0 {
+		return nil, retryAfter, err
+	}
+
+	var user User
+	if err := db.Where("name = ?", name).First(&user).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, 0, err
+	}
+	// Unknown names are checked against a dummy hash, so the response time does not tell which names exist
+	if user.ID == 0 {
+		user.Password = dummyPasswordHash
+	}
+	if user.CheckPassword(password) && user.ID != 0 {
+		// Failures of users with two-factor authentication are forgotten only after the code is checked too
+		if user.TOTPEnabled {
+			return &user, 0, nil
+		}
+		return &user, 0, ClearLoginFailures(db, name)
+	}
+
+	return nil, 0, recordLoginFailure(db, name, ip, &user)
+}
+
+// LoginRetryAfter : Returns time until the next attempt of the name from the IP is allowed, 0 if it is allowed now
+func LoginRetryAfter(db *gorm.DB, name, ip string) (time.Duration, error) {
+	_, _, lockout, delay := loginSettings()
+	now := time.Now()
+
+	var throttles []LoginThrottle
+	if err := db.Where("throttle_key IN (?)", []string{nameThrottleKey(name), "ip:" + ip}).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var retryAfter time.Duration
+	for _, throttle := range throttles {
+		if until := throttle.blockedUntil(lockout, delay); until.Sub(now) > retryAfter {
+			retryAfter = until.Sub(now)
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// ClearLoginFailures : Forgets failed sign-in attempts of the user name after the user signed in with all factors
+func ClearLoginFailures(db *gorm.DB, name string) error {
+	return db.Where("throttle_key = ?", nameThrottleKey(name)).Delete(&LoginThrottle{}).Error
+}
+
+// RecordSecondFactorFailure : Counts wrong TOTP or recovery code like a wrong password, so codes can not be guessed with a stolen password
+func RecordSecondFactorFailure(db *gorm.DB, user User, ip string) error {
+	return recordLoginFailure(db, user.Name, ip, &user)
+}
+
+// recordLoginFailure : Counts failed attempt of the name and of the IP and locks them out after too many failures.
+// Existing users are notified when their accounts are locked
+func recordLoginFailure(db *gorm.DB, name, ip string, user *User) error {
+	maxFailures, maxIPFailures, lockout, _ := loginSettings()
+	now := time.Now()
+
+	for key, max := range map[string]int{nameThrottleKey(name): maxFailures, "ip:" + ip: maxIPFailures} {
+		// Failures older than the lockout are forgotten
+		var failures int
+		if err := db.Raw(`INSERT INTO login_throttles (throttle_key, failures, last_failure_at) VALUES (?, 1, ?)
+			ON CONFLICT (throttle_key) DO UPDATE SET last_failure_at = EXCLUDED.last_failure_at, locked_until = NULL,
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END
+			RETURNING failures`, key, now, now.Add(-lockout)).Row().Scan(&failures); err != nil {
+			return err
+		}
+
+		if failures < max {
+			continue
+		}
+
+		lockedUntil := now.Add(lockout)
+		if err := db.Model(&LoginThrottle{}).Where("throttle_key = ?", key).Update("locked_until", lockedUntil).Error; err != nil {
+			return err
+		}
+
+		if failures == max && key == nameThrottleKey(name) && user.ID != 0 && user.Email != nil && user.EmailVerified {
+			notifyLockout(*user, ip, failures, lockedUntil)
+		}
+	}
+
+	return nil
+}
+
+// notifyLockout : Tells the user about the lockout, sending failures are only logged
+func notifyLockout(user User, ip string, failures int, lockedUntil time.Time) {
+	message := mailer.Message{
+		To:      *user.Email,
+		Subject: "Sign-in to your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nafter %d failed sign-in attempts, the last one from %s, signing in to your account is locked until %s.\n"+
+			"If it was not you, consider changing your password.\n", user.Name, failures, ip, lockedUntil.UTC().Format(time.RFC1123)),
+	}
+
+	if err := mailer.Send(message); err != nil {
+		fmt.Println("Cannot send email: " + err.Error())
+	}
+}
+
+// UnlockUser : Forgets failed sign-in attempts of the user name
+func UnlockUser(db *gorm.DB, name string) error {
+	return ClearLoginFailures(db, name)
+}
+
+// PruneLoginThrottles : Deletes failures that are forgotten already (older than the lockout and not locked), returns number of deleted rows
+func PruneLoginThrottles(db *gorm.DB) (int64, error) {
+	_, _, lockout, _ := loginSettings()
+	now := time.Now()
+
+	dbc := db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-lockout), now).Delete(&LoginThrottle{})
+	return dbc.RowsAffected, dbc.Error
+}
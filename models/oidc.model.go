@@ -0,0 +1,299 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	h "shorts/helper"
+	"shorts/oidc"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OIDCLogin : Pending single sign-on of a visitor, it is consumed by the provider's callback. Only hash of the state is stored
+type OIDCLogin struct {
+	ID        uint64 `gorm:"primary_key"`
+	StateHash string `gorm:"unique_index;not null"`
+	Nonce     string `gorm:"not null"`
+	// PKCE code verifier, the provider only saw its challenge
+	Verifier string `gorm:"not null"`
+	// Identity is linked to this user instead of signing in
+	LinkUserID uint64    `gorm:"not null;default:0"`
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
+// UserIdentity : Account of identity provider linked to a user
+type UserIdentity struct {
+	ID          uint64     `json:"id" gorm:"primary_key"`
+	UserID      uint64     `json:"-" gorm:"index;not null"`
+	Issuer      string     `json:"issuer" gorm:"unique_index:idx_user_identities_issuer_subject;not null"`
+	Subject     string     `json:"subject" gorm:"unique_index:idx_user_identities_issuer_subject;not null"`
+	Email       string     `json:"email" gorm:"not null;default:''"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// OIDCLinkResponseData : Provider's login page the user has to open to link the account
+type OIDCLinkResponseData struct {
+	URL string `json:"url"`
+}
+
+// GroupRole : Role in a workspace granted to members of identity provider's group
+type GroupRole struct {
+	Group       string
+	WorkspaceID uint64
+	Role        string
+}
+
+// GroupRoles : Mapping of identity provider's groups to workspace roles, applied on every single sign-on
+var GroupRoles []GroupRole
+
+// ParseGroupRoles : Parses comma separated "group=workspaceID:role" list (OIDC_GROUP_ROLES)
+func ParseGroupRoles(value string) ([]GroupRole, error) {
+	var groupRoles []GroupRole
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		separator := strings.LastIndex(item, "=")
+		if separator <= 0 {
+			return nil, errors.New("Invalid group role: " + item)
+		}
+		workspaceRole := strings.SplitN(item[separator+1:], ":", 2)
+		if len(workspaceRole) != 2 {
+			return nil, errors.New("Invalid group role: " + item)
+		}
+
+		workspaceID, err := strconv.ParseUint(strings.TrimSpace(workspaceRole[0]), 10, 64)
+		role := strings.TrimSpace(workspaceRole[1])
+		if err != nil || roleRanks[role] == 0 {
+			return nil, errors.New("Invalid group role: " + item)
+		}
+
+		groupRoles = append(groupRoles, GroupRole{Group: strings.TrimSpace(item[:separator]), WorkspaceID: workspaceID, Role: role})
+	}
+
+	return groupRoles, nil
+}
+
+// CreateOIDCLogin : Stores state of a new single sign-on, it expires after the TTL
+func CreateOIDCLogin(db *gorm.DB, state, nonce, verifier string, linkUserID uint64, ttl time.Duration) error {
+	return db.Create(&OIDCLogin{
+		StateHash:  hashToken(state),
+		Nonce:      nonce,
+		Verifier:   verifier,
+		LinkUserID: linkUserID,
+		ExpiresAt:  time.Now().Add(ttl),
+	}).Error
+}
+
+// ConsumeOIDCLogin : Returns and deletes pending single sign-on with the state, nil if it does not exist or expired
+func ConsumeOIDCLogin(db *gorm.DB, state string) (*OIDCLogin, error) {
+	var login OIDCLogin
+	if err := db.Where("state_hash = ? AND expires_at > ?", hashToken(state), time.Now()).First(&login).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Deleting the row makes the state single-use even for concurrent callbacks
+	dbc := db.Delete(&login)
+	if dbc.Error != nil {
+		return nil, dbc.Error
+	}
+	if dbc.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &login, nil
+}
+
+// FindOrProvisionUser : Returns user of the identity. Unknown identity is linked to the user with the same verified email,
+// a new user with personal workspace is created if there is none. Users with two-factor authentication have to link identities themselves,
+// otherwise single sign-on would skip their second factor
+func FindOrProvisionUser(db *gorm.DB, claims *oidc.Claims) (*User, error) {
+	var user User
+	var identity UserIdentity
+
+	err := db.Where(&UserIdentity{Issuer: claims.Issuer, Subject: claims.Subject}).First(&identity).Error
+	if err == nil {
+		if err := db.First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, touchIdentity(db, &identity, claims)
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	// Only emails both sides verified identify the same person
+	email := NormalizeEmail(claims.Email)
+	if email != "" && claims.EmailVerified {
+		err := db.Where("email = ? AND email_verified = ?", email, true).First(&user).Error
+		if err == nil {
+			if user.TOTPEnabled {
+				return nil, h.NewIdentityLinkRequiredError()
+			}
+			return &user, LinkIdentity(db, user.ID, claims)
+		}
+		if !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	name, err := availableUserName(db, claims)
+	if err != nil {
+		return nil, err
+	}
+	// Provisioned users sign in through the provider, the password can be set with password reset
+	password, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	if password, err = HashPassword(password); err != nil {
+		return nil, err
+	}
+
+	user = User{Name: name, Password: password}
+	if email != "" && claims.EmailVerified {
+		var count int
+		if err := db.Model(&User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			user.Email = &email
+			user.EmailVerified = true
+		}
+	}
+
+	if err := db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	if _, err := CreateWorkspace(db, user.Name, user.ID, true); err != nil {
+		return nil, err
+	}
+
+	return &user, LinkIdentity(db, user.ID, claims)
+}
+
+// LinkIdentity : Links identity to the user, it can not be linked to another user at the same time
+func LinkIdentity(db *gorm.DB, userID uint64, claims *oidc.Claims) error {
+	var identity UserIdentity
+
+	err := db.Where(&UserIdentity{Issuer: claims.Issuer, Subject: claims.Subject}).First(&identity).Error
+	if err == nil {
+		if identity.UserID != userID {
+			return h.NewIdentityLinkedError()
+		}
+		return touchIdentity(db, &identity, claims)
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	now := time.Now()
+	return db.Create(&UserIdentity{UserID: userID, Issuer: claims.Issuer, Subject: claims.Subject, Email: claims.Email, LastLoginAt: &now}).Error
+}
+
+// touchIdentity : Updates time of the last sign-on and email reported by the provider
+func touchIdentity(db *gorm.DB, identity *UserIdentity, claims *oidc.Claims) error {
+	return db.Model(identity).Updates(map[string]interface{}{"email": claims.Email, "last_login_at": time.Now()}).Error
+}
+
+// availableUserName : Returns unused user name based on the provider's user name or email
+func availableUserName(db *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	name := base
+	for attempt := 0; attempt < 5; attempt++ {
+		// Names are at least 5 characters long, like the ones registered with password
+		if len(name) >= 5 {
+			var count int
+			if err := db.Model(&User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+				return "", err
+			}
+			if count == 0 {
+				return name, nil
+			}
+		}
+
+		suffix, err := randomHex(3)
+		if err != nil {
+			return "", err
+		}
+		name = base + "-" + suffix
+	}
+
+	return "", h.NewUserNameTakenError()
+}
+
+// ApplyGroupRoles : Grants workspace roles mapped from the user's groups. Memberships granted by the mapping earlier
+// are updated or removed if the user left the groups, memberships added by workspace owners are only upgraded
+func ApplyGroupRoles(db *gorm.DB, userID uint64, groups []string) error {
+	inGroup := make(map[string]bool)
+	for _, group := range groups {
+		inGroup[group] = true
+	}
+
+	// The highest role of all matching groups is granted, empty if the user is in none of the workspace's groups
+	granted := make(map[uint64]string)
+	for _, groupRole := range GroupRoles {
+		if _, exists := granted[groupRole.WorkspaceID]; !exists {
+			granted[groupRole.WorkspaceID] = ""
+		}
+		if inGroup[groupRole.Group] && roleRanks[groupRole.Role] > roleRanks[granted[groupRole.WorkspaceID]] {
+			granted[groupRole.WorkspaceID] = groupRole.Role
+		}
+	}
+
+	for workspaceID, role := range granted {
+		var workspace Workspace
+		if err := db.First(&workspace, workspaceID).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+		if workspace.Personal {
+			continue
+		}
+
+		var member WorkspaceMember
+		err := db.Where(&WorkspaceMember{WorkspaceID: workspaceID, UserID: userID}).First(&member).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		exists := err == nil
+
+		switch {
+		case !exists && role != "":
+			err = db.Create(&WorkspaceMember{WorkspaceID: workspaceID, UserID: userID, Role: role, Managed: true}).Error
+		case exists && role != "" && (member.Managed || roleRanks[role] > roleRanks[member.Role]):
+			err = db.Model(&member).Updates(map[string]interface{}{"role": role, "managed": true}).Error
+		case exists && role == "" && member.Managed:
+			var owners int
+			if err = db.Model(&WorkspaceMember{}).Where("workspace_id = ? AND role = ?", workspaceID, RoleOwner).Count(&owners).Error; err != nil {
+				return err
+			}
+			// Workspace is never left without owners
+			if member.Role != RoleOwner || owners > 1 {
+				err = db.Delete(&member).Error
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
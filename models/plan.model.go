@@ -0,0 +1,363 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	h "shorts/helper"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Plan : Tier of users or workspaces. Limits set to 0 are unlimited
+type Plan struct {
+	ID               uint64 `json:"id" gorm:"primary_key"`
+	Name             string `json:"name" gorm:"unique;not null"`
+	MaxLinks         int    `json:"maxLinks" gorm:"not null;default:0"`
+	MaxCustomAliases int    `json:"maxCustomAliases" gorm:"not null;default:0"`
+	MaxDomains       int    `json:"maxDomains" gorm:"not null;default:0"`
+	// Uses of links are deleted after this many days
+	RetentionDays int `json:"retentionDays" gorm:"not null;default:0"`
+	// Authenticated API requests per calendar month (UTC)
+	MaxAPICalls int       `json:"maxApiCalls" gorm:"column:max_api_calls;not null;default:0"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// APIUsage : Authenticated API requests of a user during a month
+type APIUsage struct {
+	ID     uint64 `gorm:"primary_key"`
+	UserID uint64 `gorm:"unique_index:idx_api_usages_user_month;not null"`
+	// Month in "2006-01" format
+	Month string `gorm:"unique_index:idx_api_usages_user_month;not null"`
+	Calls int    `gorm:"not null;default:0"`
+}
+
+// PlanData structure
+// swagger:parameters adminAddPlan adminUpdatePlan
+type PlanData struct {
+	Name             string `json:"name" binding:"required,max=100"`
+	MaxLinks         int    `json:"maxLinks" binding:"min=0"`
+	MaxCustomAliases int    `json:"maxCustomAliases" binding:"min=0"`
+	MaxDomains       int    `json:"maxDomains" binding:"min=0"`
+	RetentionDays    int    `json:"retentionDays" binding:"min=0"`
+	MaxAPICalls      int    `json:"maxApiCalls" binding:"min=0"`
+}
+
+// Apply : Sets limits of the plan
+func (d PlanData) Apply(plan *Plan) {
+	plan.Name = d.Name
+	plan.MaxLinks = d.MaxLinks
+	plan.MaxCustomAliases = d.MaxCustomAliases
+	plan.MaxDomains = d.MaxDomains
+	plan.RetentionDays = d.RetentionDays
+	plan.MaxAPICalls = d.MaxAPICalls
+}
+
+// SetWorkspacePlanData structure
+// swagger:parameters adminSetWorkspacePlan
+type SetWorkspacePlanData struct {
+	// Plan of the workspace, 0 removes it so links count against plans of their creators
+	PlanID *uint64 `json:"planId" binding:"required"`
+}
+
+// QuotaUsage : Used amount of a limited resource, limit 0 is unlimited
+type QuotaUsage struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+}
+
+// Full : Checks if nothing more can be used
+func (q QuotaUsage) Full() bool {
+	return q.Limit > 0 && q.Used >= q.Limit
+}
+
+// LinkUsage : Links and custom aliases counted against the plan of a workspace or, if it has none, of the link creator
+type LinkUsage struct {
+	Plan          *Plan      `json:"plan"`
+	Links         QuotaUsage `json:"links"`
+	CustomAliases QuotaUsage `json:"customAliases"`
+}
+
+// Check : Returns error if a new link (with custom alias) would exceed the plan
+func (u *LinkUsage) Check(customAlias bool) error {
+	if u.Links.Full() {
+		return h.NewQuotaExceededError("links", u.Links.Limit)
+	}
+	if customAlias && u.CustomAliases.Full() {
+		return h.NewQuotaExceededError("custom aliases", u.CustomAliases.Limit)
+	}
+
+	return nil
+}
+
+// WorkspaceUsage : Usage of a workspace with its own plan
+type WorkspaceUsage struct {
+	WorkspaceID uint64 `json:"workspaceId"`
+	Name        string `json:"name"`
+	LinkUsage
+}
+
+// UsageResponseData : Usage of current user's plan and of plans of the user's workspaces
+type UsageResponseData struct {
+	LinkUsage
+	Domains  QuotaUsage `json:"domains"`
+	APICalls QuotaUsage `json:"apiCalls"`
+	// Month of counted API calls ("2006-01")
+	Month      string           `json:"month"`
+	Workspaces []WorkspaceUsage `json:"workspaces"`
+}
+
+// DefaultPlan : Returns plan named by DEFAULT_PLAN used by users without a plan, unlimited plan if it does not exist
+func DefaultPlan(db *gorm.DB) (*Plan, error) {
+	plan := Plan{Name: "unlimited"}
+
+	if name := h.GetEnv("DEFAULT_PLAN", ""); name != "" {
+		if err := db.Where(&Plan{Name: name}).First(&plan).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	return &plan, nil
+}
+
+// UserPlan : Returns plan of the user
+func UserPlan(db *gorm.DB, userID uint64) (*Plan, error) {
+	var user User
+	if err := db.Select("id, plan_id").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.PlanID == 0 {
+		return DefaultPlan(db)
+	}
+
+	var plan Plan
+	if err := db.First(&plan, user.PlanID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return DefaultPlan(db)
+		}
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// LockLinkQuota : Locks the user and the workspace until the end of the transaction, links counted against either of them
+// can not be created concurrently then. The user is always locked first
+func LockLinkQuota(db *gorm.DB, userID, workspaceID uint64) error {
+	if err := db.Set("gorm:query_option", "FOR UPDATE").Select("id").First(&User{}, userID).Error; err != nil {
+		return err
+	}
+
+	return db.Set("gorm:query_option", "FOR UPDATE").Select("id").First(&Workspace{}, workspaceID).Error
+}
+
+// LinkQuota : Returns link usage of the workspace if it has a plan, otherwise of the user's links in workspaces without plans
+func LinkQuota(db *gorm.DB, userID, workspaceID uint64) (*LinkUsage, error) {
+	var workspace Workspace
+	if err := db.First(&workspace, workspaceID).Error; err != nil {
+		return nil, err
+	}
+
+	if workspace.PlanID != 0 {
+		var plan Plan
+		if err := db.First(&plan, workspace.PlanID).Error; err == nil {
+			return linkUsage(db, &plan, db.Where("workspace_id = ?", workspace.ID))
+		} else if !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	return userLinkUsage(db, userID)
+}
+
+// userLinkUsage : Returns usage of links the user created in workspaces without plans
+func userLinkUsage(db *gorm.DB, userID uint64) (*LinkUsage, error) {
+	plan, err := UserPlan(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return linkUsage(db, plan, db.Where("owner_id = ? AND workspace_id IN (?)", userID,
+		db.Table("workspaces").Select("id").Where("plan_id = 0").SubQuery()))
+}
+
+// linkUsage : Counts links and custom aliases selected by the query
+func linkUsage(db *gorm.DB, plan *Plan, links *gorm.DB) (*LinkUsage, error) {
+	usage := LinkUsage{
+		Plan:          plan,
+		Links:         QuotaUsage{Limit: plan.MaxLinks},
+		CustomAliases: QuotaUsage{Limit: plan.MaxCustomAliases},
+	}
+
+	if err := links.Model(&Shortlink{}).Count(&usage.Links.Used).Error; err != nil {
+		return nil, err
+	}
+	if err := links.Model(&Shortlink{}).Where("custom_alias = ?", true).Count(&usage.CustomAliases.Used).Error; err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// DomainQuota : Returns custom domains usage of the user
+func DomainQuota(db *gorm.DB, userID uint64) (QuotaUsage, error) {
+	plan, err := UserPlan(db, userID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	usage := QuotaUsage{Limit: plan.MaxDomains}
+	err = db.Model(&Domain{}).Where("owner_id = ?", userID).Count(&usage.Used).Error
+	return usage, err
+}
+
+// APIMonth : Returns month API calls are counted for
+func APIMonth(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// apiUsageKey : User and month of counted API calls
+type apiUsageKey struct {
+	userID uint64
+	month  string
+}
+
+// pendingAPICalls : API calls counted since the last flush, they are written with one update per user (see FlushAPICalls)
+var pendingAPICalls = struct {
+	sync.Mutex
+	calls map[apiUsageKey]int
+}{calls: make(map[apiUsageKey]int)}
+
+// CountAPICall : Counts API call of the user in the current month and returns API calls usage including it.
+// Calls are counted in memory, so requests only read the stored usage
+func CountAPICall(db *gorm.DB, userID uint64) (QuotaUsage, error) {
+	plan, err := UserPlan(db, userID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	key := apiUsageKey{userID: userID, month: APIMonth(time.Now())}
+	var stored APIUsage
+	if err := db.Where(&APIUsage{UserID: key.userID, Month: key.month}).First(&stored).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return QuotaUsage{}, err
+	}
+
+	pendingAPICalls.Lock()
+	pendingAPICalls.calls[key]++
+	pending := pendingAPICalls.calls[key]
+	pendingAPICalls.Unlock()
+
+	return QuotaUsage{Used: stored.Calls + pending, Limit: plan.MaxAPICalls}, nil
+}
+
+// pendingAPICallsOf : Returns API calls of the user in the month that are not written yet
+func pendingAPICallsOf(userID uint64, month string) int {
+	pendingAPICalls.Lock()
+	defer pendingAPICalls.Unlock()
+
+	return pendingAPICalls.calls[apiUsageKey{userID: userID, month: month}]
+}
+
+// FlushAPICalls : Adds API calls counted in memory to the stored usage, calls that could not be written are kept for the next flush
+func FlushAPICalls(db *gorm.DB) error {
+	pendingAPICalls.Lock()
+	calls := pendingAPICalls.calls
+	pendingAPICalls.calls = make(map[apiUsageKey]int)
+	pendingAPICalls.Unlock()
+
+	var firstErr error
+	for key, count := range calls {
+		err := db.Exec(`INSERT INTO api_usages (user_id, month, calls) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, month) DO UPDATE SET calls = api_usages.calls + EXCLUDED.calls`, key.userID, key.month, count).Error
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		pendingAPICalls.Lock()
+		pendingAPICalls.calls[key] += count
+		pendingAPICalls.Unlock()
+	}
+
+	return firstErr
+}
+
+// Usage : Returns usage of the user's plan and of workspaces with their own plans the user is member of
+func Usage(db *gorm.DB, userID uint64) (*UsageResponseData, error) {
+	linkUsage, err := userLinkUsage(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	domains, err := DomainQuota(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := UsageResponseData{
+		LinkUsage:  *linkUsage,
+		Domains:    domains,
+		APICalls:   QuotaUsage{Limit: linkUsage.Plan.MaxAPICalls},
+		Month:      APIMonth(time.Now()),
+		Workspaces: make([]WorkspaceUsage, 0),
+	}
+
+	var apiUsage APIUsage
+	if err := db.Where(&APIUsage{UserID: userID, Month: usage.Month}).First(&apiUsage).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	usage.APICalls.Used = apiUsage.Calls + pendingAPICallsOf(userID, usage.Month)
+
+	var workspaces []Workspace
+	if err := db.Where("plan_id <> 0 AND id IN (?)", db.Table("workspace_members").Select("workspace_id").Where("user_id = ?", userID).SubQuery()).
+		Order("id").Find(&workspaces).Error; err != nil {
+		return nil, err
+	}
+	for _, workspace := range workspaces {
+		workspaceUsage, err := LinkQuota(db, userID, workspace.ID)
+		if err != nil {
+			return nil, err
+		}
+		usage.Workspaces = append(usage.Workspaces, WorkspaceUsage{WorkspaceID: workspace.ID, Name: workspace.Name, LinkUsage: *workspaceUsage})
+	}
+
+	return &usage, nil
+}
+
+// PruneUses : Deletes uses and unique visitor sketches older than retention of the links' plans, returns number of deleted uses
+func PruneUses(db *gorm.DB) (int64, error) {
+	defaultPlan, err := DefaultPlan(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var plans []Plan
+	if err := db.Where("retention_days > 0").Find(&plans).Error; err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, plan := range plans {
+		// Workspace plan takes precedence over plan of the link creator
+		links := db.Table("shortlinks").Select("shortlinks.id").
+			Joins("join workspaces on workspaces.id = shortlinks.workspace_id").
+			Joins("join users on users.id = shortlinks.owner_id").
+			Where("COALESCE(NULLIF(workspaces.plan_id, 0), NULLIF(users.plan_id, 0), ?) = ?", defaultPlan.ID, plan.ID).SubQuery()
+		cutoff := time.Now().AddDate(0, 0, -plan.RetentionDays)
+
+		dbc := db.Where("link_id IN (?) AND use_time < ?", links, cutoff).Delete(&ShortlinkUse{})
+		if dbc.Error != nil {
+			return deleted, dbc.Error
+		}
+		deleted += dbc.RowsAffected
+
+		if err := db.Where("link_id IN (?) AND day < ?", links, cutoff).Delete(&ShortlinkSketch{}).Error; err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
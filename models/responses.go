@@ -1,16 +1,26 @@
 package models
 
+import "time"
+
 // ShortlinkResponseData structure
 type ShortlinkResponseData struct {
-	ID    uint64 `json:"id"`
-	Short string `json:"short"`
-	Full  string `json:"full"`
+	ID          uint64 `json:"id"`
+	Short       string `json:"short"`
+	Full        string `json:"full"`
+	URL         string `json:"url"`
+	WorkspaceID uint64 `json:"workspaceId"`
+	// Link is not redirected after this time
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 // UserResponseData contains information about user
 type UserResponseData struct {
-	ID   uint64 `json:"id" gorm:"primary_key"`
-	Name string `json:"name" gorm:"unique;not null" binding:"required,min=5"`
+	ID            uint64  `json:"id" gorm:"primary_key"`
+	Name          string  `json:"name" gorm:"unique;not null" binding:"required,min=5"`
+	Email         *string `json:"email,omitempty"`
+	EmailVerified bool    `json:"emailVerified"`
+	// Email waiting for verification, the verified one is used meanwhile
+	PendingEmail *string `json:"pendingEmail,omitempty"`
 }
 
 // UserResponse contains information about user
@@ -29,6 +39,8 @@ type FullLinkUseCountResponse struct {
 type TopDomainsResponseData struct {
 	Website   string `json:"website"`
 	UsesCount uint64 `json:"usesCount"`
+	// Estimated number of unique visitors
+	Unique uint64 `json:"unique"`
 }
 
 // ShortlinkResponse structure
@@ -55,6 +67,41 @@ type TopDomainsResponse struct {
 	Result string                   `json:"result"`
 }
 
+// ShortlinkVariantStats : Uses count of one destination of A/B split
+type ShortlinkVariantStats struct {
+	DestinationID uint64 `json:"destinationId"`
+	Label         string `json:"label"`
+	URL           string `json:"url"`
+	Weight        uint   `json:"weight"`
+	UsesCount     uint64 `json:"usesCount"`
+}
+
+// ShortlinkStatsResponseData : Statistics of a short link
+type ShortlinkStatsResponseData struct {
+	UsesCount uint64 `json:"usesCount"`
+	// Estimated number of unique visitors
+	Unique uint64 `json:"unique"`
+	// Uses that came from scanned QR codes
+	QRUsesCount uint64 `json:"qrUsesCount"`
+	// Destinations of A/B split, including removed ones that still have uses (without URL)
+	Variants []ShortlinkVariantStats `json:"variants"`
+	// Days (UTC) with uses
+	Days []ShortlinkDayStats `json:"days"`
+}
+
+// ShortlinkDayStats : Uses count and unique visitors of a short link during a day
+type ShortlinkDayStats struct {
+	Day       string `json:"day"`
+	UsesCount uint64 `json:"usesCount"`
+	Unique    uint64 `json:"unique"`
+}
+
+// ShortlinkStatsResponse structure
+type ShortlinkStatsResponse struct {
+	Data   ShortlinkStatsResponseData `json:"data"`
+	Result string                     `json:"result"`
+}
+
 // DataMinutes : Information about uses per minute
 type DataMinutes = map[int]int
 
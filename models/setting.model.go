@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Setting : Value generated by the service once and kept across restarts, e.g. key of visitor fingerprints
+type Setting struct {
+	Key   string `gorm:"primary_key"`
+	Value string `gorm:"not null"`
+}
+
+// GeneratedSecret : Returns random secret stored under the key. The first instance asking for it generates it,
+// other instances and restarts get the same one
+func GeneratedSecret(db *gorm.DB, key string) (string, error) {
+	random, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := db.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING", key, random).Error; err != nil {
+		return "", err
+	}
+
+	var setting Setting
+	if err := db.Where("key = ?", key).First(&setting).Error; err != nil {
+		return "", err
+	}
+
+	return setting.Value, nil
+}
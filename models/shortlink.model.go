@@ -1,30 +1,253 @@
 package models
 
 import (
+	"time"
+
+	"shorts/database"
 	h "shorts/helper"
+	"shorts/slug"
 
 	"github.com/jinzhu/gorm"
 )
 
 // Shortlink structure
 type Shortlink struct {
-	ID      uint64 `json:"id" gorm:"primary_key"`
-	Short   string `json:"short" gorm:"unique;not null"`
-	Full    string `json:"full" gorm:"not null"`
-	OwnerID uint64 `json:"ownerId" gorm:"not null"`
+	ID    uint64 `json:"id" gorm:"primary_key"`
+	Short string `json:"short" gorm:"unique_index:idx_shortlinks_domain_short;not null"`
+	// Short link was requested instead of generated
+	CustomAlias bool   `json:"customAlias" gorm:"not null;default:false"`
+	Full        string `json:"full" gorm:"not null"`
+	OwnerID     uint64 `json:"ownerId" gorm:"not null"`
+	// Workspace sharing the link, members access it according to their roles
+	WorkspaceID uint64 `json:"workspaceId" gorm:"index;not null;default:0"`
+	DomainID    uint64 `json:"domainId" gorm:"unique_index:idx_shortlinks_domain_short;not null;default:0"`
+	URL         string `json:"url" gorm:"-"`
+	Title       string `json:"title" gorm:"not null;default:''"`
+	// Show page with countdown before redirecting visitors
+	Interstitial bool `json:"interstitial" gorm:"not null;default:false"`
+	// Link is not redirected after this time
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// "link.expired" webhook event was queued
+	ExpiredNotified bool `json:"-" gorm:"not null;default:false"`
+	// Link was disabled by administrator and is not redirected
+	Disabled       bool   `json:"disabled" gorm:"not null;default:false"`
+	DisabledReason string `json:"disabledReason" gorm:"not null;default:''"`
+
+	ShortlinkQueryData
+	ShortlinkAppData
+	ShortlinkSocialData
+
+	// Keep visitor on the same destination of A/B split
+	StickyDestinations bool `json:"stickyDestinations" gorm:"not null;default:false"`
 
-	Uses []ShortlinkUse `gorm:"ForeignKey:LinkID" json:"uses"`
+	Uses         []ShortlinkUse         `gorm:"ForeignKey:LinkID" json:"uses"`
+	Rules        []ShortlinkRule        `gorm:"ForeignKey:LinkID" json:"rules"`
+	Destinations []ShortlinkDestination `gorm:"ForeignKey:LinkID" json:"destinations"`
 }
 
-// AfterCreate for updating `short` field
-func (s *Shortlink) AfterCreate(tx *gorm.DB) (err error) {
-	tx.Model(s).Update("short", h.MakeShortlinkFromID(s.ID))
+// BeforeCreate for generating `short` field if custom one was not requested
+func (s *Shortlink) BeforeCreate() (err error) {
+	generator := slug.FromEnv()
+	if s.Short != "" || generator.UsesID() {
+		return
+	}
+
+	s.Short, err = generator.Generate(0)
 	return
 }
 
+// AfterCreate for generating `short` field from ID if custom one was not requested
+func (s *Shortlink) AfterCreate(tx *gorm.DB) (err error) {
+	if s.Short != "" {
+		return
+	}
+
+	short, err := slug.FromEnv().Generate(s.ID)
+	if err != nil {
+		return
+	}
+
+	return tx.Model(s).Update("short", short).Error
+}
+
+// Expired : Checks if the link can not be used anymore
+func (s *Shortlink) Expired() bool {
+	return s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now())
+}
+
+// Varies : Checks if visitors may be sent somewhere else than the full link
+func (s *Shortlink) Varies() bool {
+	return len(s.Rules) > 0 || len(s.Destinations) > 0
+}
+
+// PublicURL : Returns public URL of the short link including its custom domain
+func (s *Shortlink) PublicURL() string {
+	if s.DomainID == DefaultDomainID {
+		return h.MakeShortURL("", s.Short)
+	}
+
+	var domain Domain
+	if err := database.DB.First(&domain, s.DomainID).Error; err != nil {
+		return h.MakeShortURL("", s.Short)
+	}
+
+	return h.MakeShortURL(domain.Host, s.Short)
+}
+
+// ResponseData : Returns short link information for API responses
+func (s *Shortlink) ResponseData() ShortlinkResponseData {
+	return ShortlinkResponseData{
+		ID:          s.ID,
+		Short:       s.Short,
+		Full:        s.Full,
+		URL:         s.PublicURL(),
+		WorkspaceID: s.WorkspaceID,
+		ExpiresAt:   s.ExpiresAt,
+	}
+}
+
+// AdminUpdateShortlinkData : Disabling or enabling short link by administrator
+// swagger:parameters adminUpdateShortlink
+type AdminUpdateShortlinkData struct {
+	Disabled *bool `json:"disabled" binding:"required"`
+	// Shown to visitors of disabled link
+	Reason string `json:"reason" binding:"max=200"`
+}
+
 // ShortlinkAddData structure
 // swagger:parameters addShortlink
 type ShortlinkAddData struct {
-	Short string `json:"short" gorm:"unique;not null"`
-	Full  string `json:"full" gorm:"not null"`
+	Short  string `json:"short" gorm:"unique;not null"`
+	Full   string `json:"full" gorm:"not null"`
+	Domain string `json:"domain"`
+	// Workspace of the link, personal workspace is used if it is not set
+	WorkspaceID uint64 `json:"workspaceId"`
+	Title       string `json:"title" binding:"max=200"`
+	// Show page with countdown before redirecting visitors
+	Interstitial bool `json:"interstitial"`
+	// Link is not redirected after this time
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// Return existing short link of the user with the same (normalized) full link instead of creating a new one,
+	// ignored if custom short link is requested
+	ReuseExisting bool `json:"reuseExisting"`
+
+	ShortlinkQueryData
+	ShortlinkAppData
+	ShortlinkSocialData
+}
+
+// ShortlinkUpdateData structure, only passed fields are updated
+// swagger:parameters updateShortlink
+type ShortlinkUpdateData struct {
+	Full         *string      `json:"full"`
+	Title        *string      `json:"title" binding:"omitempty,max=200"`
+	Interstitial *bool        `json:"interstitial"`
+	ExpiresAt    *time.Time   `json:"expiresAt"`
+	UTMSource    *string      `json:"utmSource"`
+	UTMMedium    *string      `json:"utmMedium"`
+	UTMCampaign  *string      `json:"utmCampaign"`
+	UTMTerm      *string      `json:"utmTerm"`
+	UTMContent   *string      `json:"utmContent"`
+	QueryParams  *QueryParams `json:"queryParams"`
+	ForwardQuery *bool        `json:"forwardQuery"`
+
+	AppURI           *string `json:"appUri"`
+	IOSUniversalLink *string `json:"iosUniversalLink"`
+	AndroidIntent    *string `json:"androidIntent"`
+
+	SocialTitle       *string `json:"socialTitle" binding:"omitempty,max=200"`
+	SocialDescription *string `json:"socialDescription" binding:"omitempty,max=500"`
+	SocialImage       *string `json:"socialImage"`
+	TwitterCard       *string `json:"twitterCard"`
+}
+
+// Changes : Returns columns that have to be updated
+func (u ShortlinkUpdateData) Changes() map[string]interface{} {
+	changes := make(map[string]interface{})
+	for column, value := range map[string]*string{
+		"full":         u.Full,
+		"title":        u.Title,
+		"utm_source":   u.UTMSource,
+		"utm_medium":   u.UTMMedium,
+		"utm_campaign": u.UTMCampaign,
+		"utm_term":     u.UTMTerm,
+		"utm_content":  u.UTMContent,
+
+		"app_uri":            u.AppURI,
+		"ios_universal_link": u.IOSUniversalLink,
+		"android_intent":     u.AndroidIntent,
+
+		"social_title":       u.SocialTitle,
+		"social_description": u.SocialDescription,
+		"social_image":       u.SocialImage,
+		"twitter_card":       u.TwitterCard,
+	} {
+		if value != nil {
+			changes[column] = *value
+		}
+	}
+
+	if u.QueryParams != nil {
+		changes["query_params"] = *u.QueryParams
+	}
+	if u.ForwardQuery != nil {
+		changes["forward_query"] = *u.ForwardQuery
+	}
+	if u.Interstitial != nil {
+		changes["interstitial"] = *u.Interstitial
+	}
+	if u.ExpiresAt != nil {
+		changes["expires_at"] = *u.ExpiresAt
+		// New expiration time is reported again
+		changes["expired_notified"] = false
+	}
+
+	return changes
+}
+
+// Validate : Returns error if deep links can not be used
+func (a ShortlinkAppData) Validate() error {
+	if a.AppURI != "" && !IsValidAppURI(a.AppURI) {
+		return h.NewInvalidDeepLinkError("appUri")
+	}
+	if a.IOSUniversalLink != "" && !IsValidUniversalLink(a.IOSUniversalLink) {
+		return h.NewInvalidDeepLinkError("iosUniversalLink")
+	}
+	if a.AndroidIntent != "" && !IsValidAndroidIntent(a.AndroidIntent) {
+		return h.NewInvalidDeepLinkError("androidIntent")
+	}
+
+	return nil
+}
+
+// Validate : Returns error if changed deep links or social tags can not be used
+func (u ShortlinkUpdateData) Validate() error {
+	var socialData ShortlinkSocialData
+	if u.SocialImage != nil {
+		socialData.SocialImage = *u.SocialImage
+	}
+	if u.TwitterCard != nil {
+		socialData.TwitterCard = *u.TwitterCard
+	}
+	if err := socialData.Validate(); err != nil {
+		return err
+	}
+
+	return u.AppData().Validate()
+}
+
+// AppData : Returns changed deep links, unchanged ones are empty
+func (u ShortlinkUpdateData) AppData() ShortlinkAppData {
+	var appData ShortlinkAppData
+	if u.AppURI != nil {
+		appData.AppURI = *u.AppURI
+	}
+	if u.IOSUniversalLink != nil {
+		appData.IOSUniversalLink = *u.IOSUniversalLink
+	}
+	if u.AndroidIntent != nil {
+		appData.AndroidIntent = *u.AndroidIntent
+	}
+
+	return appData
 }
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+
+	h "shorts/helper"
+	"shorts/visitor"
+)
+
+// ShortlinkAppData : Deep links opening native app instead of the full link
+type ShortlinkAppData struct {
+	// Custom scheme deep link, e.g. "myapp://product/42". Opened from a page falling back to the full link
+	AppURI string `json:"appUri"`
+	// iOS universal link, iOS opens the app if it is installed and the link in browser otherwise
+	IOSUniversalLink string `json:"iosUniversalLink"`
+	// Android intent URL, e.g. "intent://product/42#Intent;scheme=myapp;package=com.example.app;end"
+	AndroidIntent string `json:"androidIntent"`
+}
+
+// AppSchemes : Returns custom schemes of apps allowed for deep links (APP_SCHEMES), none by default.
+// Schemes are listed explicitly, browsers run or show many schemes themselves (javascript, data, file...)
+func AppSchemes() []string {
+	var schemes []string
+	for _, scheme := range strings.Split(h.GetEnv("APP_SCHEMES", ""), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	return schemes
+}
+
+// IsValidAppURI : Checks that deep link has a custom scheme of an app from APP_SCHEMES
+func IsValidAppURI(uri string) bool {
+	parsedURL, err := url.Parse(uri)
+	if err != nil || parsedURL.Scheme == "" {
+		return false
+	}
+
+	return containsFold(AppSchemes(), parsedURL.Scheme)
+}
+
+// IsValidUniversalLink : Checks that universal link is https link
+func IsValidUniversalLink(link string) bool {
+	parsedURL, err := url.Parse(link)
+	return err == nil && parsedURL.Scheme == "https" && parsedURL.Host != ""
+}
+
+// IsValidAndroidIntent : Checks that link is an intent URL. Its data scheme has to be in APP_SCHEMES,
+// intents with web schemes would open any page in browser
+func IsValidAndroidIntent(intent string) bool {
+	if !strings.HasPrefix(intent, "intent:") || !strings.Contains(intent, "#Intent;") || !strings.HasSuffix(intent, ";end") {
+		return false
+	}
+
+	scheme, ok := androidIntentExtra(intent, "scheme")
+	return !ok || containsFold(AppSchemes(), scheme)
+}
+
+// AndroidIntentFallback : Returns browser fallback link of intent URL or empty string if it is not set
+func AndroidIntentFallback(intent string) (string, error) {
+	fallback, ok := androidIntentExtra(intent, "S.browser_fallback_url")
+	if !ok {
+		return "", nil
+	}
+
+	return url.QueryUnescape(fallback)
+}
+
+// androidIntentExtra : Returns raw value of intent URL's parameter (e.g. "scheme" or "S.browser_fallback_url")
+func androidIntentExtra(intent, name string) (string, bool) {
+	parts := strings.SplitN(intent, "#Intent;", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	for _, param := range strings.Split(parts[1], ";") {
+		if strings.HasPrefix(param, name+"=") {
+			return strings.TrimPrefix(param, name+"="), true
+		}
+	}
+
+	return "", false
+}
+
+// AndroidIntentWithFallback : Returns intent URL that opens fallback link in browser if app is not installed
+func AndroidIntentWithFallback(intent, fallback string) string {
+	if strings.Contains(intent, ";S.browser_fallback_url=") {
+		return intent
+	}
+
+	return strings.TrimSuffix(intent, "end") + "S.browser_fallback_url=" + url.QueryEscape(fallback) + ";end"
+}
+
+// AppRedirect : How visitor is sent to the app
+type AppRedirect struct {
+	// Link to redirect to directly
+	Location string
+	// Deep link to open from a page that falls back to the full link
+	AppURI string
+}
+
+// HasDeepLinks : Checks if any deep link is set
+func (a ShortlinkAppData) HasDeepLinks() bool {
+	return a.AppURI != "" || a.IOSUniversalLink != "" || a.AndroidIntent != ""
+}
+
+// AppRedirect : Returns how visitor's device should open the app or nil if the fallback link has to be used
+func (a ShortlinkAppData) AppRedirect(device, fallback string) *AppRedirect {
+	switch {
+	case device == visitor.DeviceIOS && a.IOSUniversalLink != "":
+		return &AppRedirect{Location: a.IOSUniversalLink}
+	case device == visitor.DeviceAndroid && a.AndroidIntent != "":
+		return &AppRedirect{Location: AndroidIntentWithFallback(a.AndroidIntent, fallback)}
+	case visitor.IsMobile(device) && a.AppURI != "":
+		return &AppRedirect{AppURI: a.AppURI}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"math/rand"
+)
+
+// ShortlinkDestination : One of weighted destinations of a short link (A/B split)
+type ShortlinkDestination struct {
+	ID     uint64 `json:"id" gorm:"primary_key"`
+	LinkID uint64 `json:"-" gorm:"not null;index"`
+	Label  string `json:"label"`
+	URL    string `json:"url" gorm:"not null"`
+	Weight uint   `json:"weight" gorm:"not null"`
+}
+
+// ShortlinkDestinationData structure
+type ShortlinkDestinationData struct {
+	Label  string `json:"label"`
+	URL    string `json:"url" binding:"required"`
+	Weight uint   `json:"weight" binding:"required,min=1,max=1000000"`
+}
+
+// ShortlinkDestinationsData structure
+// swagger:parameters setShortlinkDestinations
+type ShortlinkDestinationsData struct {
+	// Send visitor to the same destination on every click (remembered in a cookie)
+	Sticky bool `json:"sticky"`
+	// Empty list disables the split, the full link is used then
+	Destinations []ShortlinkDestinationData `json:"destinations" binding:"dive"`
+}
+
+// FindDestination : Returns destination with the ID or nil
+func (s *Shortlink) FindDestination(destinationID uint64) *ShortlinkDestination {
+	for i := range s.Destinations {
+		if s.Destinations[i].ID == destinationID {
+			return &s.Destinations[i]
+		}
+	}
+
+	return nil
+}
+
+// ChooseDestination : Returns random destination with probability proportional to its weight or nil if there are none
+func (s *Shortlink) ChooseDestination() *ShortlinkDestination {
+	var totalWeight int64
+	for _, destination := range s.Destinations {
+		totalWeight += int64(destination.Weight)
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	point := rand.Int63n(totalWeight)
+	for i := range s.Destinations {
+		if point < int64(s.Destinations[i].Weight) {
+			return &s.Destinations[i]
+		}
+		point -= int64(s.Destinations[i].Weight)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"net/url"
+)
+
+// QRMarkerParam : Query parameter added to short links encoded into QR codes, it is removed before redirect
+const QRMarkerParam = "qr"
+
+// UseSourceQR : Source of uses that came from scanned QR codes
+const UseSourceQR = "qr"
+
+// ShortlinkQROptions structure
+// swagger:parameters getShortlinkQR
+type ShortlinkQROptions struct {
+	// Image format, png or svg
+	// in: query
+	Format string `form:"format" binding:"omitempty,oneof=png svg"`
+	// Width and height of the image in pixels
+	// in: query
+	Size int `form:"size" binding:"omitempty,min=21,max=4096"`
+	// Error correction level, one of L, M, Q, H
+	// in: query
+	Level string `form:"level" binding:"omitempty,oneof=L M Q H l m q h"`
+	// Quiet zone around the code in modules
+	// in: query
+	Margin *int `form:"margin" binding:"omitempty,min=0,max=32"`
+	// Color of dark modules in hex, for example 000000
+	// in: query
+	Foreground string `form:"fg"`
+	// Color of light modules in hex, for example ffffff
+	// in: query
+	Background string `form:"bg"`
+}
+
+// QRURL : Returns public URL of the short link with QR marker, so scans are counted separately
+func (s *Shortlink) QRURL() string {
+	publicURL := s.PublicURL()
+	parsedURL, err := url.Parse(publicURL)
+	if err != nil {
+		return publicURL
+	}
+
+	query := parsedURL.Query()
+	query.Set(QRMarkerParam, "1")
+	parsedURL.RawQuery = query.Encode()
+
+	return parsedURL.String()
+}
+
+// TakeUseSource : Returns source of the use by the visitor query and removes markers from the query
+func TakeUseSource(visitorQuery url.Values) string {
+	if _, exists := visitorQuery[QRMarkerParam]; exists {
+		visitorQuery.Del(QRMarkerParam)
+		return UseSourceQR
+	}
+
+	return ""
+}
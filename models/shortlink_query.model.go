@@ -0,0 +1,112 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+// QueryParams : Query parameters stored as JSON object
+type QueryParams map[string]string
+
+// Value : Converts query parameters to JSON for the DB
+func (q QueryParams) Value() (driver.Value, error) {
+	if len(q) == 0 {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(q)
+	return string(encoded), err
+}
+
+// Scan : Reads query parameters from JSON stored in the DB
+func (q *QueryParams) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*q = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("Unsupported type of query parameters")
+	}
+
+	if len(data) == 0 {
+		*q = nil
+		return nil
+	}
+
+	return json.Unmarshal(data, q)
+}
+
+// ShortlinkQueryData : Query parameters that are added to the full link on redirect
+type ShortlinkQueryData struct {
+	UTMSource   string `json:"utmSource"`
+	UTMMedium   string `json:"utmMedium"`
+	UTMCampaign string `json:"utmCampaign"`
+	UTMTerm     string `json:"utmTerm"`
+	UTMContent  string `json:"utmContent"`
+	// Parameters added only if they are not set by the full link or the visitor
+	QueryParams QueryParams `json:"queryParams" gorm:"type:text"`
+	// Pass query string of the short link (/s/{short}?...) to the full link
+	ForwardQuery bool `json:"forwardQuery" gorm:"not null;default:false"`
+}
+
+// utmParams : Returns UTM parameters that are set
+func (q ShortlinkQueryData) utmParams() url.Values {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"utm_source":   q.UTMSource,
+		"utm_medium":   q.UTMMedium,
+		"utm_campaign": q.UTMCampaign,
+		"utm_term":     q.UTMTerm,
+		"utm_content":  q.UTMContent,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	return params
+}
+
+// BuildDestination : Returns target link with query parameters of the short link merged into it.
+// When the same key is set several times, the value is taken from (in order of precedence):
+// UTM parameters of the short link, visitor's query string (if ForwardQuery is set),
+// query of the target link itself, default QueryParams of the short link
+func (q ShortlinkQueryData) BuildDestination(target string, visitorQuery url.Values) string {
+	utmParams := q.utmParams()
+	if len(utmParams) == 0 && len(q.QueryParams) == 0 && (!q.ForwardQuery || len(visitorQuery) == 0) {
+		return target
+	}
+
+	parsedURL, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	query := parsedURL.Query()
+	for key, value := range q.QueryParams {
+		if _, exists := query[key]; !exists {
+			query.Set(key, value)
+		}
+	}
+
+	if q.ForwardQuery {
+		for key, values := range visitorQuery {
+			query[key] = values
+		}
+	}
+
+	for key, values := range utmParams {
+		query[key] = values
+	}
+
+	parsedURL.RawQuery = query.Encode()
+
+	return parsedURL.String()
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"shorts/visitor"
+)
+
+// ShortlinkRule : Conditional redirect of a short link. Empty conditions match every visitor
+type ShortlinkRule struct {
+	ID       uint64 `json:"id" gorm:"primary_key"`
+	LinkID   uint64 `json:"-" gorm:"not null;index"`
+	Position int    `json:"position" gorm:"not null"`
+	// ios, android, mobile (any mobile device) or desktop
+	Device string `json:"device"`
+	// Comma separated ISO 3166-1 alpha-2 codes
+	Country string `json:"country"`
+	// Comma separated language tags, "de" matches "de-at" too
+	Language string `json:"language"`
+	// Comma separated days of week: mon, tue, wed, thu, fri, sat, sun
+	Weekdays string `json:"weekdays"`
+	// Hours [from, to) of the day, not checked if they are equal. "to" may be less than "from" (e.g. 22-6)
+	HourFrom int `json:"hourFrom"`
+	HourTo   int `json:"hourTo"`
+	// IANA time zone of weekdays and hours, UTC by default
+	TimeZone string `json:"timeZone"`
+	Target   string `json:"target" gorm:"not null"`
+	Hits     uint64 `json:"hits" gorm:"not null;default:0"`
+}
+
+// ShortlinkRuleData structure
+type ShortlinkRuleData struct {
+	Device   string `json:"device" binding:"omitempty,oneof=ios android mobile desktop"`
+	Country  string `json:"country"`
+	Language string `json:"language"`
+	Weekdays string `json:"weekdays"`
+	HourFrom int    `json:"hourFrom" binding:"min=0,max=24"`
+	HourTo   int    `json:"hourTo" binding:"min=0,max=24"`
+	TimeZone string `json:"timeZone"`
+	Target   string `json:"target" binding:"required"`
+}
+
+// ShortlinkRulesData structure
+// swagger:parameters setShortlinkRules
+type ShortlinkRulesData struct {
+	// Rules in order of evaluation, the first matching rule is used
+	Rules []ShortlinkRuleData `json:"rules" binding:"dive"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// IsValidWeekdays : Checks that weekdays are comma separated list of known days
+func IsValidWeekdays(days string) bool {
+	for _, day := range splitList(days) {
+		if _, exists := weekdays[strings.ToLower(day)]; !exists {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsValidTimeZone : Checks that time zone is known
+func IsValidTimeZone(timeZone string) bool {
+	_, err := time.LoadLocation(timeZone)
+	return err == nil
+}
+
+// Matches : Checks if visitor satisfies all conditions of the rule
+func (r ShortlinkRule) Matches(info visitor.Info) bool {
+	if r.Device != "" && r.Device != info.Device && !(r.Device == visitor.DeviceMobile && visitor.IsMobile(info.Device)) {
+		return false
+	}
+
+	if countries := splitList(r.Country); len(countries) > 0 && !containsFold(countries, info.Country) {
+		return false
+	}
+
+	if languages := splitList(r.Language); len(languages) > 0 {
+		if len(info.Languages) == 0 {
+			return false
+		}
+
+		// Only the most preferred language of the visitor is checked
+		matches := false
+		for _, language := range languages {
+			matches = matches || visitor.MatchesLanguage(info.Languages[0], language)
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	localTime := info.Time.UTC()
+	if location, err := time.LoadLocation(r.TimeZone); err == nil {
+		localTime = info.Time.In(location)
+	}
+
+	if days := splitList(r.Weekdays); len(days) > 0 {
+		matches := false
+		for _, day := range days {
+			matches = matches || weekdays[strings.ToLower(day)] == localTime.Weekday()
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	if r.HourFrom != r.HourTo {
+		hour := localTime.Hour()
+		if r.HourFrom < r.HourTo && (hour < r.HourFrom || hour >= r.HourTo) {
+			return false
+		}
+		if r.HourFrom > r.HourTo && hour < r.HourFrom && hour >= r.HourTo {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchRule : Returns the first rule matching the visitor or nil
+func (s *Shortlink) MatchRule(info visitor.Info) *ShortlinkRule {
+	for i := range s.Rules {
+		if s.Rules[i].Matches(info) {
+			return &s.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"shorts/database"
+	"shorts/hll"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ShortlinkSketch : HyperLogLog sketch of unique visitors of a short link during a day (UTC).
+// Only the sketch registers are stored, not the visitors' fingerprints
+type ShortlinkSketch struct {
+	ID        uint64    `json:"-" gorm:"primary_key"`
+	LinkID    uint64    `json:"-" gorm:"unique_index:idx_shortlink_sketches_link_day;not null"`
+	Day       time.Time `json:"day" gorm:"type:date;unique_index:idx_shortlink_sketches_link_day;not null"`
+	Registers []byte    `json:"-" gorm:"not null"`
+}
+
+// FullLinkSketch : Sketch of unique visitors of a full link during a day
+type FullLinkSketch struct {
+	FullLink  string
+	Registers []byte
+}
+
+// SketchDay : Returns day (UTC) of the sketch containing the time
+func SketchDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Sketch : Decodes the sketch
+func (s ShortlinkSketch) Sketch() (*hll.Sketch, error) {
+	var sketch hll.Sketch
+	if err := sketch.UnmarshalBinary(s.Registers); err != nil {
+		return nil, err
+	}
+
+	return &sketch, nil
+}
+
+// FlushInterval : How often counters buffered in memory are written to the database (see FlushVisitors and FlushAPICalls)
+const FlushInterval = 10 * time.Second
+
+// sketchKey : Short link and day of a sketch
+type sketchKey struct {
+	linkID uint64
+	day    time.Time
+}
+
+// pendingVisitors : Visitors added since the last flush, sketches of the same link and day are merged in memory
+var pendingVisitors = struct {
+	sync.Mutex
+	sketches map[sketchKey]*hll.Sketch
+}{sketches: make(map[sketchKey]*hll.Sketch)}
+
+// AddVisitor : Adds visitor's fingerprint to the sketch of the short link for the day of the use.
+// Visitors are buffered, so redirects do not lock and rewrite the stored sketch on every click
+func AddVisitor(linkID uint64, useTime time.Time, fingerprint uint64) error {
+	key := sketchKey{linkID: linkID, day: SketchDay(useTime)}
+
+	pendingVisitors.Lock()
+	defer pendingVisitors.Unlock()
+
+	sketch, ok := pendingVisitors.sketches[key]
+	if !ok {
+		var err error
+		if sketch, err = hll.New(hll.DefaultPrecision); err != nil {
+			return err
+		}
+		pendingVisitors.sketches[key] = sketch
+	}
+	sketch.Add(fingerprint)
+
+	return nil
+}
+
+// FlushVisitors : Merges buffered visitors into the stored sketches, each sketch is written once per flush.
+// Sketches that could not be written are kept for the next flush
+func FlushVisitors() error {
+	pendingVisitors.Lock()
+	sketches := pendingVisitors.sketches
+	pendingVisitors.sketches = make(map[sketchKey]*hll.Sketch)
+	pendingVisitors.Unlock()
+
+	var firstErr error
+	for key, sketch := range sketches {
+		err := mergeVisitors(key, sketch)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		pendingVisitors.Lock()
+		if pending, ok := pendingVisitors.sketches[key]; ok {
+			_ = sketch.Merge(pending)
+		}
+		pendingVisitors.sketches[key] = sketch
+		pendingVisitors.Unlock()
+	}
+
+	return firstErr
+}
+
+// mergeVisitors : Merges the sketch into the stored one
+func mergeVisitors(key sketchKey, sketch *hll.Sketch) (err error) {
+	// Concurrent first flushes of the day may both try to create the sketch, the second one retries as an update
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = mergeStoredSketch(key, sketch); !database.IsUniqueViolation(err) {
+			return
+		}
+	}
+
+	return
+}
+
+func mergeStoredSketch(key sketchKey, sketch *hll.Sketch) error {
+	tx := database.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var stored ShortlinkSketch
+	var merged *hll.Sketch
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where("link_id = ? AND day = ?", key.linkID, key.day).First(&stored).Error
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		// Visitors of links deleted since they were buffered are dropped
+		var count int
+		if err := tx.Model(&Shortlink{}).Where("id = ?", key.linkID).Count(&count).Error; err != nil || count == 0 {
+			tx.Rollback()
+			return err
+		}
+		stored = ShortlinkSketch{LinkID: key.linkID, Day: key.day}
+		merged, err = hll.New(hll.DefaultPrecision)
+	case err == nil:
+		merged, err = stored.Sketch()
+	}
+	if err == nil {
+		err = merged.Merge(sketch)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if stored.Registers, err = merged.MarshalBinary(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(&stored).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// sketchesRange : Scope of sketches of days between first and last (inclusive) days of the filter
+func (f UsesFilter) sketchesRange(db *gorm.DB) *gorm.DB {
+	if !f.From.IsZero() {
+		db = db.Where("shortlink_sketches.day >= ?", SketchDay(f.From))
+	}
+	if !f.To.IsZero() {
+		db = db.Where("shortlink_sketches.day <= ?", SketchDay(f.To))
+	}
+
+	return db
+}
+
+// LinkSketches : Returns daily sketches of the short link within days range of the filter, ordered by day
+func LinkSketches(linkID uint64, filter UsesFilter) ([]ShortlinkSketch, error) {
+	var sketches []ShortlinkSketch
+	if err := database.DB.Scopes(filter.sketchesRange).Where("link_id = ?", linkID).Order("day").Find(&sketches).Error; err != nil {
+		return nil, err
+	}
+
+	return sketches, nil
+}
+
+// FullLinkSketches : Returns daily sketches of all short links with their full links within days range of the filter
+func FullLinkSketches(filter UsesFilter) ([]FullLinkSketch, error) {
+	var sketches []FullLinkSketch
+	if dbc := database.DB.Table("shortlink_sketches").Scopes(filter.sketchesRange).Select("shortlinks.full as full_link, shortlink_sketches.registers").
+		Joins("join shortlinks on shortlinks.id = shortlink_sketches.link_id").Scan(&sketches); dbc.Error != nil {
+		return nil, dbc.Error
+	}
+
+	return sketches, nil
+}
+
+// MergeSketches : Returns sketch of unique visitors of all the sketches
+func MergeSketches(sketches []ShortlinkSketch) (*hll.Sketch, error) {
+	merged, err := hll.New(hll.DefaultPrecision)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stored := range sketches {
+		sketch, err := stored.Sketch()
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.Merge(sketch); err != nil {
+			return nil, err
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"net/url"
+
+	h "shorts/helper"
+)
+
+// Twitter card types
+const (
+	TwitterCardSummary      = "summary"
+	TwitterCardLargeSummary = "summary_large_image"
+)
+
+// ShortlinkSocialData : OpenGraph and Twitter card tags served to bots building link previews
+type ShortlinkSocialData struct {
+	SocialTitle       string `json:"socialTitle" binding:"max=200"`
+	SocialDescription string `json:"socialDescription" binding:"max=500"`
+	// Absolute http(s) link to the preview image
+	SocialImage string `json:"socialImage"`
+	// Twitter card type, "summary" (default) or "summary_large_image"
+	TwitterCard string `json:"twitterCard"`
+}
+
+// HasSocialTags : Checks if link preview is configured
+func (s ShortlinkSocialData) HasSocialTags() bool {
+	return s.SocialTitle != "" || s.SocialDescription != "" || s.SocialImage != ""
+}
+
+// Validate : Returns error if social tags can not be used
+func (s ShortlinkSocialData) Validate() error {
+	if s.SocialImage != "" {
+		if parsedURL, err := url.Parse(s.SocialImage); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			return h.NewInvalidSocialTagError("socialImage")
+		}
+	}
+	if s.TwitterCard != "" && s.TwitterCard != TwitterCardSummary && s.TwitterCard != TwitterCardLargeSummary {
+		return h.NewInvalidSocialTagError("twitterCard")
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"shorts/database"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 // ShortlinkUse structure
@@ -10,15 +12,110 @@ type ShortlinkUse struct {
 	ID      uint64    `json:"-" gorm:"primary_key"`
 	LinkID  uint64    `json:"-" gorm:"not null"`
 	UseTime time.Time `json:"time" gorm:"not null"`
+	// Rule that was used for the redirect, 0 if the full link was used
+	RuleID uint64 `json:"ruleId,omitempty" gorm:"not null;default:0"`
+	// Destination of A/B split that was served, 0 if there was no split
+	DestinationID uint64 `json:"destinationId,omitempty" gorm:"not null;default:0"`
+	// Where the visitor came from, for example "qr" for scanned QR codes
+	Source string `json:"source,omitempty" gorm:"not null;default:''"`
+	// Use by a crawler, link checker or health probe, excluded from stats by default
+	IsBot bool `json:"isBot" gorm:"not null;default:false"`
+}
+
+// UsesFilter : Which uses are counted in stats
+type UsesFilter struct {
+	IncludeBots bool
+	// First and last (inclusive) days in UTC, zero values mean unbounded range
+	From time.Time
+	To   time.Time
+}
+
+// scope : Scope excluding uses by bots unless they are requested and uses outside of the days range
+func (f UsesFilter) scope(db *gorm.DB) *gorm.DB {
+	if !f.IncludeBots {
+		db = db.Where("shortlink_uses.is_bot = ?", false)
+	}
+	if !f.From.IsZero() {
+		db = db.Where("shortlink_uses.use_time >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		db = db.Where("shortlink_uses.use_time < ?", f.To.AddDate(0, 0, 1))
+	}
+
+	return db
+}
+
+// DestinationUseCount : Uses count of a short link destination
+type DestinationUseCount struct {
+	DestinationID uint64
+	UsesCount     uint64
+}
+
+// DestinationUseCount : returns uses count of each destination of the short link
+func (shortlinkUse ShortlinkUse) DestinationUseCount(linkID uint64, filter UsesFilter) ([]DestinationUseCount, error) {
+	var usesCount []DestinationUseCount
+	if dbc := database.DB.Table("shortlink_uses").Scopes(filter.scope).Select("destination_id, count(1) as uses_count").Where("link_id = ?", linkID).
+		Group("destination_id").Scan(&usesCount); dbc.Error != nil {
+		return nil, dbc.Error
+	}
+
+	return usesCount, nil
+}
+
+// LinkUseCount : returns uses count of the short link
+func (shortlinkUse ShortlinkUse) LinkUseCount(linkID uint64, filter UsesFilter) (uint64, error) {
+	var usesCount uint64
+	if dbc := database.DB.Model(&ShortlinkUse{}).Scopes(filter.scope).Where("link_id = ?", linkID).Count(&usesCount); dbc.Error != nil {
+		return 0, dbc.Error
+	}
+
+	return usesCount, nil
+}
+
+// SourceUseCount : returns uses count of the short link that came from the source
+func (shortlinkUse ShortlinkUse) SourceUseCount(linkID uint64, source string, filter UsesFilter) (uint64, error) {
+	var usesCount uint64
+	if dbc := database.DB.Model(&ShortlinkUse{}).Scopes(filter.scope).Where("link_id = ? AND source = ?", linkID, source).Count(&usesCount); dbc.Error != nil {
+		return 0, dbc.Error
+	}
+
+	return usesCount, nil
+}
+
+// DayUseCount : Uses count of a short link during a day
+type DayUseCount struct {
+	Day       time.Time
+	UsesCount uint64
+}
+
+// DayUseCount : returns uses count of the short link for each day (UTC) with uses
+func (shortlinkUse ShortlinkUse) DayUseCount(linkID uint64, filter UsesFilter) ([]DayUseCount, error) {
+	var usesCount []DayUseCount
+	if dbc := database.DB.Table("shortlink_uses").Scopes(filter.scope).Select("date(use_time at time zone 'UTC') as day, count(1) as uses_count").Where("link_id = ?", linkID).
+		Group("day").Order("day").Scan(&usesCount); dbc.Error != nil {
+		return nil, dbc.Error
+	}
+
+	return usesCount, nil
 }
 
 // UseCount : returns uses count of each full lunk
-func (shortlinkUse ShortlinkUse) UseCount() ([]FullLinkUseCountResponse, error) {
+func (shortlinkUse ShortlinkUse) UseCount(filter UsesFilter) ([]FullLinkUseCountResponse, error) {
 	var linksUses []FullLinkUseCountResponse
-	if dbc := database.DB.Table("shortlink_uses").Select("shortlinks.full as full_link, count(1) as uses_count").Group("full_link").
+	if dbc := database.DB.Table("shortlink_uses").Scopes(filter.scope).Select("shortlinks.full as full_link, count(1) as uses_count").Group("full_link").
 		Joins("left join shortlinks on shortlinks.id = shortlink_uses.link_id").Scan(&linksUses); dbc.Error != nil {
 		return nil, dbc.Error
 	}
 
 	return linksUses, nil
 }
+
+// Uses : returns all uses of short links
+func (shortlinkUse ShortlinkUse) Uses(filter UsesFilter) ([]ShortlinkUse, error) {
+	var uses []ShortlinkUse
+	if dbc := database.DB.Scopes(filter.scope).Find(&uses); dbc.Error != nil {
+		return nil, dbc.Error
+	}
+
+	return uses, nil
+}
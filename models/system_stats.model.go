@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"shorts/database"
+)
+
+// SystemStatsResponseData : Service-wide counters for administrators
+type SystemStatsResponseData struct {
+	UsersCount          int `json:"usersCount"`
+	SuspendedUsersCount int `json:"suspendedUsersCount"`
+	AdminsCount         int `json:"adminsCount"`
+	WorkspacesCount     int `json:"workspacesCount"`
+	LinksCount          int `json:"linksCount"`
+	DisabledLinksCount  int `json:"disabledLinksCount"`
+	DomainsCount        int `json:"domainsCount"`
+	// Uses by humans, all time and since the start of the day (UTC)
+	UsesCount      int `json:"usesCount"`
+	UsesTodayCount int `json:"usesTodayCount"`
+	BotUsesCount   int `json:"botUsesCount"`
+	BlocklistCount int `json:"blocklistCount"`
+	WebhooksCount  int `json:"webhooksCount"`
+	// Webhook deliveries waiting for the next attempt and given up
+	PendingDeliveriesCount int `json:"pendingDeliveriesCount"`
+	FailedDeliveriesCount  int `json:"failedDeliveriesCount"`
+}
+
+// SystemStats : Counts entities of the whole service
+func SystemStats() (SystemStatsResponseData, error) {
+	var stats SystemStatsResponseData
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	counters := []struct {
+		model     interface{}
+		condition string
+		values    []interface{}
+		result    *int
+	}{
+		{&User{}, "", nil, &stats.UsersCount},
+		{&User{}, "suspended = ?", []interface{}{true}, &stats.SuspendedUsersCount},
+		{&User{}, "role = ?", []interface{}{UserRoleAdmin}, &stats.AdminsCount},
+		{&Workspace{}, "", nil, &stats.WorkspacesCount},
+		{&Shortlink{}, "", nil, &stats.LinksCount},
+		{&Shortlink{}, "disabled = ?", []interface{}{true}, &stats.DisabledLinksCount},
+		{&Domain{}, "", nil, &stats.DomainsCount},
+		{&ShortlinkUse{}, "is_bot = ?", []interface{}{false}, &stats.UsesCount},
+		{&ShortlinkUse{}, "is_bot = ? AND use_time >= ?", []interface{}{false, today}, &stats.UsesTodayCount},
+		{&ShortlinkUse{}, "is_bot = ?", []interface{}{true}, &stats.BotUsesCount},
+		{&BlocklistEntry{}, "", nil, &stats.BlocklistCount},
+		{&Webhook{}, "", nil, &stats.WebhooksCount},
+		{&WebhookDelivery{}, "status = ?", []interface{}{DeliveryPending}, &stats.PendingDeliveriesCount},
+		{&WebhookDelivery{}, "status = ?", []interface{}{DeliveryFailed}, &stats.FailedDeliveriesCount},
+	}
+
+	for _, counter := range counters {
+		query := database.DB.Model(counter.model)
+		if counter.condition != "" {
+			query = query.Where(counter.condition, counter.values...)
+		}
+		if err := query.Count(counter.result).Error; err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"shorts/database"
+	"shorts/totp"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RecoveryCodesCount : Recovery codes generated when two-factor authentication is enabled
+const RecoveryCodesCount = 10
+
+// APITokenPrefix : Prefix of API tokens making them easy to recognize in logs and secret scanners
+const APITokenPrefix = "shorts_"
+
+// RecoveryCode : Single-use code replacing TOTP code if authenticator is lost. Only hash of the code is stored
+type RecoveryCode struct {
+	ID       uint64 `gorm:"primary_key"`
+	UserID   uint64 `gorm:"index;not null"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// APIToken : Bearer token issued by POST /v1/login. Only hash of the token is stored
+type APIToken struct {
+	ID         uint64     `json:"id" gorm:"primary_key"`
+	UserID     uint64     `json:"-" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null;default:''"`
+	TokenHash  string     `json:"-" gorm:"unique_index;not null"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// LoginData structure
+// swagger:parameters login
+type LoginData struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// TOTP code, required if two-factor authentication is enabled
+	Code string `json:"code"`
+	// Recovery code used instead of TOTP code
+	RecoveryCode string `json:"recoveryCode"`
+	// Name of the token shown in the tokens list
+	TokenName string `json:"tokenName" binding:"max=100"`
+	// Token expires after this many days, never if it is 0
+	ExpiresInDays int `json:"expiresInDays" binding:"min=0,max=3650"`
+}
+
+// LoginResponseData : Issued API token, it is shown only once
+type LoginResponseData struct {
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// PasswordData : Confirmation of sensitive action with password
+// swagger:parameters enableTwoFactor
+type PasswordData struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// TwoFactorCodeData structure
+// swagger:parameters confirmTwoFactor regenerateRecoveryCodes
+type TwoFactorCodeData struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorData structure
+// swagger:parameters disableTwoFactor
+type DisableTwoFactorData struct {
+	Password string `json:"password" binding:"required"`
+	// TOTP or recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorSetupResponseData : Pending TOTP secret to add to authenticator app
+type TwoFactorSetupResponseData struct {
+	Secret string `json:"secret"`
+	// otpauth:// URI and the same URI as QR code
+	URI    string `json:"uri"`
+	QRCode string `json:"qrCode"`
+}
+
+// RecoveryCodesResponseData : New recovery codes, they are shown only once
+type RecoveryCodesResponseData struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// randomHex : Returns hex encoded random bytes
+func randomHex(size int) (string, error) {
+	random := make([]byte, size)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+// CheckTOTP : Checks the code of the user and remembers its step, so the same code is not accepted again
+func CheckTOTP(db *gorm.DB, user *User, code string) (bool, error) {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	// Concurrent requests with the same code can not both advance the step
+	dbc := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	if dbc.Error != nil {
+		return false, dbc.Error
+	}
+	user.TOTPLastStep = step
+
+	return dbc.RowsAffected == 1, nil
+}
+
+// GenerateRecoveryCodes : Replaces recovery codes of the user with new ones
+func GenerateRecoveryCodes(db *gorm.DB, userID uint64) ([]string, error) {
+	if err := db.Where(&RecoveryCode{UserID: userID}).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodesCount)
+	for i := 0; i < RecoveryCodesCount; i++ {
+		random, err := randomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		code := random[:5] + "-" + random[5:]
+
+		if err := db.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode : Marks unused recovery code of the user as used, returns false if there is no such code
+func UseRecoveryCode(db *gorm.DB, userID uint64, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	dbc := db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(code)).Update("used_at", time.Now())
+	return dbc.RowsAffected == 1, dbc.Error
+}
+
+// apiTokenUseInterval : Last use of API token is recorded at most once per interval, so requests do not write to the database each time
+const apiTokenUseInterval = time.Minute
+
+// CreateAPIToken : Issues new API token of the user, expiring after ttl if it is not 0
+func CreateAPIToken(db *gorm.DB, userID uint64, name string, ttl time.Duration) (string, *APIToken, error) {
+	random, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + random
+
+	apiToken := APIToken{UserID: userID, Name: name, TokenHash: hashToken(token)}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	if err := db.Create(&apiToken).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &apiToken, nil
+}
+
+// FindAPITokenUser : Returns user of valid API token and records its use (see apiTokenUseInterval)
+func FindAPITokenUser(token string) (*User, error) {
+	var apiToken APIToken
+	var user User
+
+	if err := database.DB.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", hashToken(token), time.Now()).First(&apiToken).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.First(&user, apiToken.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) >= apiTokenUseInterval {
+		database.DB.Model(&apiToken).UpdateColumn("last_used_at", time.Now())
+	}
+
+	return &user, nil
+}
+
+// RevokeAPITokens : Deletes all API tokens of the user, used when the password or the second factor changes
+func RevokeAPITokens(db *gorm.DB, userID uint64) error {
+	return db.Where(&APIToken{UserID: userID}).Delete(&APIToken{}).Error
+}
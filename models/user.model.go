@@ -1,15 +1,265 @@
 package models
 
+import (
+	"strings"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of users
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
 // User structure
 type User struct {
-	ID       uint64 `json:"id" gorm:"primary_key"`
-	Name     string `json:"name" gorm:"unique;not null" binding:"required,min=5"`
-	Password string `json:"password" gorm:"not null" binding:"required,min=5,max=16"`
+	ID   uint64 `json:"id" gorm:"primary_key"`
+	Name string `json:"name" gorm:"unique;not null" binding:"required,min=5"`
+	// bcrypt hash of the password, see HashPassword
+	Password string `json:"-" gorm:"not null"`
+	// Administrators can use /v1/admin routes
+	Role string `json:"role" gorm:"not null;default:'user'"`
+	// Suspended users can not sign in
+	Suspended bool `json:"suspended" gorm:"not null;default:false"`
+	// Optional contact address, used for password reset. Only verified addresses are set, so nobody can hold another one's address
+	Email         *string `json:"email" gorm:"unique_index"`
+	EmailVerified bool    `json:"emailVerified" gorm:"not null;default:false"`
+	// Address waiting for verification, it replaces Email once verified. Several users may wait for the same one
+	PendingEmail *string `json:"pendingEmail" gorm:"index"`
+	// TOTP secret, pending until the first code confirms it
+	TOTPSecret  string `json:"-" gorm:"column:totp_secret;not null;default:''"`
+	TOTPEnabled bool   `json:"totpEnabled" gorm:"column:totp_enabled;not null;default:false"`
+	// Time step of the last accepted code, codes can not be used twice
+	TOTPLastStep int64 `json:"-" gorm:"column:totp_last_step;not null;default:0"`
+	// Plan limiting the user, DEFAULT_PLAN is used if it is not set
+	PlanID    uint64    `json:"planId" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // AddUserData structure
 // swagger:parameters addUser
 type AddUserData struct {
 	Name     string `json:"name" gorm:"unique;not null" binding:"required,min=5"`
-	Password string `json:"password" gorm:"not null" binding:"required,min=5,max=16"`
+	Password string `json:"password" gorm:"not null" binding:"required,min=5,max=72"`
+	// Required if REQUIRE_EMAIL_VERIFICATION is enabled
+	Email string `json:"email" binding:"omitempty,email,max=254"`
+}
+
+// HashPassword : Returns bcrypt hash of the password, bcrypt uses only its first 72 bytes
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword : Checks if the password matches the hash stored for the user
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+// HashStoredPasswords : Replaces passwords stored in plain text before hashing was introduced, returns number of updated users
+func HashStoredPasswords(db *gorm.DB) (int64, error) {
+	var users []User
+	// bcrypt hashes have 60 characters and start with $2a$, $2b$ or $2y$
+	if err := db.Select("id, password").Where("NOT (password LIKE ? AND length(password) = 60)", "$2_$%").Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	var updated int64
+	for _, user := range users {
+		hash, err := HashPassword(user.Password)
+		if err != nil {
+			return updated, err
+		}
+		// The condition keeps passwords changed meanwhile by another instance
+		dbc := db.Model(&User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hash)
+		if dbc.Error != nil {
+			return updated, dbc.Error
+		}
+		updated += dbc.RowsAffected
+	}
+
+	return updated, nil
+}
+
+// SignInError : Returns error if the user is not allowed to sign in (suspended or with unverified email)
+func (u *User) SignInError() error {
+	if u.Suspended {
+		return h.NewUserSuspendedError()
+	}
+	// Accounts registered before verification was required have no email and are still allowed
+	if h.EmailVerificationRequired() && !u.EmailVerified && (u.Email != nil || u.PendingEmail != nil) {
+		return h.NewEmailNotVerifiedError()
+	}
+
+	return nil
+}
+
+// NormalizeEmail : Returns trimmed email in lower case, emails are unique regardless of case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailTaken : Checks if another user has the email verified
+func EmailTaken(db *gorm.DB, email string, userID uint64) (bool, error) {
+	var count int
+	if err := db.Model(&User{}).Where("email = ? AND id <> ?", email, userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// ConfirmPendingEmail : Replaces email of the user with the pending one if it is still the email. Returns NewInvalidTokenError
+// if the user waits for another email and NewEmailTakenError if another user verified it first
+func ConfirmPendingEmail(db *gorm.DB, user *User, email string) error {
+	dbc := db.Model(user).Where("pending_email = ?", email).Updates(map[string]interface{}{"email": email, "email_verified": true, "pending_email": nil})
+	if dbc.Error != nil {
+		if database.IsUniqueViolation(dbc.Error) {
+			return h.NewEmailTakenError()
+		}
+		return dbc.Error
+	}
+	if dbc.RowsAffected == 0 {
+		return h.NewInvalidTokenError()
+	}
+
+	return nil
+}
+
+// MigratePendingEmails : Moves unverified emails stored by older versions to pending ones, so they do not block other users
+func MigratePendingEmails(db *gorm.DB) error {
+	return db.Exec("UPDATE users SET pending_email = email, email = NULL WHERE email IS NOT NULL AND NOT email_verified").Error
+}
+
+// AdminUpdateUserData : Changes of a user made by administrator, only set fields are changed
+// swagger:parameters adminUpdateUser
+type AdminUpdateUserData struct {
+	Role      *string `json:"role" binding:"omitempty,oneof=user admin"`
+	Suspended *bool   `json:"suspended"`
+	// Plan of the user, 0 means DEFAULT_PLAN
+	PlanID *uint64 `json:"planId"`
+}
+
+// Changes : Returns map of fields to update
+func (d *AdminUpdateUserData) Changes() map[string]interface{} {
+	changes := make(map[string]interface{})
+	if d.Role != nil {
+		changes["role"] = *d.Role
+	}
+	if d.Suspended != nil {
+		changes["suspended"] = *d.Suspended
+	}
+	if d.PlanID != nil {
+		changes["plan_id"] = *d.PlanID
+	}
+
+	return changes
+}
+
+// AdminUserResponseData : User as seen by administrators
+type AdminUserResponseData struct {
+	ID         uint64    `json:"id"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role"`
+	Suspended  bool      `json:"suspended"`
+	PlanID     uint64    `json:"planId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LinksCount int       `json:"linksCount"`
+	// Recent failed sign-in attempts, signing in is refused until LockedUntil
+	FailedLogins int        `json:"failedLogins"`
+	LockedUntil  *time.Time `json:"lockedUntil"`
+}
+
+// PromoteAdmins : Gives admin role to users with the names in comma separated list (ADMIN_USERS), returns number of promoted users.
+// It runs once at startup, afterwards only the role decides, so the list can not grant access to users registered under the names later
+func PromoteAdmins(db *gorm.DB, names string) (int64, error) {
+	list := make([]string, 0)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			list = append(list, name)
+		}
+	}
+	if len(list) == 0 {
+		return 0, nil
+	}
+
+	dbc := db.Model(&User{}).Where("name IN (?) AND role <> ?", list, UserRoleAdmin).Update("role", UserRoleAdmin)
+	return dbc.RowsAffected, dbc.Error
+}
+
+// SearchUsers : Returns users with names containing the query (all if it is empty) ordered by ID
+func SearchUsers(query string, limit, offset int) ([]AdminUserResponseData, error) {
+	users := make([]AdminUserResponseData, 0)
+
+	db := database.DB.Table("users").Select("users.id, users.name, users.role, users.suspended, users.plan_id, users.created_at, count(shortlinks.id) as links_count, " +
+		"coalesce(max(login_throttles.failures), 0) as failed_logins, max(login_throttles.locked_until) as locked_until").
+		Joins("left join shortlinks on shortlinks.owner_id = users.id").
+		Joins("left join login_throttles on login_throttles.throttle_key = 'name:' || lower(users.name)").Group("users.id").Order("users.id").Limit(limit).Offset(offset)
+	if query != "" {
+		db = db.Where("users.name ILIKE ?", "%"+query+"%")
+	}
+
+	if err := db.Scan(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// DeleteUser : Deletes the user with links (including their uses, rules and destinations), domains, webhooks and memberships.
+// Workspaces left without members are deleted, in shared workspaces left without owners the oldest member becomes the owner
+func DeleteUser(db *gorm.DB, userID uint64) error {
+	ownLinks := db.Table("shortlinks").Select("id").Where("owner_id = ?", userID).SubQuery()
+	ownWebhooks := db.Table("webhooks").Select("id").Where("owner_id = ?", userID).SubQuery()
+
+	deletions := []struct {
+		model     interface{}
+		condition string
+		values    []interface{}
+	}{
+		{&ShortlinkUse{}, "link_id IN (?)", []interface{}{ownLinks}},
+		{&ShortlinkRule{}, "link_id IN (?)", []interface{}{ownLinks}},
+		{&ShortlinkDestination{}, "link_id IN (?)", []interface{}{ownLinks}},
+		{&ShortlinkSketch{}, "link_id IN (?)", []interface{}{ownLinks}},
+		{&WebhookThresholdHit{}, "link_id IN (?) OR webhook_id IN (?)", []interface{}{ownLinks, ownWebhooks}},
+		{&Shortlink{}, "owner_id = ?", []interface{}{userID}},
+		{&WebhookDelivery{}, "webhook_id IN (?)", []interface{}{ownWebhooks}},
+		{&Webhook{}, "owner_id = ?", []interface{}{userID}},
+		{&Domain{}, "owner_id = ?", []interface{}{userID}},
+		{&WorkspaceMember{}, "user_id = ?", []interface{}{userID}},
+		{&UserToken{}, "user_id = ?", []interface{}{userID}},
+		{&RecoveryCode{}, "user_id = ?", []interface{}{userID}},
+		{&APIToken{}, "user_id = ?", []interface{}{userID}},
+		{&UserIdentity{}, "user_id = ?", []interface{}{userID}},
+		{&APIUsage{}, "user_id = ?", []interface{}{userID}},
+		{&LoginThrottle{}, "throttle_key IN (?)", []interface{}{db.Table("users").Select("'name:' || lower(name)").Where("id = ?", userID).SubQuery()}},
+		{&OIDCLogin{}, "link_user_id = ?", []interface{}{userID}},
+	}
+	for _, deletion := range deletions {
+		if err := db.Where(deletion.condition, deletion.values...).Delete(deletion.model).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Exec(`UPDATE workspace_members SET role = ? WHERE id IN (SELECT min(id) FROM workspace_members
+		WHERE workspace_id NOT IN (SELECT workspace_id FROM workspace_members WHERE role = ?) GROUP BY workspace_id)`, RoleOwner, RoleOwner).Error; err != nil {
+		return err
+	}
+
+	// Links of other users in workspaces nobody can access anymore are removed with the workspaces
+	emptyWorkspaces := db.Table("workspaces").Select("id").Where("id NOT IN (?)", db.Table("workspace_members").Select("workspace_id").SubQuery()).SubQuery()
+	if err := db.Where("workspace_id IN (?)", emptyWorkspaces).Delete(&Shortlink{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("id NOT IN (?)", db.Table("workspace_members").Select("workspace_id").SubQuery()).Delete(&Workspace{}).Error; err != nil {
+		return err
+	}
+
+	return db.Delete(&User{}, userID).Error
 }
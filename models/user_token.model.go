@@ -0,0 +1,83 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Purposes of user tokens
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken : Single-use expiring token sent to user by email. Only hash of the token is stored
+type UserToken struct {
+	ID        uint64 `gorm:"primary_key"`
+	UserID    uint64 `gorm:"index;not null"`
+	Purpose   string `gorm:"not null"`
+	TokenHash string `gorm:"unique_index;not null"`
+	// Email the token was sent to, verification is valid only while the user has it
+	Email     string `gorm:"not null;default:''"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// hashToken : Returns hex encoded SHA-256 of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUserToken : Returns new token of the user for the purpose, unused tokens issued before it are revoked
+func CreateUserToken(db *gorm.DB, userID uint64, purpose, email string, ttl time.Duration) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+
+	now := time.Now()
+	if err := db.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Update("used_at", now).Error; err != nil {
+		return "", err
+	}
+
+	if err := db.Create(&UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		Email:     email,
+		ExpiresAt: now.Add(ttl),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeUserToken : Marks the token as used and returns it, nil if it does not exist, expired or was already used
+func ConsumeUserToken(db *gorm.DB, token, purpose string) (*UserToken, error) {
+	var userToken UserToken
+	now := time.Now()
+
+	// Only one request can mark the token as used
+	dbc := db.Model(&UserToken{}).Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, now).
+		Update("used_at", now)
+	if dbc.Error != nil {
+		return nil, dbc.Error
+	}
+	if dbc.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	if err := db.Where(&UserToken{TokenHash: hashToken(token)}).First(&userToken).Error; err != nil {
+		return nil, err
+	}
+
+	return &userToken, nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"shorts/database"
+)
+
+// Webhook events
+const (
+	EventLinkCreated         = "link.created"
+	EventLinkUpdated         = "link.updated"
+	EventLinkDeleted         = "link.deleted"
+	EventLinkExpired         = "link.expired"
+	EventLinkClicksThreshold = "link.clicks_threshold"
+	// Sent by the test endpoint only
+	EventPing = "ping"
+)
+
+// WebhookEvents : Events webhooks can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkExpired, EventLinkClicksThreshold}
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook : Endpoint of a user notified about events of the user's links
+type Webhook struct {
+	ID      uint64 `json:"id" gorm:"primary_key"`
+	OwnerID uint64 `json:"ownerId" gorm:"not null"`
+	URL     string `json:"url" gorm:"not null"`
+	// Key of HMAC-SHA256 signatures of payloads, returned only when the webhook is created
+	Secret string `json:"-" gorm:"not null"`
+	// Comma separated list of events, all events are sent if it is empty
+	Events string `json:"events" gorm:"not null;default:''"`
+	// Comma separated list of uses counts of a link that trigger "link.clicks_threshold" event
+	ClickThresholds string    `json:"clickThresholds" gorm:"not null;default:''"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// AddWebhookData structure
+// swagger:parameters addWebhook
+type AddWebhookData struct {
+	URL    string   `json:"url" binding:"required,url"`
+	Events []string `json:"events" binding:"dive,oneof=link.created link.updated link.deleted link.expired link.clicks_threshold"`
+	// Generated if it is not set
+	Secret          string   `json:"secret" binding:"omitempty,min=16,max=128"`
+	ClickThresholds []uint64 `json:"clickThresholds" binding:"dive,min=1"`
+}
+
+// WebhookCreatedResponseData : Created webhook with its secret, the secret is not returned anymore later
+type WebhookCreatedResponseData struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// Subscribed : Checks if the webhook is notified about the event
+func (w Webhook) Subscribed(event string) bool {
+	if event == EventPing || w.Events == "" {
+		return true
+	}
+
+	return containsFold(splitList(w.Events), event)
+}
+
+// Thresholds : Returns uses counts that trigger "link.clicks_threshold" event
+func (w Webhook) Thresholds() []uint64 {
+	var thresholds []uint64
+	for _, value := range splitList(w.ClickThresholds) {
+		if threshold, err := strconv.ParseUint(value, 10, 64); err == nil {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+
+	return thresholds
+}
+
+// JoinThresholds : Returns thresholds as comma separated list
+func JoinThresholds(thresholds []uint64) string {
+	values := make([]string, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		values = append(values, strconv.FormatUint(threshold, 10))
+	}
+
+	return strings.Join(values, ",")
+}
+
+// WebhookDelivery : Payload queued for delivery to a webhook with the log of delivery attempts
+type WebhookDelivery struct {
+	ID        uint64 `json:"id" gorm:"primary_key"`
+	WebhookID uint64 `json:"webhookId" gorm:"index;not null"`
+	Event     string `json:"event" gorm:"not null"`
+	Payload   string `json:"payload" gorm:"type:text;not null"`
+	Status    string `json:"status" gorm:"index;not null"`
+	Attempts  int    `json:"attempts" gorm:"not null;default:0"`
+	// When the next attempt is made if the delivery is pending
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"index;not null"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt"`
+	// HTTP status of the last response, 0 if the request failed
+	ResponseStatus int       `json:"responseStatus" gorm:"not null;default:0"`
+	Error          string    `json:"error" gorm:"type:text;not null;default:''"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// WebhookThresholdHit : Click threshold of a link that was already reported to a webhook
+type WebhookThresholdHit struct {
+	ID        uint64 `gorm:"primary_key"`
+	WebhookID uint64 `gorm:"unique_index:idx_webhook_threshold_hits;not null"`
+	LinkID    uint64 `gorm:"unique_index:idx_webhook_threshold_hits;not null"`
+	Threshold uint64 `gorm:"unique_index:idx_webhook_threshold_hits;not null"`
+}
+
+// FindUserWebhooks : Returns webhooks of the user subscribed to the event
+func FindUserWebhooks(userID uint64, event string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := database.DB.Where(&Webhook{OwnerID: userID}).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	subscribed := webhooks[:0]
+	for _, webhook := range webhooks {
+		if webhook.Subscribed(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+
+	return subscribed, nil
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"time"
+
+	"shorts/database"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Roles of workspace members
+const (
+	// Manages members and the workspace itself
+	RoleOwner = "owner"
+	// Creates, updates and deletes links
+	RoleEditor = "editor"
+	// Reads links and their stats
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// RoleAllows : Checks if member with the role can perform actions of the required role
+func RoleAllows(role, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+// Workspace : Team sharing short links. Every user has a personal workspace only the user is member of
+type Workspace struct {
+	ID       uint64 `json:"id" gorm:"primary_key"`
+	Name     string `json:"name" gorm:"not null"`
+	Personal bool   `json:"personal" gorm:"not null;default:false"`
+	// Plan covering links of the workspace instead of plans of their creators
+	PlanID    uint64    `json:"planId" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WorkspaceMember : Membership of a user in a workspace
+type WorkspaceMember struct {
+	ID          uint64 `json:"-" gorm:"primary_key"`
+	WorkspaceID uint64 `json:"workspaceId" gorm:"unique_index:idx_workspace_members_workspace_user;not null"`
+	UserID      uint64 `json:"userId" gorm:"unique_index:idx_workspace_members_workspace_user;not null"`
+	Role        string `json:"role" gorm:"not null"`
+	// Membership was granted by single sign-on group mapping (OIDC_GROUP_ROLES) and follows the user's groups
+	Managed bool `json:"managed" gorm:"not null;default:false"`
+}
+
+// AddWorkspaceData structure
+// swagger:parameters addWorkspace
+type AddWorkspaceData struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// SetWorkspaceMemberData structure
+// swagger:parameters setWorkspaceMember
+type SetWorkspaceMemberData struct {
+	// Name of the user
+	Name string `json:"name" binding:"required"`
+	Role string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+// WorkspaceResponseData : Workspace with the role of current user
+type WorkspaceResponseData struct {
+	ID       uint64 `json:"id"`
+	Name     string `json:"name"`
+	Personal bool   `json:"personal"`
+	Role     string `json:"role"`
+}
+
+// WorkspaceMemberResponseData : Member of a workspace
+type WorkspaceMemberResponseData struct {
+	UserID uint64 `json:"userId"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+}
+
+// CreateWorkspace : Creates workspace with the user as its owner
+func CreateWorkspace(db *gorm.DB, name string, ownerID uint64, personal bool) (*Workspace, error) {
+	workspace := Workspace{Name: name, Personal: personal}
+	if err := db.Create(&workspace).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Create(&WorkspaceMember{WorkspaceID: workspace.ID, UserID: ownerID, Role: RoleOwner}).Error; err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// FindPersonalWorkspace : Returns personal workspace of the user
+func FindPersonalWorkspace(db *gorm.DB, userID uint64) (*Workspace, error) {
+	var workspace Workspace
+	if err := db.Joins("join workspace_members on workspace_members.workspace_id = workspaces.id").
+		Where("workspaces.personal = ? AND workspace_members.user_id = ?", true, userID).First(&workspace).Error; err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// MemberRole : Returns role of the user in the workspace, empty if the user is not a member
+func MemberRole(workspaceID, userID uint64) (string, error) {
+	var member WorkspaceMember
+	if err := database.DB.Where(&WorkspaceMember{WorkspaceID: workspaceID, UserID: userID}).First(&member).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return member.Role, nil
+}
+
+// UserWorkspaces : Returns workspaces the user is member of with the user's roles
+func UserWorkspaces(userID uint64) ([]WorkspaceResponseData, error) {
+	workspaces := make([]WorkspaceResponseData, 0)
+	if dbc := database.DB.Table("workspaces").Select("workspaces.id, workspaces.name, workspaces.personal, workspace_members.role").
+		Joins("join workspace_members on workspace_members.workspace_id = workspaces.id").Where("workspace_members.user_id = ?", userID).
+		Order("workspaces.id").Scan(&workspaces); dbc.Error != nil {
+		return nil, dbc.Error
+	}
+
+	return workspaces, nil
+}
+
+// MigratePersonalWorkspaces : Creates personal workspaces of users that do not have them
+// and moves links without workspace to personal workspaces of their owners
+func MigratePersonalWorkspaces(db *gorm.DB) error {
+	var users []User
+	if err := db.Where("id NOT IN (?)", db.Table("workspace_members").Select("workspace_members.user_id").
+		Joins("join workspaces on workspaces.id = workspace_members.workspace_id").Where("workspaces.personal = ?", true).SubQuery()).
+		Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		tx := db.Begin()
+		if _, err := CreateWorkspace(tx, user.Name, user.ID, true); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(`UPDATE shortlinks SET workspace_id = workspaces.id FROM workspaces, workspace_members
+		WHERE shortlinks.workspace_id = 0 AND workspaces.personal AND workspace_members.workspace_id = workspaces.id
+		AND workspace_members.user_id = shortlinks.owner_id`).Error
+}
@@ -0,0 +1,196 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	h "shorts/helper"
+)
+
+// httpTimeout : Timeout of requests to identity provider
+const httpTimeout = 10 * time.Second
+
+var client = &http.Client{Timeout: httpTimeout}
+
+// Metadata : Part of OpenID Provider configuration (/.well-known/openid-configuration) used by the flow
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider : OpenID Connect identity provider with registered client of the service
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Claim of ID token with list of groups of the user
+	GroupsClaim string
+
+	mutex    sync.Mutex
+	metadata *Metadata
+	keys     *keySet
+}
+
+// FromEnv : Returns provider configured with OIDC_* variables or nil if OIDC_ISSUER is not set
+func FromEnv() *Provider {
+	issuer := h.GetEnv("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil
+	}
+
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     h.GetEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret: h.GetEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  h.GetEnv("OIDC_REDIRECT_URL", h.GetEnv("BASE_URL", "")+"/v1/oidc/callback"),
+		Scopes:       strings.Fields(h.GetEnv("OIDC_SCOPES", "openid profile email")),
+		GroupsClaim:  h.GetEnv("OIDC_GROUPS_CLAIM", "groups"),
+	}
+}
+
+// Current : Configured identity provider, single sign-on is disabled if it is nil
+var Current *Provider
+
+// Metadata : Returns provider configuration, it is discovered on the first call
+func (p *Provider) Metadata() (*Metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata Metadata
+	if err := getJSON(p.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("OIDC issuer mismatch: %s", metadata.Issuer)
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthURL : Returns URL of the provider's login page for authorization code flow with PKCE (S256)
+func (p *Provider) AuthURL(state, nonce, verifier string) (string, error) {
+	metadata, err := p.Metadata()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// tokenResponse : Response of token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange : Exchanges authorization code for ID token and returns its verified claims
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+	metadata, err := p.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.ClientID)
+
+	request, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("OIDC token request failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	return p.Verify(tokens.IDToken, nonce, time.Now())
+}
+
+// NewRandom : Returns random URL-safe string for state, nonce and PKCE verifier
+func NewRandom() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Challenge : Returns S256 PKCE challenge of the verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON : Fetches JSON document
+func getJSON(url string, result interface{}) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("OIDC request failed: " + response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
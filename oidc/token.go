@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway : Allowed clock difference with identity provider
+const leeway = time.Minute
+
+// Claims : Verified claims of ID token
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	// Filled from the configured groups claim
+	Groups []string `json:"-"`
+}
+
+// audience : "aud" claim is a string or a list of strings
+type audience []string
+
+// UnmarshalJSON : Accepts both forms of audience
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// jwk : RSA key of provider's JWKS
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// keySet : Signing keys of the provider by key ID
+type keySet struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// errInvalidToken : ID token is malformed or its signature or claims are wrong
+var errInvalidToken = errors.New("Invalid ID token")
+
+// fetchKeys : Loads signing keys from jwks_uri
+func (p *Provider) fetchKeys() (*keySet, error) {
+	metadata, err := p.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(metadata.JWKSURI, &document); err != nil {
+		return nil, err
+	}
+
+	set := keySet{keys: make(map[string]*rsa.PublicKey), fetched: time.Now()}
+	for _, key := range document.Keys {
+		if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			continue
+		}
+
+		set.keys[key.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return &set, nil
+}
+
+// key : Returns signing key with the ID, keys are fetched again if it is unknown (rotation)
+func (p *Provider) key(keyID string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	keys := p.keys
+	p.mutex.Unlock()
+
+	if keys != nil {
+		if key, exists := keys.keys[keyID]; exists {
+			return key, nil
+		}
+		// Unknown keys are not refetched more often than once a minute
+		if time.Since(keys.fetched) < time.Minute {
+			return nil, errInvalidToken
+		}
+	}
+
+	keys, err := p.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	if key, exists := keys.keys[keyID]; exists {
+		return key, nil
+	}
+	// Providers with a single key may omit key IDs
+	if keyID == "" && len(keys.keys) == 1 {
+		for _, key := range keys.keys {
+			return key, nil
+		}
+	}
+
+	return nil, errInvalidToken
+}
+
+// Verify : Checks RS256 signature, issuer, audience, expiry and nonce of ID token and returns its claims
+func (p *Provider) Verify(rawToken, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != "RS256" {
+		return nil, errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	key, err := p.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.Issuer || !claims.Audience.contains(p.ClientID) || claims.Subject == "" {
+		return nil, errInvalidToken
+	}
+	if now.After(time.Unix(claims.Expiry, 0).Add(leeway)) || now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, errInvalidToken
+	}
+	if claims.Nonce != nonce {
+		return nil, errInvalidToken
+	}
+
+	// Groups claim name is configurable, providers use "groups", "roles" and others
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err == nil {
+		if groups, ok := raw[p.GroupsClaim].([]interface{}); ok {
+			for _, group := range groups {
+				if name, ok := group.(string); ok {
+					claims.Groups = append(claims.Groups, name)
+				}
+			}
+		}
+	}
+
+	return &claims, nil
+}
+
+// contains : Checks if the audience includes the client
+func (a audience) contains(clientID string) bool {
+	for _, item := range a {
+		if item == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeSegment : Decodes base64url JSON segment of JWT
+func decodeSegment(segment string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,177 @@
+// Package pages renders HTML pages served to short link visitors and to users opening links from emails
+package pages
+
+import (
+	"bytes"
+	"html/template"
+	"path/filepath"
+)
+
+var parsedTemplates = template.Must(parseBuiltin())
+
+// parseBuiltin : Parses templates shipped with the service
+func parseBuiltin() (*template.Template, error) {
+	return template.New("pages").Parse(layoutTemplate + appTemplate + previewTemplate + interstitialTemplate + unfurlTemplate + verifyEmailTemplate)
+}
+
+// LoadOverrides : Replaces built-in templates with ones defined ({{define "name"}}) in *.html files of the directory
+func LoadOverrides(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	// Executed templates can not be changed, so overrides are applied to freshly parsed built-in ones
+	overridden, err := parseBuiltin()
+	if err != nil {
+		return err
+	}
+	if overridden, err = overridden.ParseFiles(files...); err != nil {
+		return err
+	}
+
+	parsedTemplates = overridden
+	return nil
+}
+
+// AppData : Data of the page opening native app
+type AppData struct {
+	// Deep link of the app (custom scheme), marked safe because its scheme is validated on saving
+	AppURI template.URL
+	// Link opened if app is not installed
+	Fallback string
+}
+
+// PreviewData : Data of the page showing where short link leads
+type PreviewData struct {
+	URL         string
+	Title       string
+	Destination string
+	// Visitors may be sent to other destinations by redirect rules or A/B split
+	Varies    bool
+	UsesCount uint64
+}
+
+// InterstitialData : Data of the page shown before redirecting to the destination
+type InterstitialData struct {
+	Title       string
+	Destination string
+	Seconds     int
+}
+
+// UnfurlData : Data of the page with OpenGraph and Twitter card tags served to bots building link previews
+type UnfurlData struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	TwitterCard string
+	Destination string
+}
+
+// VerifyEmailData : Data of the page confirming email verification, the form posts the token from the link
+type VerifyEmailData struct {
+	Token string
+	// Set after the form was posted
+	Verified bool
+	Failed   bool
+}
+
+// Render : Returns HTML page made from the template with the name
+func Render(name string, data interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := parsedTemplates.ExecuteTemplate(&buffer, name, data); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+const layoutTemplate = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; max-width: 40em; margin: 4em auto; padding: 0 1em; color: #222; }
+a { color: #0366d6; word-break: break-all; }
+</style>
+</head>
+<body>
+{{end}}
+{{define "footer"}}</body>
+</html>
+{{end}}`
+
+const appTemplate = `
+{{define "app"}}{{template "header" "Opening app..."}}
+<p>Opening the app... If nothing happens, <a href="{{.Fallback}}">continue in the browser</a>.</p>
+<script>
+window.location.href = {{.AppURI}};
+setTimeout(function () { window.location.replace({{.Fallback}}); }, 1500);
+</script>
+{{template "footer"}}{{end}}`
+
+const previewTemplate = `
+{{define "preview"}}{{template "header" "Link preview"}}
+<h1>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</h1>
+<p><a href="{{.URL}}">{{.URL}}</a> leads to:</p>
+<p><a href="{{.Destination}}" rel="nofollow noopener">{{.Destination}}</a></p>
+{{if .Varies}}<p>Some visitors may be sent to a different page.</p>{{end}}
+<p>Clicks: {{.UsesCount}}</p>
+{{template "footer"}}{{end}}`
+
+const interstitialTemplate = `
+{{define "interstitial"}}{{template "header" "Redirecting..."}}
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+<p>You are being redirected to <a href="{{.Destination}}" rel="nofollow noopener">{{.Destination}}</a> in <span id="countdown">{{.Seconds}}</span> seconds.</p>
+<noscript><meta http-equiv="refresh" content="{{.Seconds}};url={{.Destination}}"></noscript>
+<script>
+var seconds = {{.Seconds}};
+var timer = setInterval(function () {
+  seconds--;
+  document.getElementById("countdown").textContent = seconds;
+  if (seconds <= 0) {
+    clearInterval(timer);
+    window.location.replace({{.Destination}});
+  }
+}, 1000);
+</script>
+{{template "footer"}}{{end}}`
+
+const unfurlTemplate = `
+{{define "unfurl"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="description" content="{{.Description}}">{{end}}
+{{if .Image}}<meta property="og:image" content="{{.Image}}">{{end}}
+<meta name="twitter:card" content="{{.TwitterCard}}">
+<meta name="twitter:title" content="{{.Title}}">
+{{if .Description}}<meta name="twitter:description" content="{{.Description}}">{{end}}
+{{if .Image}}<meta name="twitter:image" content="{{.Image}}">{{end}}
+</head>
+<body>
+<p><a href="{{.Destination}}">{{.Title}}</a></p>
+</body>
+</html>
+{{end}}`
+
+const verifyEmailTemplate = `
+{{define "verify_email"}}{{template "header" "Verify email"}}
+{{if .Verified}}<p>Your email is verified.</p>
+{{else if .Failed}}<p>The link is invalid or expired, request a new one.</p>
+{{else}}<form method="post" action="verify">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Confirm that this is the email of your account.</p>
+<button type="submit">Verify email</button>
+</form>{{end}}
+{{template "footer"}}{{end}}`
@@ -0,0 +1,174 @@
+package qrcode
+
+// reedSolomonMultiply : Multiplication in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func reedSolomonMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = reedSolomonMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = reedSolomonMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= reedSolomonMultiply(coefficient, factor)
+		}
+	}
+
+	return result
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask : XORs data modules with the mask, applying it twice restores the original
+func (code *Code) applyMask(mask int) {
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.isFunction[y][x] && maskBit(mask, x, y) {
+				code.modules[y][x] = !code.modules[y][x]
+			}
+		}
+	}
+}
+
+func (code *Code) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penaltyScore(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask)
+	}
+
+	code.applyMask(best)
+	code.drawFormatBits(best)
+}
+
+// penaltyScore : Penalty of the current modules as defined by the QR code specification
+func (code *Code) penaltyScore() int {
+	result := 0
+	size := code.Size
+
+	line := make([]bool, size)
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < size; i++ {
+			for j := 0; j < size; j++ {
+				if horizontal {
+					line[j] = code.modules[i][j]
+				} else {
+					line[j] = code.modules[j][i]
+				}
+			}
+			result += linePenalty(line)
+		}
+	}
+
+	// 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if code.modules[y][x] {
+				dark++
+			}
+			if x < size-1 && y < size-1 {
+				color := code.modules[y][x]
+				if color == code.modules[y][x+1] && color == code.modules[y+1][x] && color == code.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := size * size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+
+	return result
+}
+
+var finderLikePatterns = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func linePenalty(line []bool) int {
+	result := 0
+
+	// Runs of five or more modules of the same color
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += 3 + run - 5
+		}
+		run = 1
+	}
+
+	// Patterns looking like finder patterns
+	for i := 0; i+11 <= len(line); i++ {
+		for _, pattern := range finderLikePatterns {
+			matches := true
+			for j, dark := range pattern {
+				if line[i+j] != dark {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				result += 40
+			}
+		}
+	}
+
+	return result
+}
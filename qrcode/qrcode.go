@@ -0,0 +1,354 @@
+// Package qrcode encodes text into QR codes (byte mode, versions 1-40) and renders them as PNG or SVG
+package qrcode
+
+import (
+	"errors"
+	"strings"
+)
+
+// Level : Error correction level
+type Level int
+
+// Error correction levels, higher levels restore more damaged codes but make them bigger
+const (
+	Low Level = iota
+	Medium
+	Quartile
+	High
+)
+
+// ParseLevel : Returns error correction level by its letter (L, M, Q or H)
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return Low, nil
+	case "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	}
+
+	return Low, errors.New("Unknown error correction level")
+}
+
+// formatBits : Error correction level bits of the format information
+var formatBits = [4]int{1, 0, 3, 2}
+
+// eccCodewordsPerBlock : Error correction codewords in each block by level and version
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// errorCorrectionBlocks : Number of error correction blocks by level and version
+var errorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code : Encoded QR code
+type Code struct {
+	Version int
+	Level   Level
+	// Size : Width and height in modules
+	Size int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Encode : Returns QR code of the smallest version that fits text with the error correction level
+func Encode(text string, level Level) (*Code, error) {
+	data := []byte(text)
+
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+charCountBits(version)+len(data)*8 <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, errors.New("Text is too long for QR code")
+	}
+
+	// Byte mode segment followed by terminator and padding
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version, level) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for padByte := 0xEC; len(bits) < capacity; padByte ^= 0xEC ^ 0x11 {
+		bits.append(padByte, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+
+	code := newCode(version, level)
+	code.drawFunctionPatterns()
+	code.drawCodewords(code.addEccAndInterleave(codewords))
+	code.applyBestMask()
+
+	return code, nil
+}
+
+// Module : Checks if module at column x and row y is dark, modules outside of the code are light
+func (code *Code) Module(x, y int) bool {
+	return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.modules[y][x]
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	code := &Code{Version: version, Level: level, Size: size}
+
+	code.modules = make([][]bool, size)
+	code.isFunction = make([][]bool, size)
+	for i := range code.modules {
+		code.modules[i] = make([]bool, size)
+		code.isFunction[i] = make([]bool, size)
+	}
+
+	return code
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 != 0)
+	}
+}
+
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*errorCorrectionBlocks[level][version]
+}
+
+func (code *Code) setFunctionModule(x, y int, dark bool) {
+	code.modules[y][x] = dark
+	code.isFunction[y][x] = true
+}
+
+func (code *Code) alignmentPatternPositions() []int {
+	if code.Version == 1 {
+		return nil
+	}
+
+	numAlign := code.Version/7 + 2
+	step := (code.Version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i := 0; i < numAlign-1; i++ {
+		positions[numAlign-1-i] = code.Size - 7 - i*step
+	}
+
+	return positions
+}
+
+func (code *Code) drawFunctionPatterns() {
+	// Timing patterns
+	for i := 0; i < code.Size; i++ {
+		code.setFunctionModule(6, i, i%2 == 0)
+		code.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	for _, center := range [][2]int{{3, 3}, {code.Size - 4, 3}, {3, code.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && y >= 0 && x < code.Size && y < code.Size {
+					distance := maxInt(absInt(dx), absInt(dy))
+					code.setFunctionModule(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	// Alignment patterns, except ones overlapping finder patterns
+	positions := code.alignmentPatternPositions()
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					code.setFunctionModule(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Format bits are reserved now and drawn after mask is chosen
+	code.drawFormatBits(0)
+	code.drawVersion()
+}
+
+func (code *Code) drawFormatBits(mask int) {
+	data := formatBits[code.Level]<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 != 0
+	}
+
+	// First copy around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		code.setFunctionModule(8, i, bit(i))
+	}
+	code.setFunctionModule(8, 7, bit(6))
+	code.setFunctionModule(8, 8, bit(7))
+	code.setFunctionModule(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		code.setFunctionModule(14-i, 8, bit(i))
+	}
+
+	// Second copy split between the other finder patterns
+	for i := 0; i < 8; i++ {
+		code.setFunctionModule(code.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		code.setFunctionModule(8, code.Size-15+i, bit(i))
+	}
+	code.setFunctionModule(8, code.Size-8, true)
+}
+
+func (code *Code) drawVersion() {
+	if code.Version < 7 {
+		return
+	}
+
+	remainder := code.Version
+	for i := 0; i < 12; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+	}
+	bits := code.Version<<12 | remainder
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 != 0
+		a, b := code.Size-11+i%3, i/3
+		code.setFunctionModule(a, b, dark)
+		code.setFunctionModule(b, a, dark)
+	}
+}
+
+func (code *Code) addEccAndInterleave(data []byte) []byte {
+	numBlocks := errorCorrectionBlocks[code.Level][code.Version]
+	blockEccLength := eccCodewordsPerBlock[code.Level][code.Version]
+	rawCodewords := numRawDataModules(code.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLength := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockEccLength)
+
+	blocks := make([][]byte, numBlocks)
+	for i, offset := 0, 0; i < numBlocks; i++ {
+		dataLength := shortBlockLength - blockEccLength
+		if i >= numShortBlocks {
+			dataLength++
+		}
+
+		block := append([]byte{}, data[offset:offset+dataLength]...)
+		offset += dataLength
+
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip padding of short blocks
+			if i != shortBlockLength-blockEccLength || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
+
+func (code *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < code.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = code.Size - 1 - vertical
+				}
+
+				if !code.isFunction[y][x] && i < len(data)*8 {
+					code.modules[y][x] = (data[i>>3]>>(7-uint(i&7)))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
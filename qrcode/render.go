@@ -0,0 +1,105 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// DefaultMargin : Quiet zone width in modules required by the specification
+const DefaultMargin = 4
+
+// Options : Rendering options
+type Options struct {
+	// Size : Requested width and height in pixels, rounded down to a whole number of pixels per module
+	Size       int
+	Margin     int
+	Foreground color.Color
+	Background color.Color
+}
+
+func (o Options) scale(modules int) int {
+	if scale := o.Size / modules; scale > 0 {
+		return scale
+	}
+	return 1
+}
+
+// PNG : Renders code as PNG image
+func (code *Code) PNG(options Options) ([]byte, error) {
+	modules := code.Size + options.Margin*2
+	scale := options.scale(modules)
+
+	palette := color.Palette{options.Background, options.Foreground}
+	img := image.NewPaletted(image.Rect(0, 0, modules*scale, modules*scale), palette)
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			if !code.Module(x-options.Margin, y-options.Margin) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(x*scale+dx, y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SVG : Renders code as SVG image scaled to the requested size
+func (code *Code) SVG(options Options) []byte {
+	modules := code.Size + options.Margin*2
+	size := options.scale(modules) * modules
+
+	var path bytes.Buffer
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+options.Margin, y+options.Margin)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", size, size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hexColor(options.Background))
+	fmt.Fprintf(&buf, `<path d="%s" fill="%s"/>`+"\n", path.String(), hexColor(options.Foreground))
+	fmt.Fprintf(&buf, "</svg>\n")
+
+	return buf.Bytes()
+}
+
+// ParseColor : Parses RGB color in "rrggbb" or "rgb" hex form, with optional leading "#"
+func ParseColor(value string) (color.Color, error) {
+	if len(value) > 0 && value[0] == '#' {
+		value = value[1:]
+	}
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+
+	var r, g, b uint8
+	if len(value) != 6 {
+		return nil, fmt.Errorf("Invalid color %q", value)
+	}
+	if _, err := fmt.Sscanf(value, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return nil, fmt.Errorf("Invalid color %q", value)
+	}
+
+	return color.RGBA{R: r, G: g, B: b, A: 0xff}, nil
+}
+
+func hexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	h "shorts/helper"
+)
+
+// Policy : Token bucket holding up to Limit requests that is refilled completely during Period
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Disabled : Checks if requests of the policy are not limited
+func (p Policy) Disabled() bool {
+	return p.Limit <= 0 || p.Period <= 0
+}
+
+// rate : Tokens added per second
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result : State of a bucket after taking tokens
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the next token, set if the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Store : Keeps token buckets. Instances of the service behind a load balancer need a shared store to share the limits
+type Store interface {
+	// Take : Removes n tokens from the bucket of the key if it has them, n = 0 only checks that a token is left
+	Take(key string, policy Policy, n int) Result
+}
+
+// Default : Store used by the service
+var Default Store = NewMemoryStore()
+
+// Policies : Limits of the service, requests of disabled policies are not limited
+type Policies struct {
+	// All authenticated API requests, per user
+	API Policy
+	// Creating short links and users, per user or IP
+	Create Policy
+	// Sign-in attempts, password reset requests and failed authentication, per IP
+	Auth Policy
+	// Redirects of short links, per IP
+	Redirect Policy
+}
+
+// PoliciesFromEnv : Returns policies configured as "<limit>/<period>" (e.g. "60/1m") or "off",
+// invalid values are replaced with defaults
+func PoliciesFromEnv() Policies {
+	return Policies{
+		API:      policyFromEnv("api", "RATE_LIMIT_API", "600/1m"),
+		Create:   policyFromEnv("create", "RATE_LIMIT_CREATE", "60/1m"),
+		Auth:     policyFromEnv("auth", "RATE_LIMIT_AUTH", "10/1m"),
+		Redirect: policyFromEnv("redirect", "RATE_LIMIT_REDIRECT", "600/1m"),
+	}
+}
+
+// policyFromEnv : Returns policy from the environment variable
+func policyFromEnv(name, key, fallback string) Policy {
+	if policy, err := ParsePolicy(name, h.GetEnv(key, fallback)); err == nil {
+		return policy
+	}
+
+	policy, _ := ParsePolicy(name, fallback)
+	return policy
+}
+
+// ParsePolicy : Parses "<limit>/<period>" or "off"
+func ParsePolicy(name, value string) (Policy, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return Policy{Name: name}, nil
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return Policy{}, errors.New("Invalid rate limit: " + value)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 0 {
+		return Policy{}, errors.New("Invalid rate limit: " + value)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Policy{}, errors.New("Invalid rate limit: " + value)
+	}
+
+	return Policy{Name: name, Limit: limit, Period: period}, nil
+}
+
+// bucket : Tokens left at the time of the last update
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// defaultMaxKeys : Most buckets kept by MemoryStore by default
+const defaultMaxKeys = 100000
+
+// MemoryStore : Keeps buckets in memory of the process
+type MemoryStore struct {
+	// Clock returns current time
+	Clock func() time.Time
+	// Most buckets kept, the least recently used one is evicted for a new key
+	MaxKeys int
+
+	mutex   sync.Mutex
+	buckets map[string]*list.Element
+	// Buckets from the most to the least recently used, so eviction does not scan them
+	recent *list.List
+	swept  time.Time
+}
+
+// NewMemoryStore : Returns empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{Clock: time.Now, MaxKeys: defaultMaxKeys, buckets: make(map[string]*list.Element), recent: list.New()}
+}
+
+// Take : Removes n tokens from the bucket of the key if it has them
+func (s *MemoryStore) Take(key string, policy Policy, n int) Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.Clock()
+	s.sweep(now)
+
+	key = policy.Name + ":" + key
+	var b *bucket
+	if element, exists := s.buckets[key]; exists {
+		s.recent.MoveToFront(element)
+		b = element.Value.(*bucket)
+	} else {
+		if s.MaxKeys > 0 && len(s.buckets) >= s.MaxKeys {
+			s.evict()
+		}
+		b = &bucket{key: key, tokens: float64(policy.Limit), updated: now, period: policy.Period}
+		s.buckets[key] = s.recent.PushFront(b)
+	}
+
+	b.tokens = math.Min(float64(policy.Limit), b.tokens+now.Sub(b.updated).Seconds()*policy.rate())
+	b.updated = now
+
+	result := Result{Limit: policy.Limit}
+	if b.tokens >= math.Max(float64(n), 1) {
+		b.tokens -= float64(n)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = tokensDuration(math.Max(float64(n), 1)-b.tokens, policy)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = tokensDuration(float64(policy.Limit)-b.tokens, policy)
+
+	return result
+}
+
+// sweep : Removes buckets that are full again, at most once a minute
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+
+	for key, element := range s.buckets {
+		if b := element.Value.(*bucket); now.Sub(b.updated) >= b.period {
+			s.recent.Remove(element)
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// evict : Removes the least recently used bucket
+func (s *MemoryStore) evict() {
+	if element := s.recent.Back(); element != nil {
+		s.recent.Remove(element)
+		delete(s.buckets, element.Value.(*bucket).key)
+	}
+}
+
+// tokensDuration : Time needed to add the tokens to a bucket
+func tokensDuration(tokens float64, policy Policy) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(tokens / policy.rate() * float64(time.Second)))
+}
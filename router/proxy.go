@@ -0,0 +1,68 @@
+package router
+
+import (
+	"net"
+	"strings"
+
+	h "shorts/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedProxies : Returns networks of proxies from TRUSTED_PROXIES (comma separated IPs or CIDRs), invalid entries are skipped
+func trustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(h.GetEnv("TRUSTED_PROXIES", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+// isTrusted : Checks if the IP belongs to one of the networks
+func isTrusted(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveClientIP : Replaces the remote address with the client IP from X-Forwarded-For if the request came through trusted proxies.
+// Entries are taken from the right, the first one not added by a trusted proxy is the client, anything left of it may be forged.
+// Headers of requests from other peers are ignored, so c.ClientIP() can be used for limits
+func resolveClientIP(networks []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil || len(networks) == 0 || !isTrusted(net.ParseIP(host), networks) {
+			return
+		}
+
+		forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+			if ip == nil {
+				return
+			}
+			if !isTrusted(ip, networks) {
+				c.Request.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	h "shorts/helper"
+	"shorts/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimit : Limit requests of the policy per user on authenticated routes and per IP otherwise
+func rateLimit(policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limitRequest(c, policy, 1, requestKey(c)) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requestKey : Returns bucket key of the current user or of the client IP (the /64 network for IPv6)
+func requestKey(c *gin.Context) string {
+	if userID, exists := c.Get(gin.AuthUserKey); exists {
+		return "user:" + strconv.FormatUint(userID.(uint64), 10)
+	}
+
+	return "ip:" + h.ClientNetwork(c.ClientIP())
+}
+
+// limitRequest : Takes n tokens of the policy for every key, responds with 429 and returns false if a bucket is empty.
+// RateLimit-* headers describe the most restrictive bucket, they are not set by checks (n = 0) that passed
+func limitRequest(c *gin.Context, policy ratelimit.Policy, n int, keys ...string) bool {
+	if policy.Disabled() {
+		return true
+	}
+
+	var result ratelimit.Result
+	for i, key := range keys {
+		keyResult := ratelimit.Default.Take(key, policy, n)
+		if i == 0 || !keyResult.Allowed || (result.Allowed && keyResult.Remaining < result.Remaining) {
+			result = keyResult
+		}
+	}
+
+	if result.Allowed && n == 0 {
+		return true
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	c.Header("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(ceilSeconds(policy.Period)))
+
+	if !result.Allowed {
+		retryAfter := ceilSeconds(result.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		h.AbortWithError(c, http.StatusTooManyRequests, h.NewRateLimitedError())
+		return false
+	}
+
+	return true
+}
+
+// authenticationFailed : Takes a token of the auth policy from the client and responds with 401
+func authenticationFailed(c *gin.Context, policy ratelimit.Policy) {
+	if !policy.Disabled() {
+		ratelimit.Default.Take(requestKey(c), policy, 1)
+	}
+
+	responseUnauthorized(c)
+}
+
+// ceilSeconds : Returns the duration in whole seconds rounded up
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
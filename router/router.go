@@ -2,15 +2,18 @@ package router
 
 import (
 	"encoding/base64"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"shorts/controllers"
 	"shorts/database"
 	h "shorts/helper"
 	"shorts/models"
+	"shorts/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,10 +22,14 @@ import (
 func SetupRouter() *gin.Engine {
 
 	r := gin.Default()
-	r.Use(errorHandler)
+	// Forwarding headers are set by anyone, they are only used for requests of TRUSTED_PROXIES
+	r.ForwardedByClientIP = false
+	r.Use(resolveClientIP(trustedProxies()), errorHandler)
+
+	policies := ratelimit.PoliciesFromEnv()
 
 	// Routes for authenticated only users
-	authorizedV1 := r.Group("v1/", basicAuth())
+	authorizedV1 := r.Group("v1/", authenticate(policies.Auth), rateLimit(policies.API), apiQuota())
 
 	// User actions
 
@@ -35,6 +42,147 @@ func SetupRouter() *gin.Engine {
 	// security:
 	//   basic:
 	authorizedV1.GET("me", controllers.GetCurrentUser)
+	// swagger:route PUT /me/password user changePassword
+	// Change password of currently authenticated user, the current password is required
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	// security:
+	//   basic:
+	authorizedV1.PUT("me/password", controllers.ChangePassword)
+	// swagger:route PUT /me/name user changeName
+	// Change name of currently authenticated user if it is not taken by another user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: UserResponse
+	// security:
+	//   basic:
+	authorizedV1.PUT("me/name", controllers.ChangeName)
+	// swagger:route PUT /me/email user setEmail
+	// Change email of currently authenticated user and send verification link to it, the password is required
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	// security:
+	//   basic:
+	authorizedV1.PUT("me/email", controllers.SetEmail)
+	// swagger:route GET /me/export user exportAccount
+	// Return all data of currently authenticated user: workspaces, links with uses, domains and webhooks
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: AccountExportResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("me/export", controllers.ExportAccount)
+	// swagger:route DELETE /me user deleteAccount
+	// Delete currently authenticated user. Links are deleted or transferred to another member of the user's workspaces
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	// security:
+	//   basic:
+	authorizedV1.DELETE("me", controllers.DeleteAccount)
+	// swagger:route POST /me/2fa user enableTwoFactor
+	// Generate pending TOTP secret of currently authenticated user with otpauth:// URI and its QR code.
+	// Two-factor authentication is enabled once the first code is confirmed
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: TwoFactorSetupResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("me/2fa", controllers.EnableTwoFactor)
+	// swagger:route POST /me/2fa/confirm user confirmTwoFactor
+	// Enable two-factor authentication with the first code from authenticator app and return recovery codes.
+	// Afterwards Basic authentication is refused, API tokens are issued by POST /login with a code
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: RecoveryCodesResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("me/2fa/confirm", controllers.ConfirmTwoFactor)
+	// swagger:route POST /me/2fa/recovery-codes user regenerateRecoveryCodes
+	// Replace recovery codes, TOTP code is required
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: RecoveryCodesResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("me/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+	// swagger:route DELETE /me/2fa user disableTwoFactor
+	// Disable two-factor authentication, password and TOTP or recovery code are required
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	// security:
+	//   basic:
+	authorizedV1.DELETE("me/2fa", controllers.DisableTwoFactor)
+	// swagger:route GET /me/tokens user getAPITokens
+	// Return API tokens issued to currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: APITokensResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("me/tokens", controllers.GetAPITokens)
+	// swagger:route DELETE /me/tokens/{id} user deleteAPIToken
+	// Revoke specific API token
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("me/tokens/:id", controllers.DeleteAPIToken)
+	// swagger:route POST /me/oidc user linkOIDCIdentity
+	// Return URL of identity provider's login page, signing in there links the provider account to currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   404: ResponseError
+	//   502: ResponseError
+	//   200: OIDCLinkResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("me/oidc", controllers.LinkOIDCIdentity)
+	// swagger:route GET /me/oidc user getOIDCIdentities
+	// Return identity provider accounts linked to currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: OIDCIdentitiesResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("me/oidc", controllers.GetOIDCIdentities)
+	// swagger:route DELETE /me/oidc/{id} user deleteOIDCIdentity
+	// Unlink specific identity provider account
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("me/oidc/:id", controllers.DeleteOIDCIdentity)
+	// swagger:route GET /me/usage user getUsage
+	// Return usage and limits of currently authenticated user's plan and of plans of the user's workspaces
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: UsageResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("me/usage", controllers.GetUsage)
 	// swagger:route GET /logout user logout
 	// Log out current user
 	// responses:
@@ -46,7 +194,7 @@ func SetupRouter() *gin.Engine {
 	// Short links actions
 
 	// swagger:route GET /shorts shortlink getShortlinks
-	// Return list of short links created by currently authenticated user
+	// Return list of short links of all workspaces currently authenticated user is member of
 	// responses:
 	//   400: ResponseError
 	//   401: ResponseError
@@ -55,7 +203,7 @@ func SetupRouter() *gin.Engine {
 	//   basic:
 	authorizedV1.GET("shorts", controllers.GetShortlinks)
 	// swagger:route GET /short/{id} shortlink getShortlink
-	// Return information about specific short link that was created by currently authenticated user
+	// Return information about specific short link of a workspace currently authenticated user is member of
 	// responses:
 	//   400: ResponseError
 	//   401: ResponseError
@@ -70,9 +218,66 @@ func SetupRouter() *gin.Engine {
 	//   400: ResponseError
 	//   401: ResponseError
 	//   201: AddShortResponse
+	//   429: ResponseError
+	//   402: ResponseError
 	// security:
 	//   basic:
-	authorizedV1.POST("shorts", controllers.AddShortlink)
+	authorizedV1.POST("shorts", rateLimit(policies.Create), controllers.AddShortlink)
+	// swagger:route PATCH /shorts/{id} shortlink updateShortlink
+	// Update full link and query parameters (UTM, defaults, forwarding) of specific short link
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ShortlinkResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.PATCH("shorts/:id", controllers.UpdateShortlink)
+	// swagger:route PUT /shorts/{id}/rules shortlink setShortlinkRules
+	// Replace conditional redirect rules (device, country, language, time) of specific short link.
+	// Rules are evaluated in the given order, the full link is used if none of them matches
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ShortlinkRulesResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.PUT("shorts/:id/rules", controllers.SetShortlinkRules)
+	// swagger:route PUT /shorts/{id}/destinations shortlink setShortlinkDestinations
+	// Replace weighted destinations (A/B split) of specific short link, one of them is chosen on every redirect
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ShortlinkDestinationsResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.PUT("shorts/:id/destinations", controllers.SetShortlinkDestinations)
+	// swagger:route GET /shorts/{id}/stats stats getShortlinkStats
+	// Return uses count of specific short link, of its QR code and of each its destination
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ShortlinkStatsResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.GET("shorts/:id/stats", controllers.GetShortlinkStats)
+	// swagger:route GET /shorts/{id}/qr shortlink getShortlinkQR
+	// Return QR code of the public URL of specific short link as PNG or SVG image.
+	// Uses that come from the QR code are counted separately in the short link stats
+	// produces:
+	// - image/png
+	// - image/svg+xml
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: QRCodeResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.GET("shorts/:id/qr", controllers.GetShortlinkQR)
 	// swagger:route DELETE /shorts shortlink deleteShortlink
 	// Delete specific short link that was created by currently authenticated user
 	// responses:
@@ -84,6 +289,326 @@ func SetupRouter() *gin.Engine {
 	//   basic:
 	authorizedV1.DELETE("shorts/:id", controllers.DeleteShortlink)
 
+	// Custom domains actions
+
+	// swagger:route GET /domains domain getDomains
+	// Return list of custom domains registered by currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: DomainsResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("domains", controllers.GetDomains)
+	// swagger:route POST /domains domain addDomain
+	// Register a new custom domain, short links on it are served from the domain root once it is verified
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   201: DomainResponse
+	//   402: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.POST("domains", controllers.AddDomain)
+	// swagger:route POST /domains/{id}/verify domain verifyDomain
+	// Verify custom domain by TXT record at "_shorts-verification.{host}" containing its verificationToken
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: DomainResponse
+	//   404: ResponseError
+	//   409: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.POST("domains/:id/verify", controllers.VerifyDomain)
+	// swagger:route PATCH /domains/{id} domain updateDomain
+	// Update native apps (iOS app IDs, Android package and certificates) that handle short links of the custom domain
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: DomainResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.PATCH("domains/:id", controllers.UpdateDomain)
+	// swagger:route DELETE /domains/{id} domain deleteDomain
+	// Delete custom domain that has no short links
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("domains/:id", controllers.DeleteDomain)
+
+	// Webhooks actions
+
+	// swagger:route GET /webhooks webhook getWebhooks
+	// Return list of webhooks registered by currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: WebhooksResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("webhooks", controllers.GetWebhooks)
+	// swagger:route POST /webhooks webhook addWebhook
+	// Register endpoint notified about created, updated, deleted and expired links and reached click thresholds.
+	// Payloads are signed with HMAC-SHA256 of "<X-Shorts-Timestamp>.<body>" in the X-Shorts-Signature header
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   201: WebhookResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("webhooks", controllers.AddWebhook)
+	// swagger:route GET /webhooks/{id}/deliveries webhook getWebhookDeliveries
+	// Return latest deliveries of specific webhook with their status and last attempt
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: WebhookDeliveriesResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.GET("webhooks/:id/deliveries", controllers.GetWebhookDeliveries)
+	// swagger:route POST /webhooks/{id}/test webhook testWebhook
+	// Send "ping" event to specific webhook immediately
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: WebhookDeliveryResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.POST("webhooks/:id/test", controllers.TestWebhook)
+	// swagger:route DELETE /webhooks/{id} webhook deleteWebhook
+	// Delete specific webhook with its deliveries
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("webhooks/:id", controllers.DeleteWebhook)
+
+	// Workspaces actions
+
+	// swagger:route GET /workspaces workspace getWorkspaces
+	// Return list of workspaces currently authenticated user is member of with the user's roles
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: WorkspacesResponse
+	// security:
+	//   basic:
+	authorizedV1.GET("workspaces", controllers.GetWorkspaces)
+	// swagger:route POST /workspaces workspace addWorkspace
+	// Create a new workspace owned by currently authenticated user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   201: WorkspaceResponse
+	// security:
+	//   basic:
+	authorizedV1.POST("workspaces", controllers.AddWorkspace)
+	// swagger:route GET /workspaces/{id}/members workspace getWorkspaceMembers
+	// Return members of specific workspace with their roles
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   200: WorkspaceMembersResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.GET("workspaces/:id/members", controllers.GetWorkspaceMembers)
+	// swagger:route PUT /workspaces/{id}/members workspace setWorkspaceMember
+	// Add user to specific workspace or change the user's role (owners only)
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: WorkspaceMemberResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.PUT("workspaces/:id/members", controllers.SetWorkspaceMember)
+	// swagger:route DELETE /workspaces/{id}/members/{userId} workspace deleteWorkspaceMember
+	// Remove user from specific workspace (owners only, other members can remove themselves)
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("workspaces/:id/members/:userId", controllers.DeleteWorkspaceMember)
+	// swagger:route DELETE /workspaces/{id} workspace deleteWorkspace
+	// Delete specific workspace without short links (owners only)
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	authorizedV1.DELETE("workspaces/:id", controllers.DeleteWorkspace)
+
+	// Routes for administrators only
+	adminV1 := authorizedV1.Group("admin/", adminOnly())
+
+	// swagger:route GET /admin/blocklist admin getBlocklist
+	// Return list of blocked domains and link patterns
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: BlocklistResponse
+	// security:
+	//   basic:
+	adminV1.GET("blocklist", controllers.GetBlocklist)
+	// swagger:route POST /admin/blocklist admin addBlocklistEntry
+	// Block a domain (with subdomains) or links matching a regular expression
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   201: BlocklistEntryResponse
+	// security:
+	//   basic:
+	adminV1.POST("blocklist", controllers.AddBlocklistEntry)
+	// swagger:route DELETE /admin/blocklist/{id} admin deleteBlocklistEntry
+	// Delete blocklist entry
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.DELETE("blocklist/:id", controllers.DeleteBlocklistEntry)
+	// swagger:route GET /admin/users admin adminGetUsers
+	// Return users with names containing "q" with their roles, links count and sign-in lockout
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: AdminUsersResponse
+	// security:
+	//   basic:
+	adminV1.GET("users", controllers.AdminGetUsers)
+	// swagger:route PATCH /admin/users/{id} admin adminUpdateUser
+	// Change role or plan of specific user or suspend the user
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: AdminUserResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.PATCH("users/:id", controllers.AdminUpdateUser)
+	// swagger:route DELETE /admin/users/{id} admin adminDeleteUser
+	// Delete specific user with the user's links, domains and webhooks
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.DELETE("users/:id", controllers.AdminDeleteUser)
+	// swagger:route DELETE /admin/users/{id}/lock admin adminUnlockUser
+	// Unlock specific user locked out after failed sign-in attempts
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ResponseOK
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.DELETE("users/:id/lock", controllers.AdminUnlockUser)
+	// swagger:route PATCH /admin/shorts/{id} admin adminUpdateShortlink
+	// Disable specific short link of any user (visitors get 410 Gone) or enable it again
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: ShortlinkResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.PATCH("shorts/:id", controllers.AdminUpdateShortlink)
+	// swagger:route GET /admin/stats admin adminGetStats
+	// Return service-wide counters of users, links, uses and webhook deliveries
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: SystemStatsResponse
+	// security:
+	//   basic:
+	adminV1.GET("stats", controllers.AdminGetStats)
+	// swagger:route GET /admin/audit admin getAuditLog
+	// Return latest actions of administrators
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: AuditLogResponse
+	// security:
+	//   basic:
+	adminV1.GET("audit", controllers.AdminGetAuditLog)
+	// swagger:route GET /admin/plans admin adminGetPlans
+	// Return all plans
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: PlansResponse
+	// security:
+	//   basic:
+	adminV1.GET("plans", controllers.AdminGetPlans)
+	// swagger:route POST /admin/plans admin adminAddPlan
+	// Create a new plan with limits of links, custom aliases, custom domains, uses retention and monthly API calls
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   201: PlanResponse
+	// security:
+	//   basic:
+	adminV1.POST("plans", controllers.AdminAddPlan)
+	// swagger:route PUT /admin/plans/{id} admin adminUpdatePlan
+	// Replace name and limits of specific plan
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: PlanResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.PUT("plans/:id", controllers.AdminUpdatePlan)
+	// swagger:route PUT /admin/workspaces/{id}/plan admin adminSetWorkspacePlan
+	// Set plan of specific workspace, its links count against the workspace plan instead of plans of their creators
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   200: WorkspaceResponse
+	//   404: ResponseError
+	// security:
+	//   basic:
+	adminV1.PUT("workspaces/:id/plan", controllers.AdminSetWorkspacePlan)
+
 	publicV1 := r.Group("v1/")
 
 	// swagger:route POST /users user addUser
@@ -91,13 +616,81 @@ func SetupRouter() *gin.Engine {
 	// responses:
 	//   400: ResponseError
 	//   201: ResponseOK
-	publicV1.POST("users", controllers.AddUser)
+	//   429: ResponseError
+	publicV1.POST("users", rateLimit(policies.Create), controllers.AddUser)
+	// swagger:route POST /login user login
+	// Issue API token used as "Authorization: Bearer <token>". Users with two-factor authentication also send TOTP or recovery code
+	// responses:
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   201: LoginResponse
+	//   429: ResponseError
+	publicV1.POST("login", rateLimit(policies.Auth), controllers.Login)
+	// swagger:route GET /oidc/login user oidcLogin
+	// Redirect to login page of identity provider (OpenID Connect authorization code flow with PKCE)
+	// responses:
+	//   302: RedirectResponse
+	//   404: ResponseError
+	//   502: ResponseError
+	publicV1.GET("oidc/login", controllers.OIDCLogin)
+	// swagger:route GET /oidc/callback user oidcCallback
+	// Finish single sign-on: issue API token, creating the user on the first sign-on, or link the identity to the user who started linking.
+	// Workspace roles are granted according to the user's groups at identity provider
+	// responses:
+	//   200: ResponseOK
+	//   201: LoginResponse
+	//   400: ResponseError
+	//   401: ResponseError
+	//   403: ResponseError
+	//   404: ResponseError
+	publicV1.GET("oidc/callback", controllers.OIDCCallback)
+	// swagger:route GET /users/verify user showVerifyEmail
+	// Serve HTML page confirming email verification by the token from verification link, the page posts the token
+	// responses:
+	//   400: ResponseError
+	publicV1.GET("users/verify", controllers.ShowVerifyEmail)
+	// swagger:route POST /users/verify user verifyEmail
+	// Verify email of a user by the token from verification link
+	// responses:
+	//   400: ResponseError
+	//   200: ResponseOK
+	//   429: ResponseError
+	publicV1.POST("users/verify", rateLimit(policies.Auth), controllers.VerifyEmail)
+	// swagger:route POST /users/verify/resend user resendVerification
+	// Send new verification link to unverified email. The response does not tell if the email is registered
+	// responses:
+	//   400: ResponseError
+	//   200: ResponseOK
+	//   429: ResponseError
+	publicV1.POST("users/verify/resend", rateLimit(policies.Auth), controllers.ResendVerification)
+	// swagger:route POST /users/password-reset user requestPasswordReset
+	// Send password reset token to the email. The response does not tell if the email is registered
+	// responses:
+	//   400: ResponseError
+	//   200: ResponseOK
+	//   429: ResponseError
+	publicV1.POST("users/password-reset", rateLimit(policies.Auth), controllers.RequestPasswordReset)
+	// swagger:route POST /users/password-reset/confirm user resetPassword
+	// Set new password by single-use token from password reset email
+	// responses:
+	//   400: ResponseError
+	//   200: ResponseOK
+	//   429: ResponseError
+	publicV1.POST("users/password-reset/confirm", rateLimit(policies.Auth), controllers.ResetPassword)
 	// swagger:route GET /s/{short} shortlink redirectByShortlink
-	// Redirect to a full link by a given short link
+	// Redirect to a full link by a given short link.
+	// The same redirect is available at "/{short}" for custom domains and if ROOT_SHORTLINKS is enabled.
+	// Short link with "+" suffix shows preview page, links with interstitial enabled show page with countdown
 	// responses:
+	//   200: PageResponse
 	//   301: RedirectResponse
-	//   400: ResponseError
-	publicV1.GET("s/:short", controllers.GetShortlinkRedirect)
+	//   404: ResponseError
+	//   410: ResponseError
+	//   429: ResponseError
+	publicV1.GET("s/:short", rateLimit(policies.Redirect), controllers.GetShortlinkRedirect)
+	// Link checkers send HEAD requests, they are redirected the same way but counted as bots
+	publicV1.HEAD("s/:short", rateLimit(policies.Redirect), controllers.GetShortlinkRedirect)
 
 	publicV1Stats := publicV1.Group("stats/")
 
@@ -114,65 +707,202 @@ func SetupRouter() *gin.Engine {
 	//   200: ShortlinksGraphResponse
 	publicV1Stats.GET("graph", controllers.GetShortlinksGraph)
 
-	r.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, h.NewResponseError(h.NewPageNotFoundError()))
+	// App association files for custom domains, so short links open native apps
+	r.GET("/.well-known/apple-app-site-association", controllers.GetAppleAppSiteAssociation)
+	r.GET("/apple-app-site-association", controllers.GetAppleAppSiteAssociation)
+	r.GET("/.well-known/assetlinks.json", controllers.GetAssetLinks)
+
+	// Short links on custom domains (and on the default one if ROOT_SHORTLINKS is set) are served from the root.
+	// gin does not allow "/:short" next to "/v1/...", so it is resolved before responding with 404
+	r.NoRoute(rootShortlinkRedirect(policies.Redirect), func(c *gin.Context) {
+		h.AbortWithError(c, http.StatusNotFound, h.NewPageNotFoundError())
 	})
 
 	return r
 }
 
-// errorHandler : Default error handler
+// rootShortlinkRedirect : Redirect "/{short}" requests that did not match any API route
+func rootShortlinkRedirect(policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return
+		}
+
+		short := strings.TrimPrefix(c.Request.URL.Path, "/")
+		if short == "" || strings.Contains(short, "/") {
+			return
+		}
+
+		if !h.RootShortlinksEnabled() && models.FindDomainIDByHost(c.Request.Host) == models.DefaultDomainID {
+			return
+		}
+
+		if !limitRequest(c, policy, 1, requestKey(c)) {
+			return
+		}
+
+		c.Params = append(c.Params, gin.Param{Key: "short", Value: short})
+		controllers.GetShortlinkRedirect(c)
+		c.Abort()
+	}
+}
+
+// errorHandler : Responds with the last error registered by handlers (see helper.AbortWithError) if nothing was written yet.
+// Errors are returned as JSON with "result", "code" and "error" or, if the client asks for it, as RFC 7807 problem details
 func errorHandler(c *gin.Context) {
 	c.Next()
 
-	if len(c.Errors) > 0 {
+	if len(c.Errors) == 0 {
+		return
+	}
+
+	last := c.Errors.Last()
+	if c.Writer.Written() {
+		fmt.Println(c.Errors)
+		return
+	}
+
+	status, _ := last.Meta.(int)
+	appErr, status := h.TranslateError(database.TranslateError(last.Err), status)
+	// Causes of internal errors are only logged
+	if status >= http.StatusInternalServerError {
 		fmt.Println(c.Errors)
 	}
+
+	if h.ProblemDetailsWanted(c.GetHeader("Accept")) {
+		problem, err := json.Marshal(h.NewProblemDetails(appErr, status, c.Request.URL.Path))
+		if err == nil {
+			c.Data(status, h.ProblemContentType, problem)
+			return
+		}
+	}
+
+	c.JSON(status, h.NewResponseError(appErr))
 }
 
-// basicAuth : Check for authentication
-func basicAuth() gin.HandlerFunc {
+// authenticate : Check for authentication with Basic credentials or Bearer API token.
+// Users with two-factor authentication can only use tokens, so a stolen password alone is not enough
+func authenticate(policy ratelimit.Policy) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := strings.SplitN(c.Request.Header.Get("Authorization"), " ", 2)
 
-		if len(auth) != 2 || auth[0] != "Basic" {
+		if len(auth) != 2 {
 			responseUnauthorized(c)
 			return
 		}
 
-		authPayload, _ := base64.StdEncoding.DecodeString(auth[1])
-		authPair := strings.SplitN(string(authPayload), ":", 2)
+		// Failed attempts are taken from the auth policy bucket of the client, clients guessing credentials are stopped
+		if !limitRequest(c, policy, 0, requestKey(c)) {
+			return
+		}
+
+		var user models.User
+		switch auth[0] {
+		case "Basic":
+			authPayload, _ := base64.StdEncoding.DecodeString(auth[1])
+			authPair := strings.SplitN(string(authPayload), ":", 2)
+
+			if len(authPair) != 2 {
+				authenticationFailed(c, policy)
+				return
+			}
 
-		if len(authPair) != 2 {
+			// Wrong credentials are not errors, errors of the database are not the client's fault
+			signedIn, retryAfter, err := models.SignIn(database.DB, authPair[0], authPair[1], h.ClientNetwork(c.ClientIP()))
+			if err != nil {
+				h.AbortWithError(c, http.StatusInternalServerError, err)
+				return
+			}
+			if retryAfter > 0 {
+				responseLoginLocked(c, retryAfter)
+				return
+			}
+			if signedIn == nil {
+				authenticationFailed(c, policy)
+				return
+			}
+			user = *signedIn
+			if user.TOTPEnabled {
+				c.Header("WWW-Authenticate", "Bearer")
+				h.AbortWithError(c, http.StatusUnauthorized, h.NewTwoFactorRequiredError())
+				return
+			}
+		case "Bearer":
+			tokenUser, err := models.FindAPITokenUser(auth[1])
+			if err != nil {
+				authenticationFailed(c, policy)
+				return
+			}
+			user = *tokenUser
+		default:
 			responseUnauthorized(c)
 			return
 		}
 
-		authOK, userID := authenticateUser(authPair[0], authPair[1])
-		if authOK {
-			c.Set(gin.AuthUserKey, userID)
-		} else {
-			responseUnauthorized(c)
+		if err := user.SignInError(); err != nil {
+			h.AbortWithError(c, http.StatusForbidden, err)
 			return
 		}
 
+		c.Set(gin.AuthUserKey, user.ID)
+
 		c.Next()
 	}
 }
 
-// authenticateUser: Find user;password pair in the DB
-func authenticateUser(username, password string) (bool, uint64) {
-	user := models.User{Name: username, Password: password}
+// adminOnly : Allow access only for users with admin role
+func adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		if err := database.DB.First(&user, c.MustGet(gin.AuthUserKey).(uint64)).Error; err != nil ||
+			user.Role != models.UserRoleAdmin {
+			h.AbortWithError(c, http.StatusForbidden, h.NewForbiddenError())
+			return
+		}
 
-	err := database.DB.Where(&user).First(&user)
-	if err.Error != nil {
-		return false, 0
+		c.Next()
 	}
+}
+
+// quotaExemptRoutes : Routes available after API calls are used up, users can still see usage, export or delete their accounts
+// and revoke tokens
+var quotaExemptRoutes = map[string]bool{
+	"GET /v1/me/usage":         true,
+	"GET /v1/me/export":        true,
+	"DELETE /v1/me":            true,
+	"GET /v1/me/tokens":        true,
+	"DELETE /v1/me/tokens/:id": true,
+}
+
+// apiQuota : Count authenticated API calls of the user and reject them once monthly calls of the user's plan are used up,
+// except for quotaExemptRoutes
+func apiQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usage, err := models.CountAPICall(database.DB, c.MustGet(gin.AuthUserKey).(uint64))
+		if err != nil {
+			h.AbortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if usage.Limit > 0 && usage.Used > usage.Limit && !quotaExemptRoutes[c.Request.Method+" "+c.FullPath()] {
+			now := time.Now().UTC()
+			nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(nextMonth.Sub(now))))
+			h.AbortWithError(c, http.StatusTooManyRequests, h.NewAPIQuotaExceededError(usage.Limit))
+			return
+		}
+
+		c.Next()
+	}
+}
 
-	return true, user.ID
+// responseLoginLocked : Respond that sign-in attempts are blocked after failures
+func responseLoginLocked(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	h.AbortWithError(c, http.StatusTooManyRequests, h.NewLoginLockedError())
 }
 
 func responseUnauthorized(c *gin.Context) {
 	c.Header("WWW-Authenticate", "Basic")
-	c.AbortWithStatusJSON(http.StatusUnauthorized, h.NewResponseError(errors.New("Authentication required")))
+	h.AbortWithError(c, http.StatusUnauthorized, h.NewAuthenticationRequiredError())
 }
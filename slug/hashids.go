@@ -0,0 +1,197 @@
+package slug
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+const (
+	hashidsAlphabet   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	hashidsSeparators = "cfhistuCFHISTU"
+	hashidsSepDiv     = 3.5
+	hashidsGuardDiv   = 12
+)
+
+// Hashids : Obfuscated reversible short links made from the ID (https://hashids.org algorithm for a single number)
+type Hashids struct {
+	salt      []byte
+	minLength int
+	alphabet  []byte
+	seps      []byte
+	guards    []byte
+}
+
+// NewHashids : Returns Hashids generator for the salt and minimal short link length
+func NewHashids(salt string, minLength int) *Hashids {
+	alphabet := []byte{}
+	seps := []byte{}
+	for _, char := range []byte(hashidsAlphabet) {
+		if strings.IndexByte(hashidsSeparators, char) >= 0 {
+			seps = append(seps, char)
+		} else {
+			alphabet = append(alphabet, char)
+		}
+	}
+
+	saltBytes := []byte(salt)
+	consistentShuffle(seps, saltBytes)
+
+	if len(seps) == 0 || float64(len(alphabet))/float64(len(seps)) > hashidsSepDiv {
+		sepsLength := int(math.Ceil(float64(len(alphabet)) / hashidsSepDiv))
+		if sepsLength == 1 {
+			sepsLength++
+		}
+		if sepsLength > len(seps) {
+			diff := sepsLength - len(seps)
+			seps = append(seps, alphabet[:diff]...)
+			alphabet = alphabet[diff:]
+		} else {
+			seps = seps[:sepsLength]
+		}
+	}
+
+	consistentShuffle(alphabet, saltBytes)
+
+	guardCount := int(math.Ceil(float64(len(alphabet)) / hashidsGuardDiv))
+	var guards []byte
+	if len(alphabet) < 3 {
+		guards = seps[:guardCount]
+		seps = seps[guardCount:]
+	} else {
+		guards = alphabet[:guardCount]
+		alphabet = alphabet[guardCount:]
+	}
+
+	return &Hashids{
+		salt:      saltBytes,
+		minLength: minLength,
+		alphabet:  alphabet,
+		seps:      seps,
+		guards:    guards,
+	}
+}
+
+// Generate returns obfuscated id
+func (hid *Hashids) Generate(id uint64) (string, error) {
+	return hid.Encode(id), nil
+}
+
+// UsesID : Hashids are derived from the ID
+func (hid *Hashids) UsesID() bool {
+	return true
+}
+
+// Encode : Returns hash of the number
+func (hid *Hashids) Encode(number uint64) string {
+	alphabet := append([]byte{}, hid.alphabet...)
+
+	numbersHash := int(number % 100)
+	lottery := alphabet[numbersHash%len(alphabet)]
+
+	buffer := append(append([]byte{lottery}, hid.salt...), alphabet...)
+	consistentShuffle(alphabet, buffer[:len(alphabet)])
+
+	result := append([]byte{lottery}, hashNumber(number, alphabet)...)
+
+	if len(result) < hid.minLength {
+		guardIndex := (numbersHash + int(result[0])) % len(hid.guards)
+		result = append([]byte{hid.guards[guardIndex]}, result...)
+
+		if len(result) < hid.minLength {
+			guardIndex = (numbersHash + int(result[2])) % len(hid.guards)
+			result = append(result, hid.guards[guardIndex])
+		}
+	}
+
+	halfLength := len(alphabet) / 2
+	for len(result) < hid.minLength {
+		consistentShuffle(alphabet, append([]byte{}, alphabet...))
+
+		padded := append([]byte{}, alphabet[halfLength:]...)
+		padded = append(padded, result...)
+		result = append(padded, alphabet[:halfLength]...)
+
+		if excess := len(result) - hid.minLength; excess > 0 {
+			result = result[excess/2 : excess/2+hid.minLength]
+		}
+	}
+
+	return string(result)
+}
+
+// Decode : Returns number encoded in the hash
+func (hid *Hashids) Decode(hash string) (uint64, error) {
+	invalidHash := errors.New("Invalid hash")
+
+	parts := strings.Split(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(string(hid.guards), r) {
+			return ' '
+		}
+		return r
+	}, hash), " ")
+
+	breakdown := parts[0]
+	if len(parts) == 3 || len(parts) == 2 {
+		breakdown = parts[1]
+	}
+	if len(breakdown) < 2 || strings.ContainsAny(breakdown[1:], string(hid.seps)) {
+		return 0, invalidHash
+	}
+
+	alphabet := append([]byte{}, hid.alphabet...)
+	lottery := breakdown[0]
+
+	buffer := append(append([]byte{lottery}, hid.salt...), alphabet...)
+	consistentShuffle(alphabet, buffer[:len(alphabet)])
+
+	number, err := unhashNumber(breakdown[1:], alphabet)
+	if err != nil {
+		return 0, err
+	}
+
+	if hid.Encode(number) != hash {
+		return 0, invalidHash
+	}
+
+	return number, nil
+}
+
+func hashNumber(number uint64, alphabet []byte) []byte {
+	alphabetLength := uint64(len(alphabet))
+	hash := []byte{}
+	for {
+		hash = append([]byte{alphabet[number%alphabetLength]}, hash...)
+		number /= alphabetLength
+		if number == 0 {
+			return hash
+		}
+	}
+}
+
+func unhashNumber(hash string, alphabet []byte) (uint64, error) {
+	var number uint64
+	for _, char := range []byte(hash) {
+		position := strings.IndexByte(string(alphabet), char)
+		if position < 0 {
+			return 0, errors.New("Invalid hash")
+		}
+		number = number*uint64(len(alphabet)) + uint64(position)
+	}
+
+	return number, nil
+}
+
+func consistentShuffle(alphabet, salt []byte) {
+	if len(salt) == 0 {
+		return
+	}
+
+	for i, v, p := len(alphabet)-1, 0, 0; i > 0; i, v = i-1, v+1 {
+		v %= len(salt)
+		integer := int(salt[v])
+		p += integer
+		j := (integer + v + p) % i
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+	}
+}
@@ -0,0 +1,101 @@
+// Package slug contains strategies for generating short links
+package slug
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+
+	h "shorts/helper"
+)
+
+// MaxAttempts : How many times creating a short link is retried after a collision
+const MaxAttempts = 5
+
+const base62Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Generator : Strategy of making short links for new links
+type Generator interface {
+	// Generate returns a short link. id is zero for generators that do not use it
+	Generate(id uint64) (string, error)
+	// UsesID reports whether short link is derived from the link ID, so it can be made only after the link is saved
+	UsesID() bool
+}
+
+// FromEnv : Returns generator selected by SLUG_STRATEGY (random, hashids, words or base36)
+func FromEnv() Generator {
+	switch strings.ToLower(h.GetEnv("SLUG_STRATEGY", "random")) {
+	case "base36":
+		return Base36{}
+	case "hashids":
+		return NewHashids(h.GetEnv("SLUG_SALT", ""), getEnvInt("SLUG_LENGTH", 6))
+	case "words":
+		return Words{Count: getEnvInt("SLUG_WORDS", 3), Separator: "-"}
+	default:
+		return Random{Length: getEnvInt("SLUG_LENGTH", 7)}
+	}
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(h.GetEnv(key, "")); err == nil && value > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+// Base36 : Sequential short links, ID in Base36 format
+type Base36 struct{}
+
+// Generate returns id in Base36 format
+func (Base36) Generate(id uint64) (string, error) {
+	return strconv.FormatUint(id, 36), nil
+}
+
+// UsesID : Base36 is derived from the ID
+func (Base36) UsesID() bool {
+	return true
+}
+
+// Random : Random Base62 short links of the fixed length
+type Random struct {
+	Length int
+}
+
+// Generate returns random Base62 string
+func (r Random) Generate(uint64) (string, error) {
+	if r.Length <= 0 {
+		return "", errors.New("Short link length must be positive")
+	}
+
+	result := make([]byte, r.Length)
+	for i := range result {
+		index, err := randomIndex(len(base62Alphabet))
+		if err != nil {
+			return "", err
+		}
+		result[i] = base62Alphabet[index]
+	}
+
+	return string(result), nil
+}
+
+// UsesID : Random short links do not depend on the ID
+func (Random) UsesID() bool {
+	return false
+}
+
+// randomIndex : Returns uniformly distributed random number in [0, n), n must be less than 256
+func randomIndex(n int) (int, error) {
+	limit := 256 - 256%n
+	buffer := make([]byte, 1)
+	for {
+		if _, err := rand.Read(buffer); err != nil {
+			return 0, err
+		}
+		if int(buffer[0]) < limit {
+			return int(buffer[0]) % n, nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package slug
+
+import (
+	"errors"
+	"strings"
+)
+
+var adjectives = []string{
+	"able", "agile", "amber", "ancient", "azure", "bold", "brave", "breezy",
+	"bright", "brisk", "calm", "clever", "cosmic", "crimson", "crisp", "curious",
+	"dapper", "daring", "eager", "early", "electric", "elegant", "epic", "fancy",
+	"fearless", "fluffy", "frosty", "gentle", "giant", "golden", "grand", "happy",
+	"hidden", "humble", "icy", "jolly", "keen", "kind", "lively", "lucky",
+	"lunar", "magic", "mellow", "mighty", "misty", "modest", "noble", "odd",
+	"proud", "purple", "quick", "quiet", "rapid", "rosy", "rustic", "shiny",
+	"silent", "silver", "sleepy", "smooth", "snowy", "solar", "sunny", "swift",
+	"tidy", "tiny", "velvet", "vivid", "warm", "wild", "witty", "zesty",
+}
+
+var nouns = []string{
+	"acorn", "anchor", "apple", "badger", "banana", "beacon", "bear", "bison",
+	"breeze", "brook", "cactus", "canyon", "cedar", "cloud", "comet", "coral",
+	"cricket", "dolphin", "dragon", "eagle", "ember", "falcon", "fern", "fjord",
+	"forest", "fox", "galaxy", "garden", "glacier", "harbor", "hawk", "island",
+	"jaguar", "kettle", "koala", "lagoon", "lantern", "lemon", "lion", "lotus",
+	"maple", "meadow", "meteor", "moose", "mountain", "nebula", "otter", "owl",
+	"panda", "pebble", "pepper", "phoenix", "pine", "planet", "puffin", "rabbit",
+	"river", "rocket", "saturn", "shadow", "sparrow", "spruce", "summit", "tiger",
+	"tulip", "valley", "violet", "walrus", "willow", "wolf", "yak", "zebra",
+}
+
+// Words : Human readable short links made of random adjectives followed by a noun, e.g. "brave-orange-tiger"
+type Words struct {
+	Count     int
+	Separator string
+}
+
+// Generate returns random combination of words
+func (w Words) Generate(uint64) (string, error) {
+	if w.Count <= 0 {
+		return "", errors.New("Words count must be positive")
+	}
+
+	words := make([]string, w.Count)
+	for i := range words {
+		list := adjectives
+		if i == w.Count-1 {
+			list = nouns
+		}
+
+		index, err := randomIndex(len(list))
+		if err != nil {
+			return "", err
+		}
+		words[i] = list[index]
+	}
+
+	return strings.Join(words, w.Separator), nil
+}
+
+// UsesID : Words do not depend on the ID
+func (Words) UsesID() bool {
+	return false
+}
@@ -0,0 +1,114 @@
+package urlcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	h "shorts/helper"
+)
+
+// ErrNonPublicAddress : Returned when an outgoing request would reach loopback, private or link-local address
+var ErrNonPublicAddress = errors.New("Address is not public")
+
+// nonPublicRanges : Networks that are not reachable from the internet or belong to the host itself
+var nonPublicRanges = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+	"192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// IsPublicIP : Checks that IP does not belong to loopback, private, link-local (cloud metadata), multicast or reserved networks
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range nonPublicRanges {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// privateNetworksAllowed : Checks if outgoing requests may reach private networks (ALLOW_PRIVATE_NETWORKS, for local development only)
+func privateNetworksAllowed() bool {
+	return h.GetEnvBool("ALLOW_PRIVATE_NETWORKS", false)
+}
+
+// CheckPublicHost : Resolves the host and returns ErrNonPublicAddress if any of its addresses is not public
+func CheckPublicHost(ctx context.Context, host string) error {
+	if privateNetworksAllowed() {
+		return nil
+	}
+
+	_, err := publicIP(ctx, host)
+	return err
+}
+
+// publicIP : Resolves the host and returns its first address, all of them have to be public
+func publicIP(ctx context.Context, host string) (net.IP, error) {
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, ErrNonPublicAddress
+	}
+
+	for _, address := range addresses {
+		if !IsPublicIP(address.IP) {
+			return nil, ErrNonPublicAddress
+		}
+	}
+
+	return addresses[0].IP, nil
+}
+
+// PublicDialContext : Dials only public addresses. The host is resolved once and the checked address is dialed,
+// so DNS answers changing between the check and the connection do not matter
+func PublicDialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if privateNetworksAllowed() {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := publicIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+}
+
+// NewPublicTransport : Returns HTTP transport connecting only to public addresses, proxies from environment are not used
+func NewPublicTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:           PublicDialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+}
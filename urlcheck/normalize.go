@@ -0,0 +1,198 @@
+package urlcheck
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	h "shorts/helper"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize : Returns canonical form of the link, so the same destination is always stored the same way.
+// Scheme and host are lower cased, international domain names are converted to punycode, default ports and
+// the root path are removed. Query parameters are sorted if NORMALIZE_SORT_QUERY is enabled
+func Normalize(link string) (string, error) {
+	parsedURL, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", err
+	}
+
+	if !parsedURL.IsAbs() {
+		return "", h.NewAbsoluteLinksOnlyError()
+	}
+
+	parsedURL.Scheme = strings.ToLower(parsedURL.Scheme)
+	if parsedURL.Opaque != "" {
+		return parsedURL.String(), nil
+	}
+
+	hostname, err := NormalizeHostname(parsedURL.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := parsedURL.Port()
+	if port == defaultPorts[parsedURL.Scheme] {
+		port = ""
+	}
+
+	if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+	if port != "" {
+		hostname += ":" + port
+	}
+	parsedURL.Host = hostname
+
+	// Only the root path is the same with and without the slash, servers may treat "/a/" and "/a" differently
+	if parsedURL.Path == "/" {
+		parsedURL.Path = ""
+		parsedURL.RawPath = ""
+	}
+
+	if h.GetEnvBool("NORMALIZE_SORT_QUERY", false) && parsedURL.RawQuery != "" {
+		parsedURL.RawQuery = parsedURL.Query().Encode()
+	}
+
+	return parsedURL.String(), nil
+}
+
+// NormalizeHostname : Returns lower cased host name (without port) with international labels converted to punycode
+func NormalizeHostname(hostname string) (string, error) {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if net.ParseIP(hostname) != nil {
+		return hostname, nil
+	}
+
+	labels := strings.Split(hostname, ".")
+	for i, label := range labels {
+		ascii, err := toASCII(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = ascii
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// toASCII : Converts domain label to punycode if it contains non ASCII characters
+func toASCII(label string) (string, error) {
+	for _, r := range label {
+		if r >= utf8.RuneSelf {
+			encoded, err := punycodeEncode(label)
+			if err != nil {
+				return "", err
+			}
+			return "xn--" + encoded, nil
+		}
+	}
+
+	return label, nil
+}
+
+// Bootstring parameters for punycode (RFC 3492)
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+func punycodeEncode(input string) (string, error) {
+	runes := []rune(input)
+	output := make([]byte, 0, len(input))
+
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+
+	basicCount := len(output)
+	handled := basicCount
+	if basicCount > 0 {
+		output = append(output, '-')
+	}
+
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+	for handled < len(runes) {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (int(^uint32(0)>>1)-delta)/(handled+1) {
+			return "", errors.New("Punycode overflow")
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r == n {
+				q := delta
+				for k := punycodeBase; ; k += punycodeBase {
+					t := k - bias
+					if t < punycodeTMin {
+						t = punycodeTMin
+					} else if t > punycodeTMax {
+						t = punycodeTMax
+					}
+					if q < t {
+						break
+					}
+					output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+					q = (q - t) / (punycodeBase - t)
+				}
+				output = append(output, punycodeDigit(q))
+				bias = punycodeAdapt(delta, handled+1, handled == basicCount)
+				delta = 0
+				handled++
+			}
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output), nil
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
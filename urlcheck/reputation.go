@@ -0,0 +1,70 @@
+package urlcheck
+
+import (
+	"bufio"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ReputationChecker : External source of known malicious links
+type ReputationChecker interface {
+	// Check returns error if link is known to be unsafe
+	Check(u *url.URL) error
+}
+
+// Reputation : Reputation checker used for new links, nil if disabled
+var Reputation ReputationChecker
+
+// FileReputationChecker : Reputation checker based on a local file.
+// Every line is either a host (matches its subdomains too) or a URL prefix, lines starting with "#" are ignored
+type FileReputationChecker struct {
+	hosts    []string
+	prefixes []string
+}
+
+// NewFileReputationChecker : Loads reputation list from the file
+func NewFileReputationChecker(path string) (*FileReputationChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	checker := &FileReputationChecker{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.Contains(line, "://") {
+			checker.prefixes = append(checker.prefixes, strings.ToLower(line))
+		} else {
+			checker.hosts = append(checker.hosts, line)
+		}
+	}
+
+	return checker, scanner.Err()
+}
+
+// Check : Returns error if link host or prefix is listed in the file
+func (checker *FileReputationChecker) Check(u *url.URL) error {
+	for _, host := range checker.hosts {
+		if MatchesHost(u.Host, host) {
+			return errors.New("Link has bad reputation")
+		}
+	}
+
+	link := strings.ToLower(u.String())
+	for _, prefix := range checker.prefixes {
+		if strings.HasPrefix(link, prefix) {
+			return errors.New("Link has bad reputation")
+		}
+	}
+
+	return nil
+}
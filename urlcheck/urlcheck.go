@@ -0,0 +1,96 @@
+// Package urlcheck contains safety checks for full links
+package urlcheck
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	h "shorts/helper"
+)
+
+// MaxRedirects : How many redirects are followed when resolving links of other shorteners
+const MaxRedirects = 10
+
+// knownShorteners : Hosts of public link shorteners, links to them are resolved to detect redirect loops
+var knownShorteners = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly", "rb.gy", "rebrand.ly",
+	"s.id", "shorturl.at", "t.co", "t.ly", "tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// AllowedSchemes : Returns schemes allowed for full links (ALLOWED_SCHEMES, "http,https" by default)
+func AllowedSchemes() []string {
+	var schemes []string
+	for _, scheme := range strings.Split(h.GetEnv("ALLOWED_SCHEMES", "http,https"), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	return schemes
+}
+
+// IsSchemeAllowed : Checks if link scheme is in the allowlist
+func IsSchemeAllowed(u *url.URL) bool {
+	for _, scheme := range AllowedSchemes() {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchesHost : Checks if host equals the domain or is its subdomain
+func MatchesHost(host, domain string) bool {
+	host = h.NormalizeHost(host)
+	domain = h.NormalizeHost(domain)
+
+	return domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+}
+
+// IsShortener : Checks if link points to a known link shortener
+func IsShortener(u *url.URL) bool {
+	for _, shortener := range knownShorteners {
+		if MatchesHost(u.Host, shortener) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveChain : Follows redirects of the link and returns every visited URL (the link itself excluded).
+// Only public addresses are requested, ErrNonPublicAddress is returned if the link or a redirect leads elsewhere.
+// Other errors stop resolving without error, so the chain may be incomplete
+func ResolveChain(u *url.URL) ([]*url.URL, error) {
+	var chain []*url.URL
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: NewPublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			chain = append(chain, req.URL)
+			if err := CheckPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+				return err
+			}
+			if len(via) >= MaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	response, err := client.Get(u.String())
+	if err != nil {
+		if errors.Is(err, ErrNonPublicAddress) {
+			return chain, ErrNonPublicAddress
+		}
+		return chain, nil
+	}
+	response.Body.Close()
+
+	return chain, nil
+}
@@ -0,0 +1,145 @@
+package visitor
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// botAgents : User-Agent fragments of crawlers, link checkers, monitoring probes and HTTP libraries.
+// A bare "bot" would match devices like CUBOT, so only its forms used by crawlers are listed
+var botAgents = []string{
+	"bot/",
+	"bot-",
+	"robot",
+	"+http",
+	"crawler",
+	"spider",
+	"slurp",
+	"archiver",
+	"preview",
+	"monitor",
+	"uptime",
+	"pingdom",
+	"statuscake",
+	"healthcheck",
+	"health-check",
+	"kube-probe",
+	"elb-healthchecker",
+	"headlesschrome",
+	"phantomjs",
+	"lighthouse",
+	"curl/",
+	"wget/",
+	"httpie/",
+	"python-requests",
+	"python-urllib",
+	"aiohttp",
+	"go-http-client",
+	"okhttp",
+	"java/",
+	"apache-httpclient",
+	"libwww-perl",
+	"node-fetch",
+	"axios/",
+	"scrapy",
+}
+
+// unfurlBots : User-Agent fragments of bots that fetch links pasted into chats and social networks to show previews
+var unfurlBots = []string{
+	"facebookexternalhit",
+	"facebookcatalog",
+	"twitterbot",
+	"slackbot",
+	"slack-imgproxy",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"linkedinbot",
+	"skypeuripreview",
+	"microsoft teams",
+	"redditbot",
+	"pinterest",
+	"vkshare",
+	"viber",
+	"mattermost",
+	"mastodon",
+	"embedly",
+	"iframely",
+	"google-pagerenderer",
+	"applebot",
+}
+
+// IsUnfurlBot : Checks if the User-Agent belongs to a bot building link preview
+func IsUnfurlBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, bot := range unfurlBots {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBotAgent : Checks if the User-Agent belongs to a bot or a non-browser client. Empty User-Agent is considered a bot
+func IsBotAgent(userAgent string) bool {
+	if strings.TrimSpace(userAgent) == "" || IsUnfurlBot(userAgent) {
+		return true
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, bot := range botAgents {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BotNetworks : Networks of crawlers and health probes, parsed from BOT_IP_RANGES once at startup
+var BotNetworks []*net.IPNet
+
+// ParseIPRanges : Returns networks of comma separated CIDRs or addresses, invalid entries are skipped
+func ParseIPRanges(ranges string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(ranges, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+// IsBotIP : Checks if the IP belongs to one of BotNetworks
+func IsBotIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range BotNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBotRequest : Checks if the request was not sent by a human: HEAD requests (link checkers), bots' User-Agents and IP ranges
+func IsBotRequest(r *http.Request, ip net.IP) bool {
+	return r.Method == http.MethodHead || IsBotAgent(r.UserAgent()) || IsBotIP(ip)
+}
@@ -0,0 +1,55 @@
+package visitor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"sync"
+)
+
+var (
+	fingerprintSecret     []byte
+	fingerprintSecretOnce sync.Once
+)
+
+// SetSecret : Sets key of visitor fingerprints used if VISITOR_SECRET is not set, it has to be called before the first fingerprint
+func SetSecret(value []byte) {
+	fingerprintSecretOnce.Do(func() {
+		if value := os.Getenv("VISITOR_SECRET"); value != "" {
+			fingerprintSecret = []byte(value)
+			return
+		}
+
+		fingerprintSecret = value
+	})
+}
+
+// secret : Key of visitor fingerprints from VISITOR_SECRET or SetSecret, random one is used until restart if neither is set
+func secret() []byte {
+	fingerprintSecretOnce.Do(func() {
+		if value := os.Getenv("VISITOR_SECRET"); value != "" {
+			fingerprintSecret = []byte(value)
+			return
+		}
+
+		fingerprintSecret = make([]byte, 32)
+		if _, err := rand.Read(fingerprintSecret); err != nil {
+			panic(err)
+		}
+	})
+
+	return fingerprintSecret
+}
+
+// Fingerprint : Returns keyed hash of visitor's IP and User-Agent.
+// It is used only to count unique visitors and can not be reversed without the secret
+func Fingerprint(info Info) uint64 {
+	mac := hmac.New(sha256.New, secret())
+	mac.Write([]byte(info.IP.String()))
+	mac.Write([]byte{0})
+	mac.Write([]byte(info.UserAgent))
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package visitor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Locator : Source of visitors' countries
+type Locator interface {
+	// Country returns ISO 3166-1 alpha-2 code of the visitor's country or empty string if it is unknown
+	Country(r *http.Request, ip net.IP) string
+}
+
+// Geo : Locator used for short link visitors, nil if countries are not detected
+var Geo Locator
+
+// HeaderLocator : Takes country from the header set by a CDN or a reverse proxy (e.g. CF-IPCountry)
+type HeaderLocator struct {
+	Header string
+}
+
+// Country : Returns country from the header
+func (l HeaderLocator) Country(r *http.Request, ip net.IP) string {
+	country := strings.TrimSpace(r.Header.Get(l.Header))
+	if len(country) != 2 {
+		return ""
+	}
+
+	return country
+}
+
+type ipRange struct {
+	from    net.IP
+	to      net.IP
+	country string
+}
+
+// RangeLocator : Finds country by IP in ranges loaded from a local CSV file with "first IP,last IP,country" lines
+type RangeLocator struct {
+	ranges []ipRange
+}
+
+// NewRangeLocator : Loads IP ranges from the CSV file
+func NewRangeLocator(path string) (*RangeLocator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	locator := &RangeLocator{}
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, errors.New("Invalid IP range: " + strings.Join(record, ","))
+		}
+
+		from := net.ParseIP(strings.TrimSpace(record[0]))
+		to := net.ParseIP(strings.TrimSpace(record[1]))
+		if from == nil || to == nil {
+			return nil, errors.New("Invalid IP range: " + strings.Join(record, ","))
+		}
+
+		locator.ranges = append(locator.ranges, ipRange{from: from.To16(), to: to.To16(), country: strings.TrimSpace(record[2])})
+	}
+
+	return locator, nil
+}
+
+// Country : Returns country of the first range containing ip
+func (l *RangeLocator) Country(r *http.Request, ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	ip = ip.To16()
+	for _, item := range l.ranges {
+		if bytes.Compare(ip, item.from) >= 0 && bytes.Compare(ip, item.to) <= 0 {
+			return item.country
+		}
+	}
+
+	return ""
+}
+
+// ChainLocator : Asks locators one by one until the country is found
+type ChainLocator []Locator
+
+// Country : Returns first found country
+func (l ChainLocator) Country(r *http.Request, ip net.IP) string {
+	for _, locator := range l {
+		if country := locator.Country(r, ip); country != "" {
+			return country
+		}
+	}
+
+	return ""
+}
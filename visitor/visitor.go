@@ -0,0 +1,119 @@
+// Package visitor extracts information about visitors of short links from requests
+package visitor
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device types
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+)
+
+// Info : What is known about the visitor
+type Info struct {
+	IP        net.IP
+	UserAgent string
+	Device    string
+	Country   string
+	// Languages from Accept-Language in order of preference, e.g. ["de-at", "de", "en"]
+	Languages []string
+	Time      time.Time
+	// Request was sent by a crawler, link checker or health probe
+	Bot bool
+}
+
+// FromRequest : Collects visitor information from the request sent from the ip
+func FromRequest(r *http.Request, ip string) Info {
+	info := Info{
+		IP:        net.ParseIP(ip),
+		UserAgent: r.UserAgent(),
+		Languages: ParseAcceptLanguage(r.Header.Get("Accept-Language")),
+		Time:      time.Now(),
+	}
+	info.Device = DetectDevice(info.UserAgent)
+	info.Bot = IsBotRequest(r, info.IP)
+
+	if Geo != nil {
+		info.Country = strings.ToUpper(Geo.Country(r, info.IP))
+	}
+
+	return info
+}
+
+// DetectDevice : Returns device type by the User-Agent
+func DetectDevice(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "iPod"):
+		return DeviceIOS
+	case strings.Contains(userAgent, "Android"):
+		return DeviceAndroid
+	case strings.Contains(userAgent, "Mobile"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// IsMobile : Checks if device type is a mobile one
+func IsMobile(device string) bool {
+	return device == DeviceIOS || device == DeviceAndroid || device == DeviceMobile
+}
+
+// ParseAcceptLanguage : Returns lower cased language tags from Accept-Language header sorted by quality
+func ParseAcceptLanguage(header string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	var languages []language
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+
+		if quality > 0 {
+			languages = append(languages, language{tag: tag, quality: quality})
+		}
+	}
+
+	sort.SliceStable(languages, func(left, right int) bool {
+		return languages[left].quality > languages[right].quality
+	})
+
+	tags := make([]string, len(languages))
+	for i, item := range languages {
+		tags[i] = item.tag
+	}
+
+	return tags
+}
+
+// MatchesLanguage : Checks if language tag is the same as the expected one or is its subtag ("de-at" matches "de")
+func MatchesLanguage(tag, expected string) bool {
+	tag = strings.ToLower(tag)
+	expected = strings.ToLower(strings.TrimSpace(expected))
+
+	return expected != "" && (tag == expected || strings.HasPrefix(tag, expected+"-"))
+}
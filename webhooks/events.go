@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"shorts/database"
+	"shorts/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// NotifyExpiredLinks : Queues "link.expired" event for links that expired since the last check
+func NotifyExpiredLinks() error {
+	var shortlinks []models.Shortlink
+	if err := database.DB.Where("expires_at <= ? AND expired_notified = ?", time.Now(), false).Find(&shortlinks).Error; err != nil {
+		return err
+	}
+
+	for _, shortlink := range shortlinks {
+		// Only the worker that marked the link sends the event
+		dbc := database.DB.Model(&models.Shortlink{}).Where("id = ? AND expired_notified = ?", shortlink.ID, false).UpdateColumn("expired_notified", true)
+		if dbc.Error != nil {
+			return dbc.Error
+		}
+		if dbc.RowsAffected == 1 {
+			Notify(shortlink.OwnerID, models.EventLinkExpired, ExpiredData{Link: shortlink.ResponseData(), ExpiresAt: *shortlink.ExpiresAt})
+		}
+	}
+
+	return nil
+}
+
+// clickedLinks : IDs of links clicked since the last check of click thresholds
+var clickedLinks = struct {
+	sync.Mutex
+	ids map[uint64]struct{}
+}{ids: make(map[uint64]struct{})}
+
+// MarkClicked : Remembers the clicked link, its click thresholds are checked by the worker (see CheckClickedLinks),
+// so redirects do not wait for counting of uses
+func MarkClicked(linkID uint64) {
+	clickedLinks.Lock()
+	clickedLinks.ids[linkID] = struct{}{}
+	clickedLinks.Unlock()
+}
+
+// CheckClickedLinks : Queues "link.clicks_threshold" events of links clicked since the last check
+func CheckClickedLinks() error {
+	clickedLinks.Lock()
+	ids := clickedLinks.ids
+	clickedLinks.ids = make(map[uint64]struct{})
+	clickedLinks.Unlock()
+
+	for id := range ids {
+		var shortlink models.Shortlink
+		if err := database.DB.First(&shortlink, id).Error; err != nil {
+			// Links deleted in the meantime have nothing to report
+			if gorm.IsRecordNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+
+		checkClickThresholds(&shortlink)
+	}
+
+	return nil
+}
+
+// checkClickThresholds : Queues "link.clicks_threshold" event for thresholds the link reached, each threshold is reported once
+func checkClickThresholds(shortlink *models.Shortlink) {
+	webhooks, err := models.FindUserWebhooks(shortlink.OwnerID, models.EventLinkClicksThreshold)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var usesCount uint64
+	counted := false
+	for _, webhook := range webhooks {
+		for _, threshold := range webhook.Thresholds() {
+			if !counted {
+				var shortlinkUse models.ShortlinkUse
+				if usesCount, err = shortlinkUse.LinkUseCount(shortlink.ID, models.UsesFilter{}); err != nil {
+					fmt.Println(err)
+					return
+				}
+				counted = true
+			}
+
+			if usesCount < threshold {
+				continue
+			}
+
+			hit := models.WebhookThresholdHit{WebhookID: webhook.ID, LinkID: shortlink.ID, Threshold: threshold}
+			if err := database.DB.Create(&hit).Error; err != nil {
+				if !database.IsUniqueViolation(err) {
+					fmt.Println(err)
+				}
+				continue
+			}
+
+			if _, err := Enqueue(webhook, models.EventLinkClicksThreshold, ThresholdData{Link: shortlink.ResponseData(), Threshold: threshold, UsesCount: usesCount}); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Package webhooks queues and delivers signed notifications about link events to user-registered endpoints
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shorts/database"
+	h "shorts/helper"
+	"shorts/models"
+	"shorts/urlcheck"
+)
+
+// Headers of webhook requests
+const (
+	HeaderEvent     = "X-Shorts-Event"
+	HeaderDelivery  = "X-Shorts-Delivery"
+	HeaderTimestamp = "X-Shorts-Timestamp"
+	// HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret, "sha256=<hex>"
+	HeaderSignature = "X-Shorts-Signature"
+)
+
+// maxBackoff : Longest delay between delivery attempts
+const maxBackoff = time.Hour
+
+// Client : HTTP client used for deliveries, it connects only to public addresses so webhooks can not reach internal services
+var Client = &http.Client{Timeout: 10 * time.Second, Transport: urlcheck.NewPublicTransport()}
+
+// claimBatch : Number of due deliveries a worker takes from the queue at once
+const claimBatch = 10
+
+// claimMargin : Time of a delivery besides its request, e.g. loading the webhook and saving the attempt
+const claimMargin = 5 * time.Second
+
+// claimTimeout : Claimed deliveries are not taken by other workers for this time, deliveries of a stopped worker are retried after it.
+// The whole batch has to be sent within it, otherwise other workers would send its last deliveries again
+func claimTimeout() time.Duration {
+	timeout := Client.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	return claimBatch * (timeout + claimMargin)
+}
+
+// Payload : JSON body sent to webhooks
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// ThresholdData : Data of "link.clicks_threshold" event
+type ThresholdData struct {
+	Link      models.ShortlinkResponseData `json:"link"`
+	Threshold uint64                       `json:"threshold"`
+	UsesCount uint64                       `json:"usesCount"`
+}
+
+// ExpiredData : Data of "link.expired" event
+type ExpiredData struct {
+	Link      models.ShortlinkResponseData `json:"link"`
+	ExpiresAt time.Time                    `json:"expiresAt"`
+}
+
+// GenerateSecret : Returns random secret for signing payloads
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// Sign : Returns signature of the body sent at the time (unix seconds)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MaxAttempts : Number of attempts before delivery is marked failed (WEBHOOK_MAX_ATTEMPTS, default 8)
+func MaxAttempts() int {
+	if attempts, err := strconv.Atoi(h.GetEnv("WEBHOOK_MAX_ATTEMPTS", "")); err == nil && attempts > 0 {
+		return attempts
+	}
+
+	return 8
+}
+
+// Backoff : Delay after the failed attempt, doubled after every attempt starting from WEBHOOK_RETRY_SECONDS (default 30)
+func Backoff(attempt int) time.Duration {
+	base := 30 * time.Second
+	if seconds, err := strconv.Atoi(h.GetEnv("WEBHOOK_RETRY_SECONDS", "")); err == nil && seconds >= 0 {
+		base = time.Duration(seconds) * time.Second
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+// Notify : Queues the event for all webhooks of the user subscribed to it, errors are only logged
+func Notify(userID uint64, event string, data interface{}) {
+	webhooks, err := models.FindUserWebhooks(userID, event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if _, err := Enqueue(webhook, event, data); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// Enqueue : Adds delivery of the event to the queue of the webhook
+func Enqueue(webhook models.Webhook, event string, data interface{}) (*models.WebhookDelivery, error) {
+	payload, err := json.Marshal(Payload{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		Event:         event,
+		Payload:       string(payload),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := database.DB.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// Attempt : Sends the delivery to the webhook and updates its status, attempts and log (without saving)
+func Attempt(webhook models.Webhook, delivery *models.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+
+	if err := send(webhook, delivery, now); err != nil {
+		delivery.Error = err.Error()
+		if delivery.Attempts >= MaxAttempts() {
+			delivery.Status = models.DeliveryFailed
+		} else {
+			delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+		}
+		return
+	}
+
+	delivery.Status = models.DeliveryDelivered
+}
+
+func send(webhook models.Webhook, delivery *models.WebhookDelivery, now time.Time) error {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Shorts-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, now.Unix(), body))
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	delivery.ResponseStatus = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// DeliverDue : Makes attempts of all pending deliveries that are due, returns number of attempts.
+// Deliveries are claimed in short transactions before they are sent, so several workers can share the queue
+func DeliverDue() (int, error) {
+	attempts := 0
+	for {
+		deliveries, err := claimDue()
+		if err != nil || len(deliveries) == 0 {
+			return attempts, err
+		}
+
+		for _, delivery := range deliveries {
+			var webhook models.Webhook
+			if err := database.DB.First(&webhook, delivery.WebhookID).Error; err != nil {
+				// Webhook was deleted
+				delivery.Status = models.DeliveryFailed
+				delivery.Error = err.Error()
+			} else {
+				Attempt(webhook, &delivery)
+			}
+			attempts++
+
+			if err := database.DB.Save(&delivery).Error; err != nil {
+				return attempts, err
+			}
+		}
+	}
+}
+
+// claimDue : Locks due deliveries and postpones them by claimTimeout, so other workers skip them while they are sent
+func claimDue() ([]models.WebhookDelivery, error) {
+	tx := database.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now()).
+		Order("next_attempt_at").Limit(claimBatch).Find(&deliveries).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	ids := make([]uint64, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	if err := tx.Model(&models.WebhookDelivery{}).Where("id IN (?)", ids).Update("next_attempt_at", time.Now().Add(claimTimeout())).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return deliveries, tx.Commit().Error
+}
+
+// Fire : Queues the event for the webhook and makes the first attempt immediately
+func Fire(webhook models.Webhook, event string, data interface{}) (*models.WebhookDelivery, error) {
+	delivery, err := Enqueue(webhook, event, data)
+	if err != nil {
+		return nil, err
+	}
+
+	Attempt(webhook, delivery)
+	if err := database.DB.Save(delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// Start : Runs worker delivering queued payloads and reporting expired links and click thresholds every interval
+func Start(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := NotifyExpiredLinks(); err != nil {
+				fmt.Println(err)
+			}
+			if err := CheckClickedLinks(); err != nil {
+				fmt.Println(err)
+			}
+			if _, err := DeliverDue(); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+}